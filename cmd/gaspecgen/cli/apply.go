@@ -1,148 +1,341 @@
 package cli
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/NiclasZi/gaspecgen/db"
+	"github.com/NiclasZi/gaspecgen/internal/audit"
 	"github.com/NiclasZi/gaspecgen/pkg/generator"
 	"github.com/NiclasZi/gaspecgen/pkg/loader"
+	"github.com/NiclasZi/gaspecgen/pkg/preprocess"
 	"github.com/NiclasZi/gaspecgen/pkg/renderer"
+	"github.com/Phillezi/common/interrupt"
+	"github.com/Phillezi/common/utils/or"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
+// stdio is the path meaning stdin for input and stdout for output
+const stdio = "-"
+
 var applyCmd = &cobra.Command{
 	Use:   "apply [template.sql]",
 	Short: "Apply template SQL file",
 	Args:  cobra.ExactArgs(1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindTemplateFlags(cmd)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		templatePath := args[0]
-		dataPath := viper.GetString("input")
 
 		sqlBytes, err := os.ReadFile(templatePath)
 		if err != nil {
 			zap.L().Fatal("Failed to read SQL template", zap.Error(err))
 		}
 
-		loaderOpts := loader.LoadOpts{
-			Sheet:      viper.GetString("sheet-name"),
-			SheetIndex: viper.GetInt("sheet-index"),
-		}
+		executeTemplate(templateRun{template: string(sqlBytes), file: templatePath})
+	},
+}
+
+// templateRun is a template to render and run with the input and output
+// flags shared by apply and run.
+type templateRun struct {
+	template string
+	vars     map[string]string
+	// name is the approved query and file the template file, for the audit
+	// log
+	name, file string
+	// connection overrides the selected connection profile
+	connection string
+	// checkInput validates if input data was given, may be nil
+	checkInput func(hasValues bool) error
+	// outputFormat is used when neither --output nor --output-format is set
+	outputFormat string
+}
 
-		r := renderer.NewGoTemplateRenderer()
-		var query string
+// executeTemplate loads the input, renders and runs the template and writes
+// the results, any failure is fatal.
+func executeTemplate(t templateRun) {
+	dataPath := viper.GetString("input")
 
-		if dataPath != "" {
-			ld, err := loader.GetLoader(dataPath, loaderOpts)
-			if err != nil {
-				zap.L().Fatal("Failed to get loader", zap.Error(err))
-			}
+	loaderOpts := loader.LoadOpts{
+		Format:     viper.GetString("input-format"),
+		Sheet:      viper.GetString(deprecatedKey("sheet-name-in", "sheet-name")),
+		SheetIndex: viper.GetInt(deprecatedKey("sheet-index-in", "sheet-index")),
+		JSONPath:   viper.GetString("json-path"),
 
-			dataRows, err := ld.Load(dataPath)
-			if err != nil {
-				zap.L().Fatal("Failed to load input data", zap.Error(err))
-			}
+		HeaderRow:     viper.GetInt("header-row"),
+		Range:         viper.GetString("range"),
+		Table:         viper.GetString("table"),
+		SkipBlankRows: viper.GetBool("skip-blank-rows"),
+		RawValues:     viper.GetBool("raw-values"),
+		FillMerged:    viper.GetBool("fill-merged"),
 
-			q, err := r.Render(string(sqlBytes), *renderer.FromMapArr(dataRows))
-			if err != nil {
-				zap.L().Fatal("Failed to render sql query with input data", zap.Error(err))
-			}
-			query = q
-		} else {
-			q, err := r.Render(string(sqlBytes), renderer.QueryData{})
-			if err != nil {
-				zap.L().Fatal("Failed to render sql query with input data", zap.Error(err))
-			}
-			query = q
+		Encoding:      viper.GetString("csv-encoding"),
+		BOM:           viper.GetString("csv-bom"),
+		SkipMalformed: viper.GetBool("csv-skip-malformed"),
+	}
+	var err error
+	if loaderOpts.Delimiter, err = loader.ParseDelimiter(viper.GetString("csv-delimiter")); err != nil {
+		zap.L().Fatal("Invalid csv delimiter", zap.Error(err))
+	}
+	headerMode, err := loader.ParseHeaderMode(viper.GetString("headers"))
+	if err != nil {
+		zap.L().Fatal("Invalid header mode", zap.Error(err))
+	}
+	loaderOpts.Headers = &loader.HeaderNormalizer{
+		Mode:    headerMode,
+		Aliases: viper.GetStringMapString("header-alias"),
+	}
+	if specPath := viper.GetString("column-spec"); specPath != "" {
+		spec, err := loader.LoadFixedWidthSpec(specPath)
+		if err != nil {
+			zap.L().Fatal("Failed to load fixed-width column spec", zap.Error(err))
 		}
+		loaderOpts.FixedWidth = spec
+	}
 
-		fmt.Println("===QUERY===")
-		fmt.Println(query)
+	if t.checkInput != nil {
+		if err := t.checkInput(dataPath != ""); err != nil {
+			zap.L().Fatal("Invalid input", zap.Error(err))
+		}
+	}
 
-		db, err := db.Get()
-		if err != nil {
-			zap.L().Fatal("Failed to connect to the database", zap.Error(err))
+	entry := audit.Entry{
+		Query:          t.name,
+		Template:       t.file,
+		TemplateSHA256: audit.Hash([]byte(t.template)),
+		Vars:           t.vars,
+	}
+	var dataRows []map[string]string
+	if dataPath != "" {
+		if dataPath == stdio && loaderOpts.Format == "" {
+			zap.L().Fatal("--input-format is required when reading input from stdin")
 		}
-		defer func() {
-			if err := db.Close(); err != nil {
-				zap.L().Fatal("Failed to close the database connection", zap.Error(err))
-			}
-		}()
 
-		stmt, err := db.GetConnection().Prepare(query)
+		ld, err := loader.GetLoader(dataPath, loaderOpts)
 		if err != nil {
-			zap.L().Fatal("Failed to prepare query", zap.Error(err))
+			zap.L().Fatal("Failed to get loader", zap.Error(err))
 		}
-		defer stmt.Close()
 
-		rows, err := stmt.Query()
+		entry.Input = dataPath
+		if dataPath == stdio {
+			// hash stdin while it is read, it can not be read again
+			h := sha256.New()
+			in := io.TeeReader(os.Stdin, h)
+			if dataRows, err = ld.LoadIO(in); err == nil {
+				_, err = io.Copy(io.Discard, in)
+			}
+			entry.InputSHA256 = hex.EncodeToString(h.Sum(nil))
+		} else {
+			dataRows, err = ld.Load(dataPath)
+		}
 		if err != nil {
-			zap.L().Fatal("Query execution failed", zap.Error(err))
+			zap.L().Fatal("Failed to load input data", zap.Error(err))
+		}
+		if dataPath != stdio {
+			if entry.InputSHA256, err = audit.HashFile(dataPath); err != nil {
+				zap.L().Fatal("Failed to hash input data", zap.Error(err))
+			}
 		}
-		defer rows.Close()
 
-		g, err := generator.GetGenerator(viper.GetString("output"), generator.GenerationOptions{
-			SheetName: viper.GetString("sheet"),
-		})
+		spec, err := preprocessSpec()
 		if err != nil {
-			zap.L().Fatal("Failed to get generator", zap.Error(err))
+			zap.L().Fatal("Failed to load preprocessing spec", zap.Error(err))
 		}
+		if dataRows, err = spec.Apply(dataRows); err != nil {
+			zap.L().Fatal("Failed to preprocess input data", zap.Error(err))
+		}
+	}
+
+	data := *renderer.FromMapArr(dataRows)
+	data.Vars = t.vars
+	query, err := renderer.NewGoTemplateRenderer().Render(t.template, data)
+	if err != nil {
+		zap.L().Fatal("Failed to render sql query with input data", zap.Error(err))
+	}
 
-		columns, err := rows.Columns()
+	executeQuery(query, or.Or(t.connection, db.SelectedProfile()), t.outputFormat, entry)
+}
+
+// executeQuery runs the rendered query on the connection, records it in the
+// audit log with the template details of entry and writes the results, any
+// failure is fatal.
+func executeQuery(query, connection, defaultFormat string, entry audit.Entry) {
+	log, err := openAudit()
+	if err != nil {
+		zap.L().Fatal("Failed to open the audit log, set --audit-log or --disable-audit", zap.Error(err))
+	}
+	entry.User, entry.Source, entry.Connection, entry.SQL = audit.OSUser(), audit.SourceCLI, connection, query
+	record := func(started time.Time, res *db.Result, err error) {
+		if log == nil {
+			return
+		}
+		entry.Time, entry.DurationMs = started, time.Since(started).Milliseconds()
+		if res != nil {
+			entry.RowCounts, entry.Cached = []int{len(res.Rows)}, !res.CachedAt.IsZero()
+		}
 		if err != nil {
-			zap.L().Fatal("Failed to get columns", zap.Error(err))
+			entry.Error = err.Error()
 		}
+		if err := log.Append(entry); err != nil {
+			zap.L().Error("Failed to write the audit log", zap.Error(err))
+		}
+	}
 
-		var results []map[string]string
-		for rows.Next() {
-			values := make([]any, len(columns))
-			valuePtrs := make([]any, len(columns))
-			for i := range values {
-				valuePtrs[i] = &values[i]
-			}
+	outputPath := viper.GetString("output")
+	outputFormat := viper.GetString("output-format")
+	if outputPath == "" && outputFormat == "" {
+		outputFormat = defaultFormat
+	}
+	switch {
+	case outputPath == stdio && outputFormat == "":
+		outputFormat = "csv"
+	case outputPath == "" && outputFormat != "":
+		outputPath = stdio
+	}
 
-			if err := rows.Scan(valuePtrs...); err != nil {
-				zap.L().Fatal("Failed to scan row", zap.Error(err))
-			}
+	// keep stdout clean for the results when writing them there
+	queryOut := os.Stdout
+	if outputPath == stdio {
+		queryOut = os.Stderr
+	}
+	fmt.Fprintln(queryOut, "===QUERY===")
+	fmt.Fprintln(queryOut, query)
 
-			rowMap := make(map[string]string)
-			for i, col := range columns {
-				var val string
-				if b, ok := values[i].([]byte); ok {
-					val = string(b)
-				} else if values[i] != nil {
-					val = fmt.Sprintf("%v", values[i])
-				} else {
-					val = ""
-				}
-				rowMap[col] = val
-			}
-			results = append(results, rowMap)
+	ctx := interrupt.GetInstance().Context()
+	started := time.Now()
+	e, err := db.OpenNamed(ctx, connection)
+	if err != nil {
+		record(started, nil, err)
+		zap.L().Fatal("Failed to connect to the database", zap.Error(err))
+	}
+	defer func() {
+		if err := e.Close(); err != nil {
+			zap.L().Fatal("Failed to close the database connection", zap.Error(err))
 		}
+	}()
 
-		if err := g.Generate(results); err != nil {
-			zap.L().Fatal("Failed to generate output", zap.Error(err))
-		} else {
-			zap.L().Info("Done!")
-		}
+	res, err := e.Query(ctx, query)
+	record(started, res, err)
+	if err != nil {
+		zap.L().Fatal("Query execution failed", zap.Error(err))
+	}
+	results := res.Rows
 
-	},
+	g, err := generator.GetGenerator(outputPath, generator.GenerationOptions{
+		Format:    outputFormat,
+		SheetName: viper.GetString("sheet"),
+		SQLTable:  viper.GetString("sql-table"),
+		SQLMode:   viper.GetString("sql-mode"),
+		SQLKeys:   strings.Join(viper.GetStringSlice("sql-keys"), ","),
+	})
+	if err != nil {
+		zap.L().Fatal("Failed to get generator", zap.Error(err))
+	}
+	generator.SetTyped(g, generator.Typed{Types: res.TypeMap(), Nulls: res.Nulls})
+
+	if outputPath == stdio {
+		err = g.GenerateIO(os.Stdout, results)
+	} else {
+		err = g.Generate(results)
+	}
+	if err != nil {
+		zap.L().Fatal("Failed to generate output", zap.Error(err))
+	} else {
+		zap.L().Info("Done!")
+	}
 }
 
-func init() {
-	applyCmd.Flags().StringP("input", "i", "", "CSV or XLSX file to inject values from")
-	applyCmd.Flags().StringP("output", "o", "", "Output file path for results (not implemented yet)")
-	applyCmd.Flags().IntP("sheet-index-in", "s", 0, "Sheet index to get values from (only applies when using xlsx input), zero indexed so first is 0")
-	applyCmd.Flags().StringP("sheet-name-in", "S", "", "Sheet name to get values from (only applies when using xlsx input), takes priority over sheet-index-in")
-	applyCmd.Flags().String("sheet", "", "Sheet name to output result to (only applies when using xlsx output)")
-
-	viper.BindPFlag("input", applyCmd.Flags().Lookup("input"))
-	viper.BindPFlag("output", applyCmd.Flags().Lookup("output"))
-	viper.BindPFlag("sheet-index-in", applyCmd.Flags().Lookup("sheet-index-in"))
-	viper.BindPFlag("sheet-name-in", applyCmd.Flags().Lookup("sheet-name-in"))
-	viper.BindPFlag("sheet", applyCmd.Flags().Lookup("sheet"))
+// deprecatedKey returns the old config key when only it is set, config
+// files written before the input sheet keys got their -in suffix keep working.
+func deprecatedKey(key, old string) string {
+	if !viper.IsSet(key) && viper.IsSet(old) {
+		zap.L().Warn("Config key is deprecated", zap.String("key", old), zap.String("use", key))
+		return old
+	}
+	return key
+}
 
+func init() {
+	addTemplateFlags(applyCmd)
 	rootCmd.AddCommand(applyCmd)
 }
+
+// addTemplateFlags adds the input, preprocessing and output flags.
+func addTemplateFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("input", "i", "", "CSV, TSV, XLSX, JSON, NDJSON or fixed-width file to inject values from, - reads from stdin")
+	cmd.Flags().String("input-format", "", "Format of the input, csv, tsv, xlsx, json, ndjson or fixed-width, required when reading from stdin")
+	cmd.Flags().IntP("sheet-index-in", "s", 0, "Sheet index to get values from (only applies when using xlsx input), zero indexed so first is 0")
+	cmd.Flags().StringP("sheet-name-in", "S", "", "Sheet name to get values from (only applies when using xlsx input), takes priority over sheet-index-in")
+	cmd.Flags().Int("header-row", 0, "Row number (1 based) of the header row (only applies when using xlsx input), defaults to the first row of the range")
+	cmd.Flags().String("range", "", "Cell range to read, e.g. A5:H400 (only applies when using xlsx input)")
+	cmd.Flags().String("table", "", "Name of an Excel table to read, takes priority over the sheet and range (only applies when using xlsx input)")
+	cmd.Flags().Bool("skip-blank-rows", false, "Skip rows where every cell is empty (only applies when using xlsx input)")
+	cmd.Flags().Bool("raw-values", false, "Read unformatted cell values instead of the displayed text (only applies when using xlsx input)")
+	cmd.Flags().Bool("fill-merged", false, "Repeat the value of merged cells in every cell they cover (only applies when using xlsx input)")
+	cmd.Flags().String("json-path", "", "Dot separated path to the array of objects (only applies when using json input), e.g. data.items")
+	cmd.Flags().String("column-spec", "", "YAML column spec file (required when using fixed-width .txt, .fwf or .prn input)")
+	cmd.Flags().String("csv-delimiter", "auto", "Field delimiter for csv input, a single character, tab, comma, semicolon, pipe or auto to sniff it")
+	cmd.Flags().String("csv-encoding", loader.EncodingAuto, "Encoding of csv and fixed-width input, e.g. utf-8, windows-1252, utf-16le or auto to detect it")
+	cmd.Flags().String("csv-bom", loader.BOMAuto, "Byte order mark handling for csv and fixed-width input, auto (strip and use it to detect the encoding) or ignore")
+	cmd.Flags().Bool("csv-skip-malformed", false, "Skip malformed csv lines with a warning instead of failing")
+	cmd.Flags().String("headers", "", "How input headers are turned into template keys, as-is, lower-camel, snake or upper, defaults to lower-camel for xlsx and as-is for other input")
+	cmd.Flags().StringToString("header-alias", nil, "Map input headers to template keys, e.g. --header-alias \"Art. nr=artNr,Antal=qty\"")
+	cmd.Flags().String("preprocess", "", "YAML file declaring filter, dedupe and group-by steps to run on the input before rendering")
+	cmd.Flags().String("filter", "", "Only keep input rows matching the expression, e.g. 'qty > 0 && artNr != \"\"'")
+	cmd.Flags().StringSlice("dedupe", nil, "Drop input rows with the same values in these columns, keeping the first")
+	cmd.Flags().StringSlice("group-by", nil, "Collapse input rows with the same values in these columns into one row")
+	cmd.Flags().StringToString("aggregate", nil, "Aggregation per column when grouping (sum, min, max, count, concat, concat-distinct, first, last), e.g. qty=sum,refDesignator=concat")
+	addOutputFlags(cmd)
+}
+
+// addOutputFlags adds the flags for writing the results.
+func addOutputFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("output", "o", "", "Output file path for results (.csv, .xlsx or .sql), - writes to stdout, prints a table to stdout when empty")
+	cmd.Flags().String("output-format", "", "Format of the output, csv, xlsx, sql or table, defaults to csv when writing to stdout")
+	cmd.Flags().String("sheet", "", "Sheet name to output result to (only applies when using xlsx output)")
+	cmd.Flags().String("sql-table", "", "Target table for the generated statements (only applies when using sql output), defaults to #Results")
+	cmd.Flags().String("sql-mode", generator.SQLModeInsert, "Statement type to generate, insert or merge (only applies when using sql output)")
+	cmd.Flags().StringSlice("sql-keys", nil, "Key columns to match on when using merge (only applies when using sql output)")
+}
+
+// bindTemplateFlags binds the flags of the command that is about to run, the
+// same keys are shared by several commands so they can not be bound in init.
+func bindTemplateFlags(cmd *cobra.Command) {
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		viper.BindPFlag(f.Name, f)
+	})
+}
+
+// preprocessSpec loads the spec file if given, the preprocessing flags override its steps.
+func preprocessSpec() (*preprocess.Spec, error) {
+	spec := &preprocess.Spec{}
+	if path := viper.GetString("preprocess"); path != "" {
+		var err error
+		if spec, err = preprocess.LoadSpec(path); err != nil {
+			return nil, err
+		}
+	}
+	if filter := viper.GetString("filter"); filter != "" {
+		spec.Filter = filter
+	}
+	if dedupe := viper.GetStringSlice("dedupe"); len(dedupe) > 0 {
+		spec.Dedupe = dedupe
+	}
+	if groupBy := viper.GetStringSlice("group-by"); len(groupBy) > 0 {
+		spec.GroupBy = groupBy
+	}
+	if aggregate := viper.GetStringMapString("aggregate"); len(aggregate) > 0 {
+		spec.Aggregate = aggregate
+	}
+	return spec, nil
+}
@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/NiclasZi/gaspecgen/db"
+	"github.com/Phillezi/common/interrupt"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var diagnoseCmd = &cobra.Command{
+	Use:     "diagnose",
+	Aliases: []string{"diag"},
+	Short:   "Connect to the selected connection and show TLS and session details",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		d, err := db.Diagnose(interrupt.GetInstance().Context(), db.SelectedProfile())
+		if err != nil {
+			zap.L().Fatal("Failed to diagnose connection", zap.Error(err))
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		row := func(k string, v any) { fmt.Fprintf(w, "%s\t%v\n", k, v) }
+
+		row("Connection", d.Info.Name)
+		row("Host", d.Info.Host)
+		row("Database", d.Info.Database)
+		row("Auth", d.Info.Auth)
+		row("Driver", d.Driver)
+		row("DSN", d.DSN)
+		row("Encryption", d.Encryption)
+
+		if t := d.TLS; t != nil && t.Version != "" {
+			row("TLS version", t.Version)
+			row("Cipher suite", t.CipherSuite)
+			row("Expected host", t.ServerName)
+			switch {
+			case t.Verified:
+				row("Certificate", "trusted")
+			case t.VerifyErr != nil:
+				row("Certificate", "NOT trusted: "+t.VerifyErr.Error())
+			default:
+				row("Certificate", "not verified (db-trust-cert)")
+			}
+			for i, c := range t.Certificate {
+				prefix := fmt.Sprintf("Chain[%d]", i)
+				row(prefix+" subject", c.Subject)
+				row(prefix+" issuer", c.Issuer)
+				if len(c.DNSNames) > 0 {
+					row(prefix+" names", strings.Join(c.DNSNames, ", "))
+				}
+				row(prefix+" valid", c.NotBefore.Format("2006-01-02")+" - "+c.NotAfter.Format("2006-01-02"))
+			}
+		} else if d.TLS == nil && d.Driver != "sqlserver" {
+			row("TLS", "handshake details are not available for Azure AD auth")
+		}
+
+		row("Elapsed", d.Elapsed.Round(1e6))
+		if d.Server != nil {
+			row("Login", d.Server.Login)
+			row("Current database", d.Server.Database)
+			if d.Server.EncryptOption != "" {
+				row("Session encrypted", d.Server.EncryptOption)
+				row("Auth scheme", d.Server.AuthScheme)
+				row("Transport", d.Server.Transport)
+				row("TDS version", d.Server.ProtocolVersion)
+			}
+			row("Server", strings.Join(strings.Fields(strings.SplitN(d.Server.Version, "\n", 2)[0]), " "))
+		}
+		if d.Err != nil {
+			row("Error", d.Err)
+		}
+		w.Flush()
+
+		if d.Err != nil {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diagnoseCmd)
+}
@@ -0,0 +1,223 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/NiclasZi/gaspecgen/db"
+	"github.com/NiclasZi/gaspecgen/internal/audit"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List and search the queries recorded in the audit log, newest last",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		log := historyLog()
+		// the flags are read directly, not through viper, keys like user or
+		// connection in the config file mean something else
+		flags := cmd.Flags()
+		var filter audit.Filter
+		filter.User, _ = flags.GetString("user")
+		filter.Name, _ = flags.GetString("query")
+		filter.Text, _ = flags.GetString("search")
+		filter.Failed, _ = flags.GetBool("failed")
+		filter.Limit, _ = flags.GetInt("limit")
+		// the connection set in the config file selects a profile, only
+		// filter when it is given here
+		if flags.Changed("connection") {
+			filter.Connection, _ = flags.GetString("connection")
+		}
+		if raw, _ := flags.GetString("since"); raw != "" {
+			since, err := parseSince(raw)
+			if err != nil {
+				zap.L().Fatal("Invalid --since", zap.Error(err))
+			}
+			filter.Since = since
+		}
+
+		entries, err := log.List(filter)
+		if err != nil {
+			zap.L().Fatal("Failed to read the audit log", zap.Error(err))
+		}
+		if asJSON, _ := flags.GetBool("json"); asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			for _, e := range entries {
+				enc.Encode(e)
+			}
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tTIME\tUSER\tSOURCE\tCONNECTION\tQUERY\tROWS\tMS\tERROR")
+		for _, e := range entries {
+			ms := fmt.Sprint(e.DurationMs)
+			if e.Cached {
+				ms = "cached"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", e.ID, e.Time.Local().Format(time.DateTime), e.User, e.Source, e.Connection, e.Name(), e.Rows(), ms, firstLine(e.Error))
+		}
+		w.Flush()
+	},
+}
+
+var historyShowCmd = &cobra.Command{
+	Use:   "show [id]",
+	Short: "Show an entry of the audit log with its SQL, a unique prefix of the id is enough",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		e, err := historyLog().Get(args[0])
+		if err != nil {
+			zap.L().Fatal("Failed to get audit entry", zap.Error(err))
+		}
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(e)
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		field := func(name, value string) {
+			if value != "" {
+				fmt.Fprintf(w, "%s:\t%s\n", name, value)
+			}
+		}
+		field("ID", e.ID)
+		field("Time", e.Time.Local().Format(time.RFC3339))
+		field("User", e.User)
+		field("Source", e.Source)
+		field("Connection", e.Connection)
+		field("Query", e.Query)
+		field("Template", e.Template)
+		field("Template SHA-256", e.TemplateSHA256)
+		field("Input", e.Input)
+		field("Input SHA-256", e.InputSHA256)
+		for _, name := range slices.Sorted(maps.Keys(e.Vars)) {
+			field("Var "+name, e.Vars[name])
+		}
+		field("Rerun of", e.RerunOf)
+		field("Duration", (time.Duration(e.DurationMs) * time.Millisecond).String())
+		field("Rows", fmt.Sprint(e.RowCounts))
+		if e.Cached {
+			field("Cached", "yes, served from the result cache")
+		}
+		field("Error", e.Error)
+		w.Flush()
+		fmt.Printf("\n%s\n", e.SQL)
+	},
+}
+
+var historyRerunCmd = &cobra.Command{
+	Use:   "rerun [id]",
+	Short: "Run the recorded SQL of an audit log entry again, on its connection unless --connection is given",
+	Args:  cobra.ExactArgs(1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindTemplateFlags(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		e, err := historyLog().Get(args[0])
+		if err != nil {
+			zap.L().Fatal("Failed to get audit entry", zap.Error(err))
+		}
+		connection := e.Connection
+		if cmd.Flags().Changed("connection") {
+			connection = db.SelectedProfile()
+		}
+		fmt.Fprintf(os.Stderr, "Re-running %s by %s from %s on %s\n", e.ID, e.User, e.Time.Local().Format(time.DateTime), connection)
+
+		executeQuery(e.SQL, connection, "", audit.Entry{
+			Query:          e.Query,
+			Template:       e.Template,
+			TemplateSHA256: e.TemplateSHA256,
+			Input:          e.Input,
+			InputSHA256:    e.InputSHA256,
+			Vars:           e.Vars,
+			RerunOf:        e.ID,
+		})
+	},
+}
+
+// auditPath is the audit log file, audit.jsonl in the gaspecgen user config
+// dir when --audit-log is not set.
+func auditPath() (string, error) {
+	if path := viper.GetString("audit-log"); path != "" {
+		return path, nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find a directory for the audit log: %w", err)
+	}
+	return filepath.Join(configDir, "gaspecgen", "audit.jsonl"), nil
+}
+
+// openAudit opens the audit log executed queries are recorded in, nil when
+// it is disabled.
+func openAudit() (*audit.Log, error) {
+	if viper.GetBool("disable-audit") {
+		return nil, nil
+	}
+	path, err := auditPath()
+	if err != nil {
+		return nil, err
+	}
+	return audit.Open(path)
+}
+
+// historyLog opens the audit log for reading, also when recording is
+// disabled, any failure is fatal.
+func historyLog() *audit.Log {
+	path, err := auditPath()
+	if err != nil {
+		zap.L().Fatal("Failed to find the audit log", zap.Error(err))
+	}
+	log, err := audit.Open(path)
+	if err != nil {
+		zap.L().Fatal("Failed to open the audit log", zap.Error(err))
+	}
+	return log
+}
+
+// parseSince reads a duration back from now, e.g. 24h, or a date or time.
+func parseSince(raw string) (time.Time, error) {
+	if d, err := time.ParseDuration(raw); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateTime, time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, raw, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a duration like 24h nor a date like 2006-01-02", raw)
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+func init() {
+	historyCmd.Flags().String("user", "", "Only list queries run by this user, the OS user for the CLI and method:name for the server")
+	historyCmd.Flags().String("query", "", "Only list runs of this approved query or template file")
+	historyCmd.Flags().String("search", "", "Only list queries whose SQL contains this text, case-insensitively")
+	historyCmd.Flags().String("since", "", "Only list queries run within this duration, e.g. 24h, or since this date, e.g. 2006-01-02")
+	historyCmd.Flags().Bool("failed", false, "Only list queries that failed")
+	historyCmd.Flags().Int("limit", 20, "List this many of the newest matching queries, 0 lists all")
+	historyCmd.Flags().Bool("json", false, "Print the entries as JSON lines")
+
+	historyShowCmd.Flags().Bool("json", false, "Print the entry as JSON")
+
+	addOutputFlags(historyRerunCmd)
+
+	historyCmd.AddCommand(historyShowCmd, historyRerunCmd)
+	rootCmd.AddCommand(historyCmd)
+}
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/NiclasZi/gaspecgen/db"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var keyringCmd = &cobra.Command{
+	Use:   "keyring",
+	Short: "Manage DB passwords stored in the OS keyring",
+}
+
+var keyringSetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Prompt for the password of the selected connection and store it in the OS keyring",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := db.ConfigFor(db.SelectedProfile())
+		if err != nil {
+			zap.L().Fatal("Failed to resolve connection", zap.Error(err))
+		}
+		password, err := db.PromptPassword(fmt.Sprintf("Password for %s (%s): ", cfg.KeyringAccount(), cfg.Name))
+		if err != nil {
+			zap.L().Fatal("Failed to read password", zap.Error(err))
+		}
+		if err := cfg.SetKeyringPassword(password); err != nil {
+			zap.L().Fatal("Failed to store password in the keyring", zap.Error(err))
+		}
+		fmt.Fprintf(os.Stderr, "Stored password for %s, use --db-keyring or keyring: true in the profile to use it\n", cfg.KeyringAccount())
+	},
+}
+
+var keyringDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Remove the password of the selected connection from the OS keyring",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := db.ConfigFor(db.SelectedProfile())
+		if err != nil {
+			zap.L().Fatal("Failed to resolve connection", zap.Error(err))
+		}
+		if err := cfg.DeleteKeyringPassword(); err != nil {
+			zap.L().Fatal("Failed to delete password from the keyring", zap.Error(err))
+		}
+	},
+}
+
+func init() {
+	keyringCmd.AddCommand(keyringSetCmd)
+	keyringCmd.AddCommand(keyringDeleteCmd)
+	rootCmd.AddCommand(keyringCmd)
+}
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/NiclasZi/gaspecgen/pkg/repository"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run [name]",
+	Short: "Run an approved query from the query repository by name",
+	Args:  cobra.MaximumNArgs(1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		bindTemplateFlags(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		repo, err := openQueries()
+		if err != nil {
+			zap.L().Fatal("Failed to open the query repository", zap.Error(err))
+		}
+		if repo == nil {
+			zap.L().Fatal("No query repository configured, set --queries-dir")
+		}
+
+		if len(args) == 0 {
+			queries, err := repo.List()
+			if err != nil {
+				zap.L().Fatal("Failed to list queries", zap.Error(err))
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tINPUT\tCONNECTION\tTITLE")
+			for _, q := range queries {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", q.Name, q.Input, q.Connection, q.Title)
+			}
+			w.Flush()
+			return
+		}
+
+		q, err := repo.Get(args[0])
+		if err != nil {
+			zap.L().Fatal("Failed to get query", zap.Error(err))
+		}
+		vars, err := q.Vars(viper.GetStringMapString("var"))
+		if err != nil {
+			zap.L().Fatal("Invalid variables", zap.Error(err))
+		}
+
+		executeTemplate(templateRun{
+			template:     q.Template,
+			vars:         vars,
+			name:         q.Name,
+			connection:   q.Connection,
+			checkInput:   q.CheckInput,
+			outputFormat: q.Output,
+		})
+	},
+}
+
+// openQueries opens the query repository, nil when none is configured.
+func openQueries() (*repository.Repository, error) {
+	dir := viper.GetString("queries-dir")
+	if dir == "" {
+		return nil, nil
+	}
+	return repository.Open(dir)
+}
+
+func init() {
+	addTemplateFlags(runCmd)
+	runCmd.Flags().StringToString("var", nil, "Values for the variables of the query, e.g. --var plant=1000,status=open")
+	rootCmd.AddCommand(runCmd)
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/NiclasZi/gaspecgen/pkg/scaffold"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var scaffoldCmd = &cobra.Command{
+	Use:   "scaffold [template.sql]",
+	Short: "Write a blank input workbook with the columns a template reads, from its front-matter when it has one",
+	Args:  cobra.MaximumNArgs(1),
+	PreRun: func(cmd *cobra.Command, args []string) {
+		viper.BindPFlag("output", cmd.Flags().Lookup("output"))
+		viper.BindPFlag("query", cmd.Flags().Lookup("query"))
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		name := viper.GetString("query")
+		if (len(args) == 0) == (name == "") {
+			zap.L().Fatal("Pass either a template file or --query")
+		}
+
+		var template, base string
+		if name != "" {
+			repo, err := openQueries()
+			if err != nil {
+				zap.L().Fatal("Failed to open the query repository", zap.Error(err))
+			}
+			if repo == nil {
+				zap.L().Fatal("No query repository configured, set --queries-dir")
+			}
+			q, err := repo.Get(name)
+			if err != nil {
+				zap.L().Fatal("Failed to get query", zap.Error(err))
+			}
+			template, base = q.Template, q.Name
+		} else {
+			content, err := os.ReadFile(args[0])
+			if err != nil {
+				zap.L().Fatal("Failed to read template file", zap.Error(err))
+			}
+			template, base = string(content), strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0]))
+		}
+
+		spec, err := scaffold.Describe(template)
+		if err != nil {
+			zap.L().Fatal("Failed to read the template input", zap.Error(err))
+		}
+		if spec.Title == "" {
+			spec.Title = base
+		}
+
+		output := viper.GetString("output")
+		if output == "" {
+			output = base + "-input.xlsx"
+		}
+		var w io.Writer = os.Stdout
+		if output != "-" {
+			f, err := os.Create(output)
+			if err != nil {
+				zap.L().Fatal("Failed to create output file", zap.Error(err))
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := scaffold.WriteWorkbook(w, spec); err != nil {
+			zap.L().Fatal("Failed to write the input workbook", zap.Error(err))
+		}
+		if output != "-" {
+			fmt.Fprintf(os.Stderr, "Wrote %s with %d columns\n", output, len(spec.Columns))
+		}
+	},
+}
+
+func init() {
+	scaffoldCmd.Flags().StringP("output", "o", "", "Output workbook, - writes to stdout, defaults to <template>-input.xlsx")
+	scaffoldCmd.Flags().String("query", "", "Scaffold the approved query with this name from --queries-dir instead of a template file")
+	rootCmd.AddCommand(scaffoldCmd)
+}
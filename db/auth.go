@@ -0,0 +1,132 @@
+package db
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/microsoft/go-mssqldb/azuread"
+)
+
+const (
+	AuthSQL = "sql"
+	// AuthKerberos is integrated authentication with Kerberos, mostly useful on
+	// Linux and macOS where Windows SSPI is not available.
+	AuthKerberos = "krb5"
+
+	AuthADDefault          = azuread.ActiveDirectoryDefault
+	AuthADPassword         = azuread.ActiveDirectoryPassword
+	AuthADServicePrincipal = azuread.ActiveDirectoryServicePrincipal
+	AuthADManagedIdentity  = azuread.ActiveDirectoryManagedIdentity
+	AuthADInteractive      = azuread.ActiveDirectoryInteractive
+)
+
+// AuthModes lists the supported values for the auth setting.
+var AuthModes = []string{
+	AuthSQL,
+	AuthKerberos,
+	AuthADDefault,
+	AuthADPassword,
+	AuthADServicePrincipal,
+	AuthADManagedIdentity,
+	AuthADInteractive,
+}
+
+// normalizeAuth returns the canonical spelling of an auth mode.
+func normalizeAuth(mode string) (string, error) {
+	if mode == "" {
+		return AuthSQL, nil
+	}
+	for _, m := range AuthModes {
+		if strings.EqualFold(m, mode) {
+			return m, nil
+		}
+	}
+	if strings.EqualFold(mode, "kerberos") {
+		return AuthKerberos, nil
+	}
+	return "", fmt.Errorf("unsupported auth mode %q, expected one of %s", mode, strings.Join(AuthModes, ", "))
+}
+
+func isAzureAD(mode string) bool {
+	return strings.HasPrefix(mode, "ActiveDirectory")
+}
+
+// driverName returns the database/sql driver to use for the auth mode,
+// the azuresql driver wraps sqlserver and acquires Azure AD tokens.
+func (c *Config) driverName() string {
+	if isAzureAD(c.Auth) || strings.Contains(strings.ToLower(c.DSN), "fedauth=") {
+		return azuread.DriverName
+	}
+	return "sqlserver"
+}
+
+// authParams returns the connection string parameters for the auth mode and
+// the credentials to put in the connection string, nil for none.
+func (c *Config) authParams() (map[string]string, *url.Userinfo, error) {
+	params := map[string]string{}
+	switch c.Auth {
+	case AuthSQL:
+		return params, c.userPassword(), nil
+	case AuthKerberos:
+		params["authenticator"] = "krb5"
+		if c.Krb5Conf != "" {
+			params["krb5-configfile"] = c.Krb5Conf
+		}
+		if c.Krb5Realm != "" {
+			params["krb5-realm"] = c.Krb5Realm
+		}
+		if c.Keytab != "" {
+			// a password would make the driver log in with it instead of the keytab
+			params["krb5-keytabfile"] = c.Keytab
+			if c.User == "" {
+				return nil, nil, fmt.Errorf("%s with a keytab requires a user", c.Auth)
+			}
+			return params, url.User(c.User), nil
+		}
+		// without a user the credential cache (KRB5CCNAME or kinit) is used
+		return params, c.userPassword(), nil
+	case AuthADDefault:
+		params["fedauth"] = c.Auth
+		return params, nil, nil
+	case AuthADManagedIdentity:
+		params["fedauth"] = c.Auth
+		// the user is the optional client id of a user assigned identity
+		if c.User != "" {
+			return params, url.User(c.User), nil
+		}
+		return params, nil, nil
+	case AuthADPassword, AuthADInteractive:
+		if c.AppClientID == "" {
+			return nil, nil, fmt.Errorf("%s requires an application client id (db-app-client-id)", c.Auth)
+		}
+		params["fedauth"] = c.Auth
+		params["applicationclientid"] = c.AppClientID
+		if c.Auth == AuthADInteractive {
+			// the user is only a login hint for the browser
+			if c.User != "" {
+				return params, url.User(c.User), nil
+			}
+			return params, nil, nil
+		}
+		return params, c.userPassword(), nil
+	case AuthADServicePrincipal:
+		if c.User == "" {
+			return nil, nil, fmt.Errorf("%s requires the client id (optionally client-id@tenant-id) as the user", c.Auth)
+		}
+		params["fedauth"] = c.Auth
+		return params, c.userPassword(), nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported auth mode %q for connection %q", c.Auth, c.Name)
+	}
+}
+
+func (c *Config) userPassword() *url.Userinfo {
+	if c.User == "" {
+		return nil
+	}
+	if c.Password == "" {
+		return url.User(c.User)
+	}
+	return url.UserPassword(c.User, c.Password)
+}
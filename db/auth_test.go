@@ -0,0 +1,64 @@
+package db
+
+import "testing"
+
+func TestAuthParamsUser(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		want    string
+		wantErr bool
+	}{
+		{"sql", Config{Auth: AuthSQL, User: "sa", Password: "secret"}, "sa:secret", false},
+		{"sql without user", Config{Auth: AuthSQL}, "", false},
+		{"krb5 ticket cache", Config{Auth: AuthKerberos}, "", false},
+		{"krb5 password", Config{Auth: AuthKerberos, User: "svc@CORP.LOCAL", Password: "secret"}, "svc@CORP.LOCAL:secret", false},
+		{"krb5 keytab", Config{Auth: AuthKerberos, User: "svc@CORP.LOCAL", Password: "secret", Keytab: "svc.keytab"}, "svc@CORP.LOCAL", false},
+		{"krb5 keytab without user", Config{Auth: AuthKerberos, Keytab: "svc.keytab"}, "", true},
+		{"ad default", Config{Auth: AuthADDefault, User: "ignored"}, "", false},
+		{"managed identity", Config{Auth: AuthADManagedIdentity}, "", false},
+		{"managed identity client id", Config{Auth: AuthADManagedIdentity, User: "client-id"}, "client-id", false},
+		{"interactive", Config{Auth: AuthADInteractive, AppClientID: "app"}, "", false},
+		{"interactive hint", Config{Auth: AuthADInteractive, AppClientID: "app", User: "me@corp.com"}, "me@corp.com", false},
+		{"interactive without app", Config{Auth: AuthADInteractive}, "", true},
+		{"service principal without user", Config{Auth: AuthADServicePrincipal}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, user, err := tt.cfg.authParams()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			got := ""
+			if user != nil {
+				got = user.Username()
+				if password, ok := user.Password(); ok {
+					got += ":" + password
+				}
+			}
+			if got != tt.want {
+				t.Errorf("user = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNeedsPassword(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want bool
+	}{
+		{"sql", Config{Auth: AuthSQL, User: "sa"}, true},
+		{"sql without user", Config{Auth: AuthSQL}, false},
+		{"krb5 ticket cache", Config{Auth: AuthKerberos}, false},
+		{"krb5 keytab", Config{Auth: AuthKerberos, User: "svc", Keytab: "svc.keytab"}, false},
+		{"ad default", Config{Auth: AuthADDefault, User: "x"}, false},
+		{"dsn", Config{Auth: AuthSQL, User: "sa", DSN: "sqlserver://sa@host"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.needsPassword(); got != tt.want {
+			t.Errorf("%s: needsPassword() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
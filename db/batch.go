@@ -0,0 +1,174 @@
+package db
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// batchToken is a word of a SQL batch, quoted identifiers are never
+// keywords.
+type batchToken struct {
+	text   string
+	quoted bool
+}
+
+// keyword returns the upper case word, empty for quoted identifiers.
+func (t batchToken) keyword() string {
+	if t.quoted {
+		return ""
+	}
+	return strings.ToUpper(t.text)
+}
+
+// local tells if the token names a table variable or a temporary table,
+// writing to them leaves no trace in the database.
+func (t batchToken) local() bool {
+	return strings.HasPrefix(t.text, "@") || strings.HasPrefix(t.text, "#")
+}
+
+// batchStarts are the first words of batches that are checked statement by
+// statement. Anything else, e.g. a procedure called without EXEC, may write.
+var batchStarts = map[string]bool{
+	"SELECT": true, "WITH": true, "DECLARE": true, "SET": true, "IF": true,
+	"BEGIN": true, "INSERT": true, "UPDATE": true, "DELETE": true,
+	"MERGE": true, "CREATE": true, "DROP": true, "TRUNCATE": true,
+	"USE": true, "VALUES": true,
+}
+
+// batchWrites are the words of statements that may change the database,
+// run code or change permissions.
+var batchWrites = map[string]bool{
+	"EXEC": true, "EXECUTE": true, "ALTER": true, "GRANT": true,
+	"REVOKE": true, "DENY": true, "BACKUP": true, "RESTORE": true,
+	"BULK": true, "DBCC": true, "KILL": true, "SHUTDOWN": true,
+	"RECONFIGURE": true, "CHECKPOINT": true, "OPENQUERY": true,
+	"OPENDATASOURCE": true, "ATTACH": true, "DETACH": true, "PRAGMA": true,
+	"VACUUM": true, "REINDEX": true,
+}
+
+// readOnlyBatch tells if the batch only reads from the database, so running
+// it again would give the same answer and it is safe to cache. Writes to
+// table variables and temporary tables are allowed, as templates collect
+// their input in them, anything else that may write is not.
+func readOnlyBatch(query string) bool {
+	tokens := batchTokens(query)
+	if len(tokens) == 0 || !batchStarts[tokens[0].keyword()] {
+		return false
+	}
+	// target returns the table the word at i writes to, skipping the words
+	// that may come in between
+	target := func(i int, skip ...string) batchToken {
+		for i++; i < len(tokens); i++ {
+			kw := tokens[i].keyword()
+			if kw == "" || !slices.Contains(skip, kw) {
+				return tokens[i]
+			}
+		}
+		return batchToken{}
+	}
+
+	for i, t := range tokens {
+		kw := t.keyword()
+		switch {
+		case batchWrites[kw]:
+			return false
+		case kw == "INSERT" || kw == "INTO" || kw == "MERGE":
+			if !target(i, "INTO").local() {
+				return false
+			}
+		case kw == "UPDATE":
+			if !target(i).local() {
+				return false
+			}
+		case kw == "DELETE":
+			if !target(i, "FROM").local() {
+				return false
+			}
+		case kw == "CREATE" || kw == "DROP" || kw == "TRUNCATE":
+			// only temporary tables, table variables need no CREATE
+			if next := target(i); next.keyword() != "TABLE" || !target(i+1, "IF", "NOT", "EXISTS").local() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// endsTransaction tells if the batch commits or rolls back a transaction,
+// which ends the transaction it runs in.
+func endsTransaction(query string) bool {
+	for _, t := range batchTokens(query) {
+		switch t.keyword() {
+		case "COMMIT", "ROLLBACK":
+			return true
+		}
+	}
+	return false
+}
+
+// batchTokens splits the batch into words and quoted identifiers, leaving
+// out comments, string literals, numbers and punctuation.
+func batchTokens(query string) []batchToken {
+	var tokens []batchToken
+	runes := []rune(query)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			// block comments nest in T-SQL
+			depth := 0
+			for ; i < len(runes); i++ {
+				if runes[i] == '/' && i+1 < len(runes) && runes[i+1] == '*' {
+					depth++
+					i++
+				} else if runes[i] == '*' && i+1 < len(runes) && runes[i+1] == '/' {
+					depth--
+					i++
+					if depth == 0 {
+						break
+					}
+				}
+			}
+		case r == '\'':
+			i = closing(runes, i, '\'')
+		case r == '[' || r == '"' || r == '`':
+			end := map[rune]rune{'[': ']', '"': '"', '`': '`'}[r]
+			j := closing(runes, i, end)
+			tokens = append(tokens, batchToken{text: string(runes[i+1 : min(j, len(runes))]), quoted: true})
+			i = j
+		case unicode.IsLetter(r) || r == '_' || r == '@' || r == '#':
+			j := i
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || strings.ContainsRune("_@#$", runes[j])) {
+				j++
+			}
+			tokens = append(tokens, batchToken{text: string(runes[i:j])})
+			i = j - 1
+		case unicode.IsDigit(r):
+			for i+1 < len(runes) && (unicode.IsLetter(runes[i+1]) || unicode.IsDigit(runes[i+1]) || runes[i+1] == '.') {
+				i++
+			}
+		}
+	}
+	return tokens
+}
+
+// closing returns the index of the quote ending the literal or identifier
+// opened at start, a doubled quote is an escaped one.
+func closing(runes []rune, start int, quote rune) int {
+	for i := start + 1; i < len(runes); i++ {
+		if runes[i] != quote {
+			continue
+		}
+		if i+1 < len(runes) && runes[i+1] == quote {
+			i++
+			continue
+		}
+		return i
+	}
+	return len(runes)
+}
@@ -0,0 +1,63 @@
+package db
+
+import "testing"
+
+func TestReadOnlyBatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  bool
+	}{
+		{"select", "SELECT * FROM articles", true},
+		{"cte", "WITH a AS (SELECT 1 AS n) SELECT n FROM a", true},
+		{"table variable", "DECLARE @BOM TABLE ([QTY] INT);\nINSERT INTO @BOM ([QTY]) VALUES (1);\nSELECT * FROM @BOM JOIN [dbo].[Update] u ON 1 = 1", true},
+		{"temporary table", "CREATE TABLE #bom (qty INT); INSERT #bom VALUES (1); SELECT * INTO #copy FROM #bom; DROP TABLE IF EXISTS #bom", true},
+		{"update table variable", "UPDATE @bom SET qty = 2; DELETE FROM @bom WHERE qty = 0; SELECT * FROM @bom", true},
+		{"keywords in literals and comments", "SELECT 'DELETE FROM articles' AS s -- UPDATE articles\n/* EXEC /* nested */ DROP TABLE x */ FROM articles", true},
+		{"quoted identifiers", `SELECT [Insert], "delete" FROM [Update]`, true},
+		{"replace function", "SELECT REPLACE(artNr, '-', '') FROM articles", true},
+		{"set options", "SET NOCOUNT ON; SELECT 1", true},
+
+		{"update then select", "UPDATE articles SET qty = 1; SELECT * FROM articles", false},
+		{"insert", "INSERT INTO dbo.articles VALUES (1)", false},
+		{"insert without into", "INSERT articles VALUES (1)", false},
+		{"delete", "DELETE FROM articles", false},
+		{"delete without from", "delete articles", false},
+		{"merge output", "MERGE INTO [dbo].[articles] AS target USING @src AS source ON 1 = 1 WHEN MATCHED THEN UPDATE SET qty = 1 OUTPUT inserted.*;", false},
+		{"merge into table variable updating", "MERGE @t AS target USING articles AS source ON 1 = 1 WHEN MATCHED THEN UPDATE SET qty = 1;", false},
+		{"select into", "SELECT * INTO archive FROM articles", false},
+		{"output into", "UPDATE @t SET qty = 1 OUTPUT inserted.qty INTO audit", false},
+		{"exec", "EXEC dbo.GetBom", false},
+		{"procedure without exec", "sp_who", false},
+		{"create view", "CREATE VIEW v AS SELECT 1 AS n", false},
+		{"drop table", "DROP TABLE articles", false},
+		{"truncate", "SELECT 1; TRUNCATE TABLE articles", false},
+		{"alter", "SELECT 1; ALTER TABLE articles ADD x INT", false},
+		{"sqlite upsert", "INSERT OR REPLACE INTO articles VALUES ('A-1', 1, 1, NULL)", false},
+		{"empty", "  -- nothing\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := readOnlyBatch(tt.query); got != tt.want {
+				t.Errorf("readOnlyBatch(%q) = %t, want %t", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEndsTransaction(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"UPDATE articles SET qty = 1", false},
+		{"SELECT 'COMMIT' AS s -- ROLLBACK", false},
+		{"BEGIN TRAN; UPDATE articles SET qty = 1; COMMIT TRAN", true},
+		{"UPDATE articles SET qty = 1; rollback", true},
+	}
+	for _, tt := range tests {
+		if got := endsTransaction(tt.query); got != tt.want {
+			t.Errorf("endsTransaction(%q) = %t, want %t", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// cacheVersion is bumped when the file layout changes, older entries are
+// treated as misses.
+const cacheVersion = 2
+
+// CachePolicy is how a query uses the result cache.
+type CachePolicy int
+
+const (
+	// CacheUse answers from the cache when it has a fresh entry
+	CacheUse CachePolicy = iota
+	// CacheRefresh always runs the query and replaces the entry
+	CacheRefresh
+	// CacheSkip runs the query without reading or writing the cache
+	CacheSkip
+)
+
+type cachePolicyKey struct{}
+
+// WithCachePolicy returns a copy of ctx running its queries with the policy,
+// it overrides --refresh and --no-cache.
+func WithCachePolicy(ctx context.Context, p CachePolicy) context.Context {
+	return context.WithValue(ctx, cachePolicyKey{}, p)
+}
+
+func cachePolicy(ctx context.Context) CachePolicy {
+	if p, ok := ctx.Value(cachePolicyKey{}).(CachePolicy); ok {
+		return p
+	}
+	switch {
+	case viper.GetBool("no-cache"):
+		return CacheSkip
+	case viper.GetBool("refresh"):
+		return CacheRefresh
+	}
+	return CacheUse
+}
+
+// ResultCache stores the result sets of queries on disk, one file per
+// connection profile, database and query, so exporting the same query again
+// does not run it on the database.
+type ResultCache struct {
+	dir string
+	ttl time.Duration
+	// pruned removes expired entries once per process
+	pruned sync.Once
+}
+
+// cacheEntry is the file layout of a cached query.
+type cacheEntry struct {
+	Version    int    `json:"version"`
+	Connection string `json:"connection"`
+	// Source is the redacted connection string the results came from, a
+	// profile pointed at another database does not share them
+	Source   string           `json:"source"`
+	Query    string           `json:"query"`
+	CachedAt time.Time        `json:"cachedAt"`
+	Results  []CassetteResult `json:"results"`
+}
+
+var (
+	_ Executor = (*Cached)(nil)
+
+	resultCaches   = map[string]*ResultCache{}
+	resultCachesMu sync.Mutex
+)
+
+// OpenResultCache returns the cache in dir keeping entries for ttl. Caches
+// are shared per directory.
+func OpenResultCache(dir string, ttl time.Duration) (*ResultCache, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("cache ttl must be positive, got %s", ttl)
+	}
+	resultCachesMu.Lock()
+	defer resultCachesMu.Unlock()
+
+	if c, ok := resultCaches[dir]; ok {
+		return c, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	c := &ResultCache{dir: dir, ttl: ttl}
+	resultCaches[dir] = c
+	return c, nil
+}
+
+func (c *ResultCache) path(connection, source, query string) string {
+	sum := sha256.Sum256([]byte(connection + "\x00" + source + "\x00" + strings.TrimSpace(query)))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// get returns the cached result sets of the query, ok is false when there is
+// no fresh entry.
+func (c *ResultCache) get(connection, source, query string) (sets []*Result, ok bool) {
+	c.pruned.Do(c.prune)
+
+	b, err := os.ReadFile(c.path(connection, source, query))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil || entry.Version != cacheVersion {
+		return nil, false
+	}
+	// the hash is only the file name, compare what was hashed
+	if entry.Connection != connection || entry.Source != source || strings.TrimSpace(entry.Query) != strings.TrimSpace(query) {
+		return nil, false
+	}
+	if time.Since(entry.CachedAt) > c.ttl {
+		os.Remove(c.path(connection, source, query))
+		return nil, false
+	}
+	sets = decodeResults(entry.Results)
+	for _, res := range sets {
+		res.CachedAt = entry.CachedAt
+	}
+	return sets, true
+}
+
+// put stores the result sets of the query, replacing an older entry.
+func (c *ResultCache) put(connection, source, query string, sets []*Result) error {
+	b, err := json.Marshal(cacheEntry{
+		Version:    cacheVersion,
+		Connection: connection,
+		Source:     source,
+		Query:      query,
+		CachedAt:   time.Now().UTC(),
+		Results:    encodeResults(sets),
+	})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.dir, ".cache-*")
+	if err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	return os.Rename(tmp.Name(), c.path(connection, source, query))
+}
+
+// prune removes the entries older than the ttl.
+func (c *ResultCache) prune() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		if info, err := e.Info(); err == nil && time.Since(info.ModTime()) > c.ttl {
+			os.Remove(filepath.Join(c.dir, e.Name()))
+		}
+	}
+}
+
+// Cached is an Executor that answers repeated queries from a ResultCache.
+// Only batches that read are cached, see readOnlyBatch. Failed queries,
+// queries without result sets and result sets cut short by a row limit are
+// never cached.
+type Cached struct {
+	Executor
+	cache *ResultCache
+	// source is the cacheSource of the executor's connection
+	source string
+}
+
+// NewCached wraps the executor to cache its results.
+func NewCached(e Executor, c *ResultCache) *Cached {
+	return &Cached{Executor: e, cache: c, source: e.Config().cacheSource()}
+}
+
+// Execute returns the cached result sets of the query or runs it on the
+// wrapped executor and caches them.
+func (c *Cached) Execute(ctx context.Context, query string) ([]*Result, error) {
+	policy := cachePolicy(ctx)
+	if policy != CacheSkip && !readOnlyBatch(query) {
+		zap.L().Debug("Not caching a batch that may write", zap.String("connection", c.Config().Name))
+		policy = CacheSkip
+	}
+	connection, source := c.Config().Name, c.source
+	if policy == CacheUse {
+		if sets, ok := c.cache.get(connection, source, query); ok {
+			cachedAt := FirstResult(sets).CachedAt
+			msg := fmt.Sprintf("Served from the result cache, cached at %s", cachedAt.Local().Format(time.DateTime))
+			if m, ok := ctx.Value(messagesKey{}).(*Messages); ok {
+				m.add(msg)
+			}
+			zap.L().Info(msg, zap.String("connection", connection))
+			return sets, nil
+		}
+	}
+
+	sets, err := c.Executor.Execute(ctx, query)
+	if err != nil || ctx.Err() != nil || policy == CacheSkip || len(sets) == 0 || truncated(sets) {
+		return sets, err
+	}
+	if putErr := c.cache.put(connection, source, query, sets); putErr != nil {
+		zap.L().Error("Failed to cache query results", zap.String("dir", c.cache.dir), zap.Error(putErr))
+	}
+	return sets, nil
+}
+
+// Query returns the first result set of the query, cached or not.
+func (c *Cached) Query(ctx context.Context, query string) (*Result, error) {
+	sets, err := c.Execute(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return FirstResult(sets), nil
+}
+
+// ResultCacheEnabled tells if queries are answered from the result cache.
+func ResultCacheEnabled() bool {
+	return viper.GetBool("cache")
+}
+
+// openResultCache opens the cache configured with --cache, --cache-dir and
+// --cache-ttl, nil when it is disabled.
+func openResultCache() (*ResultCache, error) {
+	if !ResultCacheEnabled() {
+		return nil, nil
+	}
+	dir := viper.GetString("cache-dir")
+	if dir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find a directory for the result cache, set --cache-dir: %w", err)
+		}
+		dir = filepath.Join(cacheDir, "gaspecgen", "results")
+	}
+	return OpenResultCache(dir, viper.GetDuration("cache-ttl"))
+}
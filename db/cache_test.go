@@ -0,0 +1,215 @@
+package db
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// countingExecutor counts the queries that reach the wrapped executor.
+type countingExecutor struct {
+	Executor
+	n int
+}
+
+func (c *countingExecutor) Execute(ctx context.Context, query string) ([]*Result, error) {
+	c.n++
+	return c.Executor.Execute(ctx, query)
+}
+
+func newTestCache(t *testing.T, ttl time.Duration) (*Cached, *countingExecutor) {
+	t.Helper()
+	cache, err := OpenResultCache(t.TempDir(), ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := &countingExecutor{Executor: openTestSQLite(t, false)}
+	return NewCached(counter, cache), counter
+}
+
+func TestCachedRoundTrip(t *testing.T) {
+	cached, counter := newTestCache(t, time.Hour)
+	ctx := context.Background()
+	const query = "SELECT artNr, qty FROM articles ORDER BY artNr"
+
+	first, err := cached.Execute(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first[0].CachedAt.IsZero() {
+		t.Error("the first run is marked as cached")
+	}
+
+	ctx, messages := WithMessages(ctx)
+	second, err := cached.Execute(ctx, query+"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counter.n != 1 {
+		t.Errorf("the database ran %d queries, want 1", counter.n)
+	}
+	if second[0].CachedAt.IsZero() {
+		t.Error("the cached answer has no CachedAt")
+	}
+	if len(messages.List()) == 0 {
+		t.Error("the cached answer added no message")
+	}
+	second[0].CachedAt = time.Time{}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("cached %+v, want %+v", second[0], first[0])
+	}
+}
+
+func TestCachedPolicies(t *testing.T) {
+	cached, counter := newTestCache(t, time.Hour)
+	ctx := context.Background()
+	const query = "SELECT COUNT(*) AS n FROM articles"
+
+	for _, step := range []struct {
+		policy CachePolicy
+		runs   int
+	}{
+		{CacheSkip, 1},    // not stored
+		{CacheUse, 2},     // miss, stored
+		{CacheUse, 2},     // hit
+		{CacheRefresh, 3}, // runs and replaces
+		{CacheUse, 3},     // hit
+	} {
+		if _, err := cached.Execute(WithCachePolicy(ctx, step.policy), query); err != nil {
+			t.Fatal(err)
+		}
+		if counter.n != step.runs {
+			t.Fatalf("policy %d: the database ran %d queries, want %d", step.policy, counter.n, step.runs)
+		}
+	}
+}
+
+func TestCachedSkipsFailuresAndStatements(t *testing.T) {
+	cached, counter := newTestCache(t, time.Hour)
+	ctx := context.Background()
+
+	for range 2 {
+		cached.Execute(ctx, "SELECT nosuch FROM articles")
+		cached.Execute(ctx, "UPDATE articles SET qty = 1")
+	}
+	if counter.n != 4 {
+		t.Errorf("the database ran %d queries, want 4", counter.n)
+	}
+}
+
+func TestCachedExpires(t *testing.T) {
+	cached, counter := newTestCache(t, time.Hour)
+	ctx := context.Background()
+	const query = "SELECT artNr FROM articles"
+
+	if _, err := cached.Execute(ctx, query); err != nil {
+		t.Fatal(err)
+	}
+	// let the entry outlive a shorter ttl
+	cached.cache.ttl = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := cached.Execute(ctx, query); err != nil {
+		t.Fatal(err)
+	}
+	if counter.n != 2 {
+		t.Errorf("the database ran %d queries, want 2 after the entry expired", counter.n)
+	}
+	if _, err := os.Stat(cached.cache.path(cached.Config().Name, cached.source, query)); err != nil {
+		t.Errorf("the fresh answer was not cached: %v", err)
+	}
+}
+
+func TestCachedSkipsTruncatedResults(t *testing.T) {
+	cached, counter := newTestCache(t, time.Hour)
+	const query = "SELECT artNr FROM articles"
+
+	if _, err := cached.Execute(WithRowLimit(context.Background(), 1), query); err != nil {
+		t.Fatal(err)
+	}
+	res, err := cached.Query(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counter.n != 2 || len(res.Rows) != 2 {
+		t.Errorf("the database ran %d queries and answered %d rows, want 2 and 2", counter.n, len(res.Rows))
+	}
+}
+
+func TestCachedSkipsWritingBatches(t *testing.T) {
+	cached, counter := newTestCache(t, time.Hour)
+	ctx := context.Background()
+	const query = "UPDATE articles SET qty = qty + 1 WHERE artNr = 'A-1' RETURNING qty"
+
+	for i, want := range []string{"6", "7"} {
+		res, err := cached.Query(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Rows[0]["qty"]; got != want || counter.n != i+1 {
+			t.Errorf("run %d: qty = %s after %d queries, want %s", i+1, got, counter.n, want)
+		}
+	}
+}
+
+func TestCacheSource(t *testing.T) {
+	a := &Config{Name: "prod", Host: "db1.example.com", Auth: AuthSQL, User: "sa", Password: "secret"}
+	b := &Config{Name: "prod", Host: "db2.example.com", Auth: AuthSQL, User: "sa", Password: "secret"}
+	if a.cacheSource() == b.cacheSource() {
+		t.Errorf("profiles on different servers share the cache source %q", a.cacheSource())
+	}
+	if strings.Contains(a.cacheSource(), "secret") {
+		t.Errorf("cache source %q holds the password", a.cacheSource())
+	}
+	viper.Set("loglevel", "debug")
+	t.Cleanup(viper.Reset)
+	if c := (&Config{Name: "prod", Host: "db1.example.com", Auth: AuthSQL, User: "sa", Password: "secret"}); c.cacheSource() != a.cacheSource() {
+		t.Errorf("the log level changed the cache source to %q", c.cacheSource())
+	}
+
+	sqlite := &Config{Name: "test", Driver: DriverSQLite, Fixtures: []string{"a.sql"}}
+	other := &Config{Name: "test", Driver: DriverSQLite, Fixtures: []string{"b.sql"}}
+	if sqlite.cacheSource() == other.cacheSource() {
+		t.Error("sqlite databases with different fixtures share the cache source")
+	}
+}
+
+func TestOpenNamedRecordsCacheHits(t *testing.T) {
+	dir := t.TempDir()
+	fixture := filepath.Join(dir, "fixture.sql")
+	if err := os.WriteFile(fixture, []byte(testFixture), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cassette := filepath.Join(dir, "cassette.json")
+	viper.Set("db-driver", DriverSQLite)
+	viper.Set("db-fixture", []string{fixture})
+	viper.Set("cache", true)
+	viper.Set("cache-dir", filepath.Join(dir, "cache"))
+	viper.Set("cache-ttl", time.Hour)
+	viper.Set("record", cassette)
+	t.Cleanup(func() {
+		CloseAll()
+		viper.Reset()
+	})
+
+	ctx := context.Background()
+	e, err := Open(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const query = "SELECT artNr FROM articles"
+	for range 2 {
+		if _, err := e.Execute(ctx, query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(reloadCassette(t, cassette).Interactions); n != 2 {
+		t.Errorf("recorded %d queries, want the miss and the hit", n)
+	}
+}
@@ -0,0 +1,361 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// cassetteVersion is bumped when the file layout changes.
+const cassetteVersion = 2
+
+// Cassette is a file of recorded queries and their results. It is written
+// with --record and served back with --replay, so runs can be reproduced
+// without a database.
+//
+// The file holds a header line with the version followed by one JSON
+// interaction per line, recording appends a line per query so a long
+// running server or an interrupted run still leaves a usable file.
+type Cassette struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions,omitempty"`
+
+	path string
+	mu   sync.Mutex
+	// started is set once the file was replaced by the first recorded query
+	started bool
+	// next is the replay position per connection and query, repeated
+	// queries are served in the recorded order and the last answer is
+	// repeated after that
+	next map[string]int
+}
+
+// Interaction is a single recorded query.
+type Interaction struct {
+	Connection string           `json:"connection"`
+	Query      string           `json:"query"`
+	RecordedAt time.Time        `json:"recordedAt"`
+	Results    []CassetteResult `json:"results,omitempty"`
+	Error      string           `json:"error,omitempty"`
+}
+
+// CassetteResult is a result set, rows are stored in column order.
+type CassetteResult struct {
+	Columns []string   `json:"columns"`
+	Types   []string   `json:"types,omitempty"`
+	Rows    [][]string `json:"rows"`
+	// Nulls has the indexes of the NULL columns of every row, it is left
+	// out when the set has none
+	Nulls [][]int `json:"nulls,omitempty"`
+}
+
+var (
+	_ Executor = (*Recorder)(nil)
+	_ Executor = (*Replayer)(nil)
+
+	cassettes   = map[string]*Cassette{}
+	cassettesMu sync.Mutex
+)
+
+// LoadCassette reads the cassette at path for replaying. Cassettes are
+// shared per path.
+func LoadCassette(path string) (*Cassette, error) {
+	return cassette(path, func(c *Cassette) error {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read cassette: %w", err)
+		}
+		if err := c.decode(b); err != nil {
+			return fmt.Errorf("invalid cassette %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// decode reads the header line and the interactions following it. Version
+// 1 cassettes are a single JSON document with all interactions.
+func (c *Cassette) decode(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	var header Cassette
+	if err := dec.Decode(&header); err != nil {
+		return err
+	}
+	switch header.Version {
+	case 1:
+		c.Interactions = header.Interactions
+		return nil
+	case cassetteVersion:
+	default:
+		return fmt.Errorf("unsupported version %d, expected %d", header.Version, cassetteVersion)
+	}
+	for {
+		var it Interaction
+		err := dec.Decode(&it)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("interaction %d: %w", len(c.Interactions)+1, err)
+		}
+		c.Interactions = append(c.Interactions, it)
+	}
+}
+
+// NewCassette starts an empty cassette for recording, an existing file at
+// path is replaced on the first recorded query.
+func NewCassette(path string) (*Cassette, error) {
+	return cassette(path, func(*Cassette) error { return nil })
+}
+
+func cassette(path string, init func(*Cassette) error) (*Cassette, error) {
+	cassettesMu.Lock()
+	defer cassettesMu.Unlock()
+
+	if c, ok := cassettes[path]; ok {
+		return c, nil
+	}
+	c := &Cassette{Version: cassetteVersion, path: path, next: map[string]int{}}
+	if err := init(c); err != nil {
+		return nil, err
+	}
+	cassettes[path] = c
+	return c, nil
+}
+
+func cassetteKey(query string) string {
+	return strings.TrimSpace(query)
+}
+
+func (c *Cassette) record(connection, query string, sets []*Result, queryErr error) error {
+	it := Interaction{
+		Connection: connection,
+		Query:      query,
+		RecordedAt: time.Now().UTC(),
+		Results:    encodeResults(sets),
+	}
+	if queryErr != nil {
+		it.Error = queryErr.Error()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.append(it); err != nil {
+		return err
+	}
+	c.Interactions = append(c.Interactions, it)
+	return nil
+}
+
+// append writes the interaction as a line at the end of the file, the
+// first one replaces the file with a new header.
+func (c *Cassette) append(it Interaction) error {
+	line, err := json.Marshal(it)
+	if err != nil {
+		return err
+	}
+	flags := os.O_WRONLY | os.O_APPEND
+	if !c.started {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		header, err := json.Marshal(Cassette{Version: c.Version})
+		if err != nil {
+			return err
+		}
+		line = append(append(header, '\n'), line...)
+	}
+	f, err := os.OpenFile(c.path, flags, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	c.started = true
+	return nil
+}
+
+// replay returns the recorded outcome of the query on the connection. A
+// query recorded on another connection is an error, its answer would not
+// be the one of this connection.
+func (c *Cassette) replay(connection, query string) ([]*Result, error) {
+	key := cassetteKey(query)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var (
+		matches []*Interaction
+		others  []string
+	)
+	for i := range c.Interactions {
+		it := &c.Interactions[i]
+		if cassetteKey(it.Query) != key {
+			continue
+		}
+		if it.Connection != connection {
+			if !slices.Contains(others, it.Connection) {
+				others = append(others, it.Connection)
+			}
+			continue
+		}
+		matches = append(matches, it)
+	}
+	if len(matches) == 0 {
+		if len(others) > 0 {
+			return nil, fmt.Errorf("query was recorded on connection %s in cassette %s, not on %s", strings.Join(others, ", "), c.path, connection)
+		}
+		return nil, fmt.Errorf("query not found in cassette %s, record it first with --record", c.path)
+	}
+	next := connection + "\x00" + key
+	n := min(c.next[next], len(matches)-1)
+	c.next[next] = n + 1
+	it := matches[n]
+
+	if it.Error != "" {
+		return nil, errors.New(it.Error)
+	}
+	return decodeResults(it.Results), nil
+}
+
+// encodeResults stores the rows of the result sets in column order.
+func encodeResults(sets []*Result) []CassetteResult {
+	var encoded []CassetteResult
+	for _, res := range sets {
+		cr := CassetteResult{Columns: res.Columns, Types: res.Types, Rows: make([][]string, 0, len(res.Rows))}
+		for _, row := range res.Rows {
+			values := make([]string, len(res.Columns))
+			for i, col := range res.Columns {
+				values[i] = row[col]
+			}
+			cr.Rows = append(cr.Rows, values)
+		}
+		if res.Nulls != nil {
+			cr.Nulls = make([][]int, len(res.Rows))
+			for r := range res.Rows {
+				for i, col := range res.Columns {
+					if r < len(res.Nulls) && res.Nulls[r][col] {
+						cr.Nulls[r] = append(cr.Nulls[r], i)
+					}
+				}
+			}
+		}
+		encoded = append(encoded, cr)
+	}
+	return encoded
+}
+
+func decodeResults(encoded []CassetteResult) []*Result {
+	sets := make([]*Result, 0, len(encoded))
+	for _, cr := range encoded {
+		res := &Result{Columns: cr.Columns, Types: cr.Types, Rows: make([]map[string]string, 0, len(cr.Rows))}
+		for _, values := range cr.Rows {
+			row := make(map[string]string, len(cr.Columns))
+			for i, col := range cr.Columns {
+				if i < len(values) {
+					row[col] = values[i]
+				}
+			}
+			res.Rows = append(res.Rows, row)
+		}
+		if cr.Nulls != nil {
+			res.Nulls = make([]map[string]bool, len(res.Rows))
+			for r, indexes := range cr.Nulls {
+				for _, i := range indexes {
+					if r < len(res.Nulls) && i < len(cr.Columns) {
+						if res.Nulls[r] == nil {
+							res.Nulls[r] = map[string]bool{}
+						}
+						res.Nulls[r][cr.Columns[i]] = true
+					}
+				}
+			}
+		}
+		sets = append(sets, res)
+	}
+	return sets
+}
+
+// Recorder is an Executor that writes every query and its results to a
+// cassette.
+type Recorder struct {
+	Executor
+	cassette *Cassette
+}
+
+// NewRecorder wraps the executor to record into the cassette.
+func NewRecorder(e Executor, c *Cassette) *Recorder {
+	return &Recorder{Executor: e, cassette: c}
+}
+
+// Execute runs the query on the wrapped executor and records the outcome.
+func (r *Recorder) Execute(ctx context.Context, query string) ([]*Result, error) {
+	sets, err := r.Executor.Execute(ctx, query)
+	// a cancelled query says nothing about the database and a truncated
+	// one would replay as the full result, do not record them
+	if ctx.Err() == nil && !truncated(sets) {
+		if recErr := r.cassette.record(r.Config().Name, query, sets, err); recErr != nil {
+			zap.L().Error("Failed to record query", zap.String("cassette", r.cassette.path), zap.Error(recErr))
+		}
+	}
+	return sets, err
+}
+
+// Query runs the query and returns its first result set.
+func (r *Recorder) Query(ctx context.Context, query string) (*Result, error) {
+	sets, err := r.Execute(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return FirstResult(sets), nil
+}
+
+// Replayer is an Executor that answers from a cassette without a database.
+type Replayer struct {
+	cassette *Cassette
+	config   *Config
+}
+
+// NewReplayer serves the queries recorded in the cassette.
+func NewReplayer(c *Cassette, cfg *Config) *Replayer {
+	return &Replayer{cassette: c, config: cfg}
+}
+
+// Execute returns the recorded results of the query.
+func (r *Replayer) Execute(ctx context.Context, query string) ([]*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.cassette.replay(r.config.Name, query)
+}
+
+// Query returns the first recorded result set of the query.
+func (r *Replayer) Query(ctx context.Context, query string) (*Result, error) {
+	sets, err := r.Execute(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return FirstResult(sets), nil
+}
+
+// Config returns the configuration of the replayed connection.
+func (r *Replayer) Config() *Config {
+	return r.config
+}
+
+// Close is a no-op, the cassette is shared.
+func (r *Replayer) Close() error {
+	return nil
+}
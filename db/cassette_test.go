@@ -0,0 +1,189 @@
+package db
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// reloadCassette drops the shared cassette so it is read from the file.
+func reloadCassette(t *testing.T, path string) *Cassette {
+	t.Helper()
+	cassettesMu.Lock()
+	delete(cassettes, path)
+	cassettesMu.Unlock()
+
+	c, err := LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCassetteRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s := openTestSQLite(t, false)
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	c, err := NewCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := NewRecorder(s, c)
+
+	const query = "SELECT artNr, qty, added FROM articles ORDER BY artNr"
+	recorded, err := rec.Execute(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rec.Execute(ctx, "DELETE FROM articles WHERE artNr = 'A-2'"); err != nil {
+		t.Fatal(err)
+	}
+	afterDelete, err := rec.Execute(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rec.Execute(ctx, "SELECT nosuch FROM articles"); err == nil {
+		t.Fatal("got no error for an unknown column")
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayer := NewReplayer(reloadCassette(t, path), s.Config())
+
+	// repeated queries are answered in the recorded order, the last one
+	// again after that
+	for i, want := range [][]*Result{recorded, afterDelete, afterDelete} {
+		got, err := replayer.Execute(ctx, "  "+query+"\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("replay %d = %+v, want %+v", i, got[0], want[0])
+		}
+	}
+	if got := afterDelete[0].Rows; len(got) != 1 {
+		t.Errorf("recorded %d rows after the delete, want 1", len(got))
+	}
+	if !reflect.DeepEqual(recorded[0].Nulls, []map[string]bool{nil, {"qty": true, "added": true}}) {
+		t.Errorf("recorded nulls = %v", recorded[0].Nulls)
+	}
+
+	if _, err := replayer.Execute(ctx, "SELECT nosuch FROM articles"); err == nil {
+		t.Error("the recorded error was not replayed")
+	}
+	if _, err := replayer.Execute(ctx, "SELECT 1"); err == nil {
+		t.Error("replayed a query that was never recorded")
+	}
+}
+
+func TestRecorderSkipsCancelledQueries(t *testing.T) {
+	s := openTestSQLite(t, false)
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	c, err := NewCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	NewRecorder(s, c).Execute(ctx, "SELECT 1")
+	if len(c.Interactions) != 0 {
+		t.Errorf("recorded %d cancelled queries", len(c.Interactions))
+	}
+}
+
+func TestRecorderSkipsTruncatedResults(t *testing.T) {
+	s := openTestSQLite(t, false)
+	c, err := NewCassette(filepath.Join(t.TempDir(), "cassette.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	NewRecorder(s, c).Execute(WithRowLimit(context.Background(), 1), "SELECT artNr FROM articles")
+	if len(c.Interactions) != 0 {
+		t.Errorf("recorded %d truncated queries", len(c.Interactions))
+	}
+}
+
+func TestCassetteAppends(t *testing.T) {
+	ctx := context.Background()
+	s := openTestSQLite(t, false)
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	if err := os.WriteFile(path, []byte("an older recording"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := NewRecorder(s, c)
+
+	for i, query := range []string{"SELECT 1 AS n", "SELECT 2 AS n", "SELECT 3 AS n"} {
+		if _, err := rec.Execute(ctx, query); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the header and a line per query, readable after every query
+		if lines := strings.Count(string(b), "\n"); lines != i+2 {
+			t.Errorf("the cassette has %d lines after %d queries, want %d", lines, i+1, i+2)
+		}
+		if got := len(reloadCassette(t, path).Interactions); got != i+1 {
+			t.Errorf("read %d interactions after %d queries", got, i+1)
+		}
+	}
+}
+
+func TestCassetteVersion1(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	v1 := `{
+  "version": 1,
+  "interactions": [
+    {"connection": "default", "query": "SELECT 1 AS n", "recordedAt": "2024-03-01T00:00:00Z", "results": [{"columns": ["n"], "rows": [["1"]]}]}
+  ]
+}`
+	if err := os.WriteFile(path, []byte(v1), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	res, err := NewReplayer(reloadCassette(t, path), &Config{Name: DefaultProfile}).Query(context.Background(), "SELECT 1 AS n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Rows[0]["n"]; got != "1" {
+		t.Errorf("n = %q, want 1", got)
+	}
+}
+
+func TestReplayerMatchesConnection(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	c, err := NewCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const query = "SELECT 'prod' AS db"
+	c.record("prod", query, []*Result{{Columns: []string{"db"}, Rows: []map[string]string{{"db": "prod"}}}}, nil)
+	c.record("test", query, []*Result{{Columns: []string{"db"}, Rows: []map[string]string{{"db": "test"}}}}, nil)
+	c = reloadCassette(t, path)
+
+	for _, connection := range []string{"test", "prod"} {
+		res, err := NewReplayer(c, &Config{Name: connection}).Query(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Rows[0]["db"]; got != connection {
+			t.Errorf("replayed the answer of %s on %s", got, connection)
+		}
+	}
+	_, err = NewReplayer(c, &Config{Name: "dev"}).Query(ctx, query)
+	if err == nil || !strings.Contains(err.Error(), "recorded on connection prod, test") {
+		t.Errorf("got %v, want an error naming the recorded connections", err)
+	}
+}
@@ -1,85 +1,285 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
-	_ "github.com/microsoft/go-mssqldb/azuread"
+	_ "github.com/microsoft/go-mssqldb/integratedauth/krb5"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
+// DB is the SQL Server Executor, a connection pool for one connection profile.
 type DB struct {
+	config *Config
+
+	mu   sync.Mutex
+	pool *pool
+}
+
+// pool is the connection pool of a DB, it is replaced when the server
+// refuses the login so a changed password is picked up.
+type pool struct {
 	connection *sql.DB
+	connString string
+	// users counts the callers of connection, a replaced pool is closed
+	// once they are done
+	users sync.WaitGroup
+}
+
+// acquire returns the current pool, the caller has to call users.Done.
+func (d *DB) acquire() *pool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pool.users.Add(1)
+	return d.pool
 }
 
+// instances holds one executor per connection profile. Only opened ones are
+// stored, a failed connect is retried on the next call instead of being
+// cached for the life of the process.
 var (
-	instance *DB
-	lastErr  error
-	once     sync.Once
+	instances   = map[string]Executor{}
+	instancesMu sync.Mutex
 )
 
-// Get returns the singleton instance with the connection from config
-func Get() (*DB, error) {
-	options := fmt.Sprintf("encrypt=%s", (func(opt bool) string {
-		if opt {
-			return "true"
+// Get returns the pool for the selected connection profile
+func Get(ctx context.Context) (*DB, error) {
+	return GetNamed(ctx, SelectedProfile())
+}
+
+// GetNamed returns the shared pool for the named connection profile and
+// makes sure the server can be reached, see Connect.
+func GetNamed(ctx context.Context, name string) (*DB, error) {
+	cfg, err := ConfigFor(name)
+	if err != nil {
+		return nil, err
+	}
+
+	d, ok := lookup[*DB](cfg.Name)
+	if !ok {
+		// the password may come from a command, the keyring or a prompt,
+		// resolve it before locking the pools of every profile
+		connString, err := cfg.resolvedConnString()
+		if err != nil {
+			return nil, err
 		}
-		return "false"
-	})(viper.GetBool("db-encrypt")))
-	if viper.GetBool("db-trust-cert") {
-		options += "&TrustServerCertificate=true"
-	}
-	if viper.GetString("loglevel") == "debug" {
-		// All the logs
-		options += "&log=255"
-	}
-	return GetInstance(
-		fmt.Sprintf("sqlserver://%s:%s@%s?database=%s&%s",
-			viper.GetString("db-user"),
-			viper.GetString("db-password"),
-			viper.GetString("db-host"),
-			viper.GetString("db"),
-			options,
-		),
-	)
-}
-
-// GetInstance returns the singleton instance of the DB struct.
-// returns nil on failure
-func GetInstance(connString string) (*DB, error) {
-	once.Do(func() {
-		db, err := sql.Open("sqlserver", connString)
+		d, err = instance(cfg.Name, func() (*DB, error) {
+			zap.L().Debug("Connecting to db", zap.String("connection", cfg.Name), zap.String("dsn", RedactedDSN(connString)))
+			return open(cfg.driverName(), connString, cfg)
+		})
 		if err != nil {
-			//zap.L().Error("Failed to connect to the database", zap.Error(err))
-			lastErr = fmt.Errorf("failed to connect to the database, err: %s", err.Error())
-			return
+			return nil, err
 		}
+	}
+
+	err = d.Connect(ctx)
+	if err == nil || !isLoginError(err) {
+		return d, err
+	}
+	// the login was refused, read the password again and connect once more
+	// with a new pool when it changed
+	connString, resolveErr := cfg.resolvedConnString()
+	if resolveErr != nil {
+		return nil, errors.Join(err, resolveErr)
+	}
+	if replaced, replaceErr := d.replace(cfg.driverName(), connString); !replaced {
+		return d, errors.Join(err, replaceErr)
+	}
+	zap.L().Info("Login refused, connecting with the password read again", zap.String("connection", cfg.Name))
+	return d, d.Connect(ctx)
+}
 
-		// Ping the database to verify the connection
-		if err := db.Ping(); err != nil {
-			//zap.L().Error("Failed to ping the database", zap.Error(err))
-			lastErr = fmt.Errorf("failed to ping the database, err: %s", err.Error())
+// resolvedConnString resolves the password and returns the connection
+// string.
+func (c *Config) resolvedConnString() (string, error) {
+	if err := c.resolvePassword(); err != nil {
+		return "", err
+	}
+	return c.connString()
+}
+
+// GetInstance returns the shared pool for a raw sqlserver connection string.
+func GetInstance(ctx context.Context, connString string) (*DB, error) {
+	d, err := instance(connString, func() (*DB, error) {
+		return open("sqlserver", connString, &Config{DSN: connString})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, d.Connect(ctx)
+}
+
+// replace swaps in a new pool for connString, other callers may still use
+// the old one, it is closed once they are done. It returns false when the
+// connection string did not change.
+func (d *DB) replace(driver, connString string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pool.connString == connString {
+		return false, nil
+	}
+	p, err := newPool(driver, connString)
+	if err != nil {
+		return false, err
+	}
+	old := d.pool
+	d.pool = p
+	go func() {
+		old.users.Wait()
+		if err := old.connection.Close(); err != nil {
+			zap.L().Debug("Failed to close the replaced pool", zap.String("connection", d.config.Name), zap.Error(err))
 		}
+	}()
+	return true, nil
+}
 
-		instance = &DB{
-			connection: db,
+// isLoginError tells if the server refused the credentials.
+func isLoginError(err error) bool {
+	var sqlErr interface{ SQLErrorNumber() int32 }
+	if errors.As(err, &sqlErr) {
+		switch sqlErr.SQLErrorNumber() {
+		case 18456, 18487, 18488: // login failed, password expired, password must be changed
+			return true
 		}
-		zap.L().Debug("Connected to db")
-	})
-	return instance, lastErr
+	}
+	return strings.Contains(err.Error(), "login error")
 }
 
-// GetConnection provides access to the SQL database connection.
+// lookup returns the shared executor stored under key.
+func lookup[E Executor](key string) (E, bool) {
+	instancesMu.Lock()
+	defer instancesMu.Unlock()
+
+	e, ok := instances[key].(E)
+	return e, ok
+}
+
+func instance[E Executor](key string, create func() (E, error)) (E, error) {
+	instancesMu.Lock()
+	defer instancesMu.Unlock()
+
+	if e, ok := instances[key].(E); ok {
+		return e, nil
+	}
+	e, err := create()
+	if err != nil {
+		return e, err
+	}
+	instances[key] = e
+	return e, nil
+}
+
+// forget drops a closed executor so the next call opens a new one.
+func forget(e Executor) {
+	instancesMu.Lock()
+	defer instancesMu.Unlock()
+	for key, v := range instances {
+		if v == e {
+			delete(instances, key)
+		}
+	}
+}
+
+// open creates the DB, it does not contact the server.
+func open(driver, connString string, cfg *Config) (*DB, error) {
+	p, err := newPool(driver, connString)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{config: cfg, pool: p}, nil
+}
+
+// newPool creates a pool with the pool settings.
+func newPool(driver, connString string) (*pool, error) {
+	conn, err := sql.Open(driver, connString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the database, err: %s", err.Error())
+	}
+	conn.SetMaxOpenConns(viper.GetInt("db-max-open-conns"))
+	conn.SetMaxIdleConns(viper.GetInt("db-max-idle-conns"))
+	conn.SetConnMaxLifetime(viper.GetDuration("db-conn-max-lifetime"))
+	conn.SetConnMaxIdleTime(viper.GetDuration("db-conn-max-idle-time"))
+	return &pool{connection: conn, connString: connString}, nil
+}
+
+// Connect pings the server, retrying with exponential backoff up to
+// db-connect-retries times. The pool reconnects on its own, so a server that
+// was down is picked up again by a later call.
+func (d *DB) Connect(ctx context.Context) error {
+	retries := viper.GetInt("db-connect-retries")
+	backoff := viper.GetDuration("db-connect-backoff")
+	if backoff <= 0 {
+		backoff = 500 * time.Millisecond
+	}
+	const maxBackoff = 30 * time.Second
+
+	p := d.acquire()
+	defer p.users.Done()
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = p.connection.PingContext(ctx); err == nil {
+			zap.L().Debug("Connected to db", zap.String("connection", d.config.Name))
+			return nil
+		}
+		if attempt >= retries || ctx.Err() != nil {
+			break
+		}
+
+		zap.L().Warn("Failed to reach the database, retrying",
+			zap.String("connection", d.config.Name),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("backoff", backoff),
+			zap.Error(err))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("failed to ping the database: %w", ctx.Err())
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+	return fmt.Errorf("failed to ping the database, err: %w", err)
+}
+
+// GetConnection provides access to the current connection pool.
 func (d *DB) GetConnection() *sql.DB {
-	return d.connection
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.pool.connection
 }
 
-// Close closes the database connection.
+// Config returns the configuration the connection was opened with.
+func (d *DB) Config() *Config {
+	return d.config
+}
+
+// Close closes the pool, the next Get opens a new one.
 func (d *DB) Close() error {
-	if d.connection != nil {
-		return d.connection.Close()
+	forget(d)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.pool.connection.Close()
+}
+
+// CloseAll closes every open executor.
+func CloseAll() error {
+	instancesMu.Lock()
+	open := make([]Executor, 0, len(instances))
+	for _, e := range instances {
+		open = append(open, e)
+	}
+	instancesMu.Unlock()
+
+	var errs []error
+	for _, e := range open {
+		errs = append(errs, e.Close())
 	}
-	return nil
+	return errors.Join(errs...)
 }
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestGetNamedResolvesPasswordOutsideTheLock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the password command uses sh")
+	}
+	useConfig(t, `
+db-connect-retries: 0
+connections:
+  slow:
+    host: 127.0.0.1
+    port: 1
+    user: sa
+    password-cmd: sleep 1 && echo secret
+  offline:
+    driver: sqlite
+`)
+	t.Cleanup(func() { CloseAll() })
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := GetNamed(context.Background(), "slow")
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	started := time.Now()
+	if _, err := OpenNamed(context.Background(), "offline"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(started); d > 500*time.Millisecond {
+		t.Errorf("opening another profile took %s while a password command ran", d)
+	}
+
+	if err := <-done; err == nil {
+		t.Fatal("connected to a closed port")
+	}
+}
+
+func TestReplacePool(t *testing.T) {
+	d, err := open(DriverSQLite, "file:first?mode=memory", &Config{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	// a query still runs on the old pool
+	old := d.acquire()
+	if replaced, err := d.replace(DriverSQLite, "file:first?mode=memory"); replaced || err != nil {
+		t.Fatalf("replaced the pool with the same connection string: %v", err)
+	}
+	if replaced, err := d.replace(DriverSQLite, "file:second?mode=memory"); !replaced || err != nil {
+		t.Fatalf("did not replace the pool: %v", err)
+	}
+	if d.GetConnection() == old.connection {
+		t.Fatal("new callers still get the old pool")
+	}
+	if err := old.connection.Ping(); err != nil {
+		t.Fatalf("the old pool was closed while in use: %v", err)
+	}
+
+	old.users.Done()
+	deadline := time.Now().Add(5 * time.Second)
+	for old.connection.Ping() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the old pool was not closed once unused")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDisablePrompt(t *testing.T) {
+	useConfig(t, `
+db-user: sa
+`)
+	t.Cleanup(func() { noPrompt.Store(false) })
+	DisablePrompt()
+
+	cfg, err := ConfigFor("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.resolvePassword(); err == nil {
+		t.Error("resolved a password without a source")
+	}
+	viper.Set("db-password-cmd", "echo secret")
+	if runtime.GOOS != "windows" {
+		cfg, _ = ConfigFor("")
+		if err := cfg.resolvePassword(); err != nil || cfg.Password != "secret" {
+			t.Errorf("got %q, %v, want the password of the command", cfg.Password, err)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// DefaultProfile is the name of the connection built from the flat db-* keys,
+// it is used when no connection profiles are configured.
+const DefaultProfile = "default"
+
+// Config describes a single database connection.
+//
+// Profiles live under the connections key of the config file:
+//
+//	default-connection: dev
+//	connections:
+//	  dev:
+//	    host: localhost:1433
+//	    database: DETPLAN
+//	    user: myuser@domain.com
+//	  prod:
+//	    host: sql-prod.corp.local:1433
+//	    database: DETPLAN
+//	    auth: sql
+//	    password-cmd: pass show sql/prod
+//	    read-only: true
+//	  azure:
+//	    host: myserver.database.windows.net
+//	    database: DETPLAN
+//	    auth: ActiveDirectoryDefault
+//	  offline:
+//	    driver: sqlite
+//	    fixtures: [testdata/schema.sql]
+//
+// Fields left out of a profile fall back to the flat db-* keys and flags,
+// except the password, password-file and password-cmd, a profile only uses
+// its own. Viper lowercases the keys of the file, so profile names are
+// matched case-insensitively.
+type Config struct {
+	Name      string
+	Host      string
+	Database  string
+	User      string
+	Password  string
+	Auth      string
+	Encrypt   bool
+	TrustCert bool
+	// ReadOnly runs every query in a transaction that is rolled back, a
+	// best effort that batches ending the transaction themselves escape,
+	// only a login without write permissions guarantees it
+	ReadOnly bool
+
+	// Driver is sqlserver or sqlite, Path and Fixtures only apply to sqlite.
+	Driver   string
+	Path     string
+	Fixtures []string
+
+	// AppClientID is the Azure AD application used by the
+	// ActiveDirectoryPassword and ActiveDirectoryInteractive modes.
+	AppClientID string
+	// Krb5Conf, Keytab and Krb5Realm configure the krb5 mode.
+	Krb5Conf  string
+	Keytab    string
+	Krb5Realm string
+
+	// PasswordFile, PasswordCmd and Keyring are tried in that order when
+	// Password is empty, see resolvePassword.
+	PasswordFile string
+	PasswordCmd  string
+	Keyring      bool
+
+	// Instance and Port can also be given in Host as host\instance or
+	// host:port, the fields win over the host form.
+	Instance          string
+	Port              int
+	AppName           string
+	ApplicationIntent string
+	ConnectionTimeout time.Duration
+	DialTimeout       time.Duration
+	PacketSize        int
+	// CAFile is a .pem or .der file with the CA certificates that are
+	// trusted for the server certificate instead of the system roots.
+	CAFile       string
+	CertHostname string
+	// Strict uses TDS 8 where TLS is set up before any TDS traffic.
+	Strict bool
+	TLSMin string
+
+	// DSN is a complete connection string used as is, it replaces every
+	// other connection setting except Auth, which picks the driver.
+	DSN string
+}
+
+// Info is the part of a connection that is safe to show to users.
+type Info struct {
+	Name     string `json:"name"`
+	Driver   string `json:"driver"`
+	Host     string `json:"host"`
+	Database string `json:"database"`
+	Auth     string `json:"auth"`
+	ReadOnly bool   `json:"readOnly"`
+	Default  bool   `json:"default"`
+}
+
+// profile mirrors the config file layout, pointers tell unset fields apart
+type profile struct {
+	Driver    string   `mapstructure:"driver"`
+	Path      string   `mapstructure:"path"`
+	Fixtures  []string `mapstructure:"fixtures"`
+	Host      string   `mapstructure:"host"`
+	Database  string   `mapstructure:"database"`
+	User      string   `mapstructure:"user"`
+	Password  string   `mapstructure:"password"`
+	Auth      string   `mapstructure:"auth"`
+	Encrypt   *bool    `mapstructure:"encrypt"`
+	TrustCert *bool    `mapstructure:"trust-cert"`
+	ReadOnly  *bool    `mapstructure:"read-only"`
+
+	AppClientID string `mapstructure:"app-client-id"`
+	Krb5Conf    string `mapstructure:"krb5-conf"`
+	Keytab      string `mapstructure:"keytab"`
+	Krb5Realm   string `mapstructure:"krb5-realm"`
+
+	PasswordFile string `mapstructure:"password-file"`
+	PasswordCmd  string `mapstructure:"password-cmd"`
+	Keyring      *bool  `mapstructure:"keyring"`
+
+	Instance          string        `mapstructure:"instance"`
+	Port              int           `mapstructure:"port"`
+	AppName           string        `mapstructure:"app-name"`
+	ApplicationIntent string        `mapstructure:"application-intent"`
+	ConnectionTimeout time.Duration `mapstructure:"connection-timeout"`
+	DialTimeout       time.Duration `mapstructure:"dial-timeout"`
+	PacketSize        int           `mapstructure:"packet-size"`
+	DSN               string        `mapstructure:"dsn"`
+
+	CAFile       string `mapstructure:"ca-file"`
+	CertHostname string `mapstructure:"cert-hostname"`
+	Strict       *bool  `mapstructure:"strict"`
+	TLSMin       string `mapstructure:"tls-min"`
+}
+
+// SelectedProfile returns the profile picked with --connection, the
+// default-connection key or DefaultProfile, in that order.
+func SelectedProfile() string {
+	if name := viper.GetString("connection"); name != "" {
+		return ProfileName(name)
+	}
+	if name := viper.GetString("default-connection"); name != "" {
+		return ProfileName(name)
+	}
+	if names := ProfileNames(); len(names) == 1 {
+		return names[0]
+	}
+	return DefaultProfile
+}
+
+// ProfileName returns the name a profile is configured under, profile names
+// are lowercase as viper lowercases the keys of the config file.
+func ProfileName(name string) string {
+	return strings.ToLower(name)
+}
+
+// ProfileNames returns the sorted names of the configured connection profiles.
+func ProfileNames() []string {
+	profiles := viper.GetStringMap("connections")
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ConfigFor resolves the named connection profile, DefaultProfile (or an
+// empty name) gives the connection described by the flat db-* keys.
+func ConfigFor(name string) (*Config, error) {
+	name = ProfileName(name)
+	cfg := &Config{
+		Name:      DefaultProfile,
+		Driver:    viper.GetString("db-driver"),
+		Path:      viper.GetString("db-path"),
+		Fixtures:  viper.GetStringSlice("db-fixture"),
+		Host:      viper.GetString("db-host"),
+		Database:  viper.GetString("db"),
+		User:      viper.GetString("db-user"),
+		Password:  viper.GetString("db-password"),
+		Auth:      viper.GetString("db-auth"),
+		Encrypt:   viper.GetBool("db-encrypt"),
+		TrustCert: viper.GetBool("db-trust-cert"),
+		ReadOnly:  viper.GetBool("db-read-only"),
+
+		AppClientID: viper.GetString("db-app-client-id"),
+		Krb5Conf:    viper.GetString("db-krb5-conf"),
+		Keytab:      viper.GetString("db-keytab"),
+		Krb5Realm:   viper.GetString("db-krb5-realm"),
+
+		PasswordFile: viper.GetString("db-password-file"),
+		PasswordCmd:  viper.GetString("db-password-cmd"),
+		Keyring:      viper.GetBool("db-keyring"),
+
+		Instance:          viper.GetString("db-instance"),
+		Port:              viper.GetInt("db-port"),
+		AppName:           viper.GetString("db-app-name"),
+		ApplicationIntent: viper.GetString("db-application-intent"),
+		ConnectionTimeout: viper.GetDuration("db-connection-timeout"),
+		DialTimeout:       viper.GetDuration("db-dial-timeout"),
+		PacketSize:        viper.GetInt("db-packet-size"),
+		DSN:               viper.GetString("dsn"),
+
+		CAFile:       viper.GetString("db-ca-file"),
+		CertHostname: viper.GetString("db-cert-hostname"),
+		Strict:       viper.GetBool("db-strict"),
+		TLSMin:       viper.GetString("db-tls-min"),
+	}
+	if name == "" || (name == DefaultProfile && !viper.IsSet("connections."+DefaultProfile)) {
+		return cfg, cfg.normalize()
+	}
+
+	if !viper.IsSet("connections." + name) {
+		return nil, fmt.Errorf("no connection profile named %q, configured profiles: %v", name, ProfileNames())
+	}
+	var p profile
+	if err := viper.UnmarshalKey("connections."+name, &p); err != nil {
+		return nil, fmt.Errorf("invalid connection profile %q: %w", name, err)
+	}
+
+	cfg.Name = name
+	if p.Driver != "" {
+		cfg.Driver = p.Driver
+	}
+	if p.Path != "" {
+		cfg.Path = p.Path
+	}
+	if len(p.Fixtures) > 0 {
+		cfg.Fixtures = p.Fixtures
+	}
+	if p.Host != "" {
+		cfg.Host = p.Host
+	}
+	if p.Database != "" {
+		cfg.Database = p.Database
+	}
+	if p.User != "" {
+		cfg.User = p.User
+	}
+	// the flat secret sources belong to the default connection, a profile
+	// for another server must not send them there
+	cfg.Password = p.Password
+	cfg.PasswordFile = p.PasswordFile
+	cfg.PasswordCmd = p.PasswordCmd
+	if p.Keyring != nil {
+		cfg.Keyring = *p.Keyring
+	}
+	if p.Host != "" {
+		// the host form of the profile decides unless the profile sets them
+		cfg.Instance, cfg.Port = p.Instance, p.Port
+	}
+	if p.Instance != "" {
+		cfg.Instance = p.Instance
+	}
+	if p.Port != 0 {
+		cfg.Port = p.Port
+	}
+	if p.AppName != "" {
+		cfg.AppName = p.AppName
+	}
+	if p.ApplicationIntent != "" {
+		cfg.ApplicationIntent = p.ApplicationIntent
+	}
+	if p.ConnectionTimeout != 0 {
+		cfg.ConnectionTimeout = p.ConnectionTimeout
+	}
+	if p.DialTimeout != 0 {
+		cfg.DialTimeout = p.DialTimeout
+	}
+	if p.PacketSize != 0 {
+		cfg.PacketSize = p.PacketSize
+	}
+	if p.CAFile != "" {
+		cfg.CAFile = p.CAFile
+	}
+	if p.CertHostname != "" {
+		cfg.CertHostname = p.CertHostname
+	}
+	if p.Strict != nil {
+		cfg.Strict = *p.Strict
+	}
+	if p.TLSMin != "" {
+		cfg.TLSMin = p.TLSMin
+	}
+	// a flat dsn only applies to the default connection
+	cfg.DSN = p.DSN
+	if p.Auth != "" {
+		cfg.Auth = p.Auth
+	}
+	if p.Encrypt != nil {
+		cfg.Encrypt = *p.Encrypt
+	}
+	if p.TrustCert != nil {
+		cfg.TrustCert = *p.TrustCert
+	}
+	if p.ReadOnly != nil {
+		cfg.ReadOnly = *p.ReadOnly
+	}
+	if p.AppClientID != "" {
+		cfg.AppClientID = p.AppClientID
+	}
+	if p.Krb5Conf != "" {
+		cfg.Krb5Conf = p.Krb5Conf
+	}
+	if p.Keytab != "" {
+		cfg.Keytab = p.Keytab
+	}
+	if p.Krb5Realm != "" {
+		cfg.Krb5Realm = p.Krb5Realm
+	}
+	return cfg, cfg.normalize()
+}
+
+func (c *Config) normalize() error {
+	driver, err := normalizeDriver(c.Driver)
+	if err != nil {
+		return fmt.Errorf("connection %q: %w", c.Name, err)
+	}
+	c.Driver = driver
+
+	auth, err := normalizeAuth(c.Auth)
+	if err != nil {
+		return fmt.Errorf("connection %q: %w", c.Name, err)
+	}
+	c.Auth = auth
+	return nil
+}
+
+// Info returns the non secret details of the connection.
+func (c *Config) Info() Info {
+	return Info{
+		Name:     c.Name,
+		Driver:   c.Driver,
+		Host:     c.Host,
+		Database: c.Database,
+		Auth:     c.Auth,
+		ReadOnly: c.ReadOnly,
+		Default:  c.Name == SelectedProfile(),
+	}
+}
+
+// Connections lists the configured profiles, or only the default
+// connection when no profiles are configured.
+func Connections() ([]Info, error) {
+	names := ProfileNames()
+	if len(names) == 0 {
+		names = []string{DefaultProfile}
+	}
+
+	infos := make([]Info, 0, len(names))
+	for _, name := range names {
+		cfg, err := ConfigFor(name)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, cfg.Info())
+	}
+	return infos, nil
+}
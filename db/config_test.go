@@ -0,0 +1,99 @@
+package db
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// useConfig replaces the viper config with the yaml for the test.
+func useConfig(t *testing.T, yaml string) {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(strings.NewReader(yaml)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigForProfiles(t *testing.T) {
+	useConfig(t, `
+db-host: flat.example.com
+db-user: flat-user
+db-password: flat-secret
+db-password-cmd: pass show flat
+db-keyring: true
+connections:
+  Prod:
+    host: prod.example.com
+    database: DETPLAN
+    password-file: /run/secrets/prod
+  dev:
+    database: DEV
+`)
+
+	tests := []struct {
+		name     string
+		want     Config
+		wantName string
+	}{
+		{
+			name:     "Prod",
+			wantName: "prod",
+			want:     Config{Host: "prod.example.com", Database: "DETPLAN", User: "flat-user", PasswordFile: "/run/secrets/prod", Keyring: true},
+		},
+		{
+			name:     "PROD",
+			wantName: "prod",
+			want:     Config{Host: "prod.example.com", Database: "DETPLAN", User: "flat-user", PasswordFile: "/run/secrets/prod", Keyring: true},
+		},
+		{
+			name:     "dev",
+			wantName: "dev",
+			want:     Config{Host: "flat.example.com", Database: "DEV", User: "flat-user", Keyring: true},
+		},
+		{
+			name:     "",
+			wantName: DefaultProfile,
+			want:     Config{Host: "flat.example.com", User: "flat-user", Password: "flat-secret", PasswordCmd: "pass show flat", Keyring: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ConfigFor(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Name != tt.wantName {
+				t.Errorf("Name = %q, want %q", cfg.Name, tt.wantName)
+			}
+			got := Config{Host: cfg.Host, Database: cfg.Database, User: cfg.User, Password: cfg.Password, PasswordFile: cfg.PasswordFile, PasswordCmd: cfg.PasswordCmd, Keyring: cfg.Keyring}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ConfigFor("staging"); err == nil {
+		t.Error("got a config for a profile that does not exist")
+	}
+}
+
+func TestSelectedProfile(t *testing.T) {
+	useConfig(t, `
+default-connection: Dev
+connections:
+  dev: {}
+  prod: {}
+`)
+	if got := SelectedProfile(); got != "dev" {
+		t.Errorf("SelectedProfile() = %q, want dev", got)
+	}
+	viper.Set("connection", "PROD")
+	if got := SelectedProfile(); got != "prod" {
+		t.Errorf("SelectedProfile() = %q, want prod", got)
+	}
+}
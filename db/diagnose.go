@@ -0,0 +1,204 @@
+package db
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/microsoft/go-mssqldb/azuread"
+	"github.com/microsoft/go-mssqldb/msdsn"
+)
+
+// Diagnostics describes how a connection attempt went.
+type Diagnostics struct {
+	Info       Info
+	DSN        string // with the password redacted
+	Driver     string
+	Encryption string
+	Elapsed    time.Duration
+
+	TLS *TLSDetails
+	// Server is nil when the connection failed
+	Server *ServerDetails
+	Err    error
+}
+
+// TLSDetails is the outcome of the TLS handshake with the server.
+type TLSDetails struct {
+	Version     string
+	CipherSuite string
+	ServerName  string
+	Certificate []CertificateDetails
+	// VerifyErr is set when the certificate was not trusted, the handshake is
+	// still captured to show what the server presented.
+	VerifyErr error
+	Verified  bool
+}
+
+// CertificateDetails is a certificate in the chain presented by the server.
+type CertificateDetails struct {
+	Subject   string
+	Issuer    string
+	DNSNames  []string
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// ServerDetails is what the server reports about the session.
+type ServerDetails struct {
+	Version         string
+	Login           string
+	Database        string
+	EncryptOption   string
+	AuthScheme      string
+	Transport       string
+	ProtocolVersion string
+}
+
+// Diagnose connects to the named connection without the shared instance and
+// reports the TLS handshake and session details. Azure AD connections do not
+// expose the TLS handshake, only the session details are reported for them.
+func Diagnose(ctx context.Context, name string) (*Diagnostics, error) {
+	cfg, err := ConfigFor(name)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Driver != DriverSQLServer {
+		return nil, fmt.Errorf("connection %q uses the %s driver, diagnose only supports SQL Server", cfg.Name, cfg.Driver)
+	}
+	d := &Diagnostics{Info: cfg.Info(), Driver: cfg.driverName()}
+
+	if err := cfg.resolvePassword(); err != nil {
+		return nil, err
+	}
+	dsn, err := cfg.connString()
+	if err != nil {
+		return nil, err
+	}
+	d.DSN = RedactedDSN(dsn)
+
+	params, err := msdsn.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection string: %w", err)
+	}
+	d.Encryption = encryptionName(params.Encryption)
+
+	var connector *mssql.Connector
+	if d.Driver == azuread.DriverName {
+		if connector, err = azuread.NewConnector(dsn); err != nil {
+			return nil, err
+		}
+	} else {
+		if params.TLSConfig != nil {
+			d.TLS = &TLSDetails{}
+			params.TLSConfig = captureTLS(params.TLSConfig, d.TLS)
+		}
+		connector = mssql.NewConnectorConfig(params)
+	}
+
+	conn := sql.OpenDB(connector)
+	defer conn.Close()
+
+	start := time.Now()
+	d.Err = conn.PingContext(ctx)
+	d.Elapsed = time.Since(start)
+	if d.Err != nil && d.TLS != nil && d.TLS.VerifyErr != nil && !strings.Contains(d.Err.Error(), d.TLS.VerifyErr.Error()) {
+		d.Err = fmt.Errorf("%w (certificate: %v)", d.Err, d.TLS.VerifyErr)
+	}
+	if d.Err != nil {
+		return d, nil
+	}
+
+	d.Server, d.Err = serverDetails(ctx, conn)
+	return d, nil
+}
+
+// captureTLS returns a copy of the config that records the handshake in
+// details. Verification is done by hand so the server certificate can be
+// shown even when it is not trusted.
+func captureTLS(orig *tls.Config, details *TLSDetails) *tls.Config {
+	c := orig.Clone()
+	c.InsecureSkipVerify = true
+	c.VerifyConnection = func(cs tls.ConnectionState) error {
+		details.Version = tls.VersionName(cs.Version)
+		details.CipherSuite = tls.CipherSuiteName(cs.CipherSuite)
+		details.ServerName = orig.ServerName
+		for _, cert := range cs.PeerCertificates {
+			details.Certificate = append(details.Certificate, CertificateDetails{
+				Subject:   cert.Subject.String(),
+				Issuer:    cert.Issuer.String(),
+				DNSNames:  cert.DNSNames,
+				NotBefore: cert.NotBefore,
+				NotAfter:  cert.NotAfter,
+			})
+		}
+		if orig.InsecureSkipVerify {
+			return nil
+		}
+		if len(cs.PeerCertificates) == 0 {
+			details.VerifyErr = errors.New("the server sent no certificate")
+			return details.VerifyErr
+		}
+		opts := x509.VerifyOptions{
+			Roots:         orig.RootCAs,
+			DNSName:       orig.ServerName,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+			details.VerifyErr = err
+			return err
+		}
+		details.Verified = true
+		return nil
+	}
+	return c
+}
+
+func serverDetails(ctx context.Context, conn *sql.DB) (*ServerDetails, error) {
+	s := &ServerDetails{}
+	err := conn.QueryRowContext(ctx, "SELECT @@VERSION, SUSER_SNAME(), DB_NAME()").
+		Scan(&s.Version, &s.Login, &s.Database)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server details: %w", err)
+	}
+
+	// needs VIEW SERVER STATE on older servers, leave the fields empty without it
+	var encrypt, scheme, transport sql.NullString
+	var protocol sql.NullInt64
+	err = conn.QueryRowContext(ctx, `SELECT encrypt_option, auth_scheme, net_transport, protocol_version
+		FROM sys.dm_exec_connections WHERE session_id = @@SPID`).
+		Scan(&encrypt, &scheme, &transport, &protocol)
+	if err == nil {
+		s.EncryptOption = encrypt.String
+		s.AuthScheme = scheme.String
+		s.Transport = transport.String
+		if protocol.Valid {
+			s.ProtocolVersion = fmt.Sprintf("0x%08X", protocol.Int64)
+		}
+	}
+	return s, nil
+}
+
+func encryptionName(e msdsn.Encryption) string {
+	switch e {
+	case msdsn.EncryptionOff:
+		return "login only"
+	case msdsn.EncryptionRequired:
+		return "required"
+	case msdsn.EncryptionDisabled:
+		return "disabled"
+	case msdsn.EncryptionStrict:
+		return "strict (TDS 8)"
+	default:
+		return fmt.Sprintf("unknown (%d)", e)
+	}
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Phillezi/common/utils/or"
+)
+
+// DefaultAppName is reported to the server as the application name.
+const DefaultAppName = "gaspecgen"
+
+// connString builds the sqlserver:// connection URL, every part is escaped
+// by net/url so passwords and database names may contain any character.
+func (c *Config) connString() (string, error) {
+	if c.DSN != "" {
+		return c.DSN, nil
+	}
+
+	params, user, err := c.authParams()
+	if err != nil {
+		return "", err
+	}
+	host, instance, port, err := c.address()
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{}
+	if c.Database != "" {
+		q.Set("database", c.Database)
+	}
+	if err := c.tlsParams(q); err != nil {
+		return "", err
+	}
+	intent, err := c.applicationIntent()
+	if err != nil {
+		return "", err
+	}
+	if intent != "" {
+		q.Set("ApplicationIntent", intent)
+	}
+	q.Set("app name", or.Or(c.AppName, DefaultAppName))
+	if c.ConnectionTimeout > 0 {
+		q.Set("connection timeout", strconv.Itoa(int(c.ConnectionTimeout.Seconds())))
+	}
+	if c.DialTimeout > 0 {
+		q.Set("dial timeout", strconv.Itoa(int(c.DialTimeout.Seconds())))
+	}
+	if c.PacketSize > 0 {
+		q.Set("packet size", strconv.Itoa(c.PacketSize))
+	}
+	for k, v := range params {
+		q.Set(k, v)
+	}
+	q.Set("log", strconv.FormatUint(uint64(driverLogFlags()|messageLogFlags), 10))
+
+	u := &url.URL{
+		Scheme:   "sqlserver",
+		User:     user,
+		Host:     host,
+		RawQuery: q.Encode(),
+	}
+	if port != 0 {
+		u.Host = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	if instance != "" {
+		u.Path = "/" + instance
+	}
+	return u.String(), nil
+}
+
+// address splits Host into host, instance and port, accepting host,
+// host:port, host\instance and host\instance:port. The Instance and Port
+// fields override the parts found in Host.
+func (c *Config) address() (host, instance string, port int, err error) {
+	host = strings.TrimSpace(c.Host)
+	if h, p, splitErr := net.SplitHostPort(host); splitErr == nil {
+		if port, err = strconv.Atoi(p); err != nil {
+			return "", "", 0, fmt.Errorf("invalid port %q in host %q", p, c.Host)
+		}
+		host = h
+	}
+	if h, inst, ok := strings.Cut(host, `\`); ok {
+		host, instance = h, inst
+	}
+	if c.Instance != "" {
+		instance = c.Instance
+	}
+	if c.Port != 0 {
+		port = c.Port
+	}
+	if host == "" {
+		return "", "", 0, fmt.Errorf("no host configured for connection %q", c.Name)
+	}
+	return host, instance, port, nil
+}
+
+func (c *Config) applicationIntent() (string, error) {
+	switch strings.ToLower(c.ApplicationIntent) {
+	case "":
+		if c.ReadOnly {
+			return "ReadOnly", nil
+		}
+		return "", nil
+	case "readonly":
+		return "ReadOnly", nil
+	case "readwrite":
+		if c.ReadOnly {
+			return "", fmt.Errorf("connection %q is read-only but asks for the ReadWrite application intent", c.Name)
+		}
+		return "ReadWrite", nil
+	default:
+		return "", fmt.Errorf("invalid application intent %q, expected ReadOnly or ReadWrite", c.ApplicationIntent)
+	}
+}
+
+// cacheSource returns the redacted connection string, or the database file
+// and fixtures for SQLite, so cached results are only shared by connections
+// to the same database. The driver log level is left out.
+func (c *Config) cacheSource() string {
+	if c.Driver == DriverSQLite {
+		return DriverSQLite + ":" + c.Path + "?" + strings.Join(c.Fixtures, ",")
+	}
+	dsn, err := c.connString()
+	if err != nil {
+		return ""
+	}
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		q := u.Query()
+		q.Del("log")
+		u.RawQuery = q.Encode()
+		dsn = u.String()
+	}
+	return RedactedDSN(dsn)
+}
+
+// RedactedDSN returns the connection string with the password masked, for
+// logs and diagnostics.
+func RedactedDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" {
+		// ADO and ODBC style strings, mask the password=... pair
+		parts := strings.Split(dsn, ";")
+		for i, part := range parts {
+			if k, _, ok := strings.Cut(part, "="); ok {
+				switch strings.ToLower(strings.TrimSpace(k)) {
+				case "password", "pwd":
+					parts[i] = k + "=xxxxx"
+				}
+			}
+		}
+		return strings.Join(parts, ";")
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	DriverSQLServer = "sqlserver"
+	DriverSQLite    = "sqlite"
+)
+
+// Executor runs rendered queries. The SQL Server pool (DB) is the default,
+// SQLite lets templates be tried offline against a fixture database.
+type Executor interface {
+	// Execute runs the query and returns every result set it produced.
+	Execute(ctx context.Context, query string) ([]*Result, error)
+	// Query runs the query and returns its first result set.
+	Query(ctx context.Context, query string) (*Result, error)
+	Config() *Config
+	Close() error
+}
+
+var (
+	_ Executor = (*DB)(nil)
+	_ Executor = (*SQLite)(nil)
+)
+
+// Open returns the shared executor for the selected connection profile.
+func Open(ctx context.Context) (Executor, error) {
+	return OpenNamed(ctx, SelectedProfile())
+}
+
+// OpenNamed returns the shared executor for the named connection profile,
+// the driver setting of the profile picks the backend.
+//
+// With --replay the queries are answered from the cassette without touching
+// the database, with --record the executor writes every query to it. With
+// --cache repeated queries are answered from the result cache.
+func OpenNamed(ctx context.Context, name string) (Executor, error) {
+	cfg, err := ConfigFor(name)
+	if err != nil {
+		return nil, err
+	}
+
+	record, replay := viper.GetString("record"), viper.GetString("replay")
+	if record != "" && replay != "" {
+		return nil, fmt.Errorf("--record and --replay cannot be used together")
+	}
+	if replay != "" {
+		c, err := LoadCassette(replay)
+		if err != nil {
+			return nil, err
+		}
+		return NewReplayer(c, cfg), nil
+	}
+
+	var e Executor
+	switch cfg.Driver {
+	case DriverSQLServer:
+		e, err = GetNamed(ctx, name)
+	case DriverSQLite:
+		e, err = instance(cfg.Name, func() (Executor, error) {
+			return openSQLite(ctx, cfg)
+		})
+	default:
+		err = fmt.Errorf("unsupported driver %q for connection %q", cfg.Driver, cfg.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := openResultCache()
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		e = NewCached(e, cache)
+	}
+	// the recorder wraps the cache so cache hits are recorded too, replay
+	// needs every query the export ran
+	if record != "" {
+		c, err := NewCassette(record)
+		if err != nil {
+			return nil, err
+		}
+		e = NewRecorder(e, c)
+	}
+	return e, nil
+}
+
+func normalizeDriver(driver string) (string, error) {
+	switch driver {
+	case "", "sqlserver", "mssql":
+		return DriverSQLServer, nil
+	case "sqlite", "sqlite3":
+		return DriverSQLite, nil
+	default:
+		return "", fmt.Errorf("unsupported driver %q, expected sqlserver or sqlite", driver)
+	}
+}
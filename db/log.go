@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/microsoft/go-mssqldb/msdsn"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// defaultDebugLogFlags is used in debug mode when --db-driver-log is not set.
+// Parameter values (msdsn.LogParams) are left out since they can hold secrets.
+const defaultDebugLogFlags = msdsn.LogErrors | msdsn.LogMessages | msdsn.LogRows |
+	msdsn.LogSQL | msdsn.LogTransaction | msdsn.LogRetries
+
+// messageLogFlags are always requested from the driver so Messages can
+// collect them, they only reach the log when driverLogFlags asks for them.
+const messageLogFlags = msdsn.LogMessages | msdsn.LogRows
+
+func init() {
+	mssql.SetContextLogger(driverLogger{})
+}
+
+// driverLogFlags returns the go-mssqldb log flags for the connection string.
+func driverLogFlags() msdsn.Log {
+	if viper.IsSet("db-driver-log") {
+		return msdsn.Log(viper.GetUint64("db-driver-log"))
+	}
+	if viper.GetString("loglevel") == "debug" {
+		return defaultDebugLogFlags
+	}
+	return 0
+}
+
+// driverLogger forwards the driver logs to zap, without it the log flags in
+// the connection string have no effect. Server messages and row counts go
+// to the Messages of the query context.
+type driverLogger struct{}
+
+func (driverLogger) Log(ctx context.Context, category msdsn.Log, msg string) {
+	if category&messageLogFlags != 0 {
+		if m, ok := ctx.Value(messagesKey{}).(*Messages); ok {
+			m.add(msg)
+		}
+	}
+	if category&driverLogFlags() != 0 {
+		zap.L().Debug(msg, zap.String("source", "mssql"), zap.Uint64("category", uint64(category)))
+	}
+}
+
+type messagesKey struct{}
+
+// Messages collects the informational messages of the queries run with its
+// context, e.g. PRINT output and "(1 rows affected)". Only SQL Server
+// connections built from the connection settings (not --dsn) report them,
+// answers from the result cache are noted on every connection.
+type Messages struct {
+	mu   sync.Mutex
+	list []string
+}
+
+// WithMessages returns a context collecting the messages of the queries run
+// with it.
+func WithMessages(ctx context.Context) (context.Context, *Messages) {
+	m := &Messages{}
+	return context.WithValue(ctx, messagesKey{}, m), m
+}
+
+func (m *Messages) add(msg string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.list = append(m.list, msg)
+}
+
+// List returns the messages collected so far.
+func (m *Messages) List() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.list)
+}
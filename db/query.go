@@ -0,0 +1,269 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Result is a fully read result set with every value converted to a string,
+// NULL values become empty strings and are marked in Nulls. Dates and times
+// are written in ISO 8601.
+type Result struct {
+	Columns []string
+	// Types are the database type names of the columns, e.g. NVARCHAR
+	Types []string
+	Rows  []map[string]string
+	// Nulls marks the NULL values of every row by column, it is nil when
+	// the set has none and rows without NULLs have a nil map
+	Nulls []map[string]bool
+	// CachedAt is when the result set was stored, zero when it was not
+	// served from the result cache
+	CachedAt time.Time
+	// Truncated is set when the set had more rows than the row limit of
+	// the context
+	Truncated bool
+}
+
+type rowLimitKey struct{}
+
+// WithRowLimit returns a copy of ctx reading at most n rows of every result
+// set, the rest is skipped without being read into memory. Sets that are
+// cut short are marked Truncated and never cached or recorded.
+func WithRowLimit(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, rowLimitKey{}, n)
+}
+
+type dryRunKey struct{}
+
+// WithDryRun returns a copy of ctx running batches that may write in a
+// transaction that is always rolled back, as read-only connections do, so
+// previewing a template that writes leaves no trace. Batches that commit or
+// roll back transactions themselves are refused.
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// rowLimit returns the row limit of ctx, 0 when every row is read.
+func rowLimit(ctx context.Context) int {
+	n, _ := ctx.Value(rowLimitKey{}).(int)
+	return max(n, 0)
+}
+
+// truncated tells if any of the sets was cut short by a row limit.
+func truncated(sets []*Result) bool {
+	for _, res := range sets {
+		if res.Truncated {
+			return true
+		}
+	}
+	return false
+}
+
+// TypeMap returns the database type names by column.
+func (r *Result) TypeMap() map[string]string {
+	types := make(map[string]string, len(r.Columns))
+	for i, col := range r.Columns {
+		if i < len(r.Types) {
+			types[col] = r.Types[i]
+		}
+	}
+	return types
+}
+
+// FirstResult returns the first result set, or an empty one when the query
+// produced none.
+func FirstResult(sets []*Result) *Result {
+	if len(sets) == 0 {
+		return &Result{}
+	}
+	return sets[0]
+}
+
+// Execute runs the query and reads every result set it produces.
+func (d *DB) Execute(ctx context.Context, query string) ([]*Result, error) {
+	p := d.acquire()
+	defer p.users.Done()
+	return execute(ctx, p.connection, d.config.ReadOnly, query)
+}
+
+// Query runs the query and returns its first result set.
+func (d *DB) Query(ctx context.Context, query string) (*Result, error) {
+	sets, err := d.Execute(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return FirstResult(sets), nil
+}
+
+// execute runs the query on conn. Read-only connections run the query in a
+// transaction that is always rolled back, so templates that write to real
+// tables by mistake leave no trace. Batches that commit or roll back the
+// transaction themselves, and anything not covered by it, still write.
+//
+// Cancelling ctx cancels the running query on the server, query-timeout
+// bounds how long it may run.
+func execute(ctx context.Context, conn *sql.DB, readOnly bool, query string) ([]*Result, error) {
+	timeout := viper.GetDuration("query-timeout")
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if dryRun, _ := ctx.Value(dryRunKey{}).(bool); dryRun && !readOnly && !readOnlyBatch(query) {
+		if endsTransaction(query) {
+			return nil, errors.New("the batch commits or rolls back transactions itself, it cannot run as a dry run")
+		}
+		readOnly = true
+		if m, ok := ctx.Value(messagesKey{}).(*Messages); ok {
+			m.add("The batch may write, it ran in a transaction that was rolled back")
+		}
+	}
+
+	sets, err := run(ctx, conn, readOnly, query)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("query timed out after %s: %w", timeout, err)
+	}
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		return nil, fmt.Errorf("query cancelled: %w", err)
+	}
+	return sets, err
+}
+
+func run(ctx context.Context, conn *sql.DB, readOnly bool, query string) ([]*Result, error) {
+	if !readOnly {
+		stmt, err := conn.PrepareContext(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare query: %w", err)
+		}
+		defer stmt.Close()
+
+		rows, err := stmt.QueryContext(ctx)
+		return readAll(rows, err, rowLimit(ctx))
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin read-only transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			zap.L().Error("Failed to roll back read-only transaction", zap.Error(err))
+		}
+	}()
+
+	rows, err := tx.QueryContext(ctx, query)
+	return readAll(rows, err, rowLimit(ctx))
+}
+
+// readAll reads every result set, at most limit rows of each when limit is
+// positive.
+func readAll(rows *sql.Rows, err error, limit int) ([]*Result, error) {
+	if err != nil {
+		return nil, fmt.Errorf("query execution failed: %w", err)
+	}
+	defer rows.Close()
+
+	var sets []*Result
+	for {
+		res, err := readSet(rows, limit)
+		if err != nil {
+			return nil, err
+		}
+		// statements without output still show up as sets without columns
+		if len(res.Columns) > 0 {
+			sets = append(sets, res)
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+	return sets, nil
+}
+
+// formatValue converts a scanned value to its text, NULL is empty. Times are
+// written in ISO 8601 as fits the database type.
+func formatValue(v any, dbType string) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case time.Time:
+		switch strings.ToUpper(dbType) {
+		case "DATE":
+			return v.Format(time.DateOnly)
+		case "TIME":
+			return v.Format("15:04:05.9999999")
+		case "DATETIMEOFFSET":
+			return v.Format("2006-01-02 15:04:05.9999999 -07:00")
+		default:
+			return v.Format("2006-01-02 15:04:05.9999999")
+		}
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func readSet(rows *sql.Rows, limit int) (*Result, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns: %w", err)
+	}
+
+	res := &Result{Columns: columns, Types: make([]string, len(columns))}
+	if types, err := rows.ColumnTypes(); err == nil {
+		for i, t := range types {
+			res.Types[i] = t.DatabaseTypeName()
+		}
+	}
+	for rows.Next() {
+		// the rest of the set is discarded by NextResultSet or Close
+		if limit > 0 && len(res.Rows) == limit {
+			res.Truncated = true
+			break
+		}
+		values := make([]any, len(columns))
+		valuePtrs := make([]any, len(columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+
+		rowMap := make(map[string]string, len(columns))
+		var nulls map[string]bool
+		for i, col := range columns {
+			if values[i] == nil {
+				if nulls == nil {
+					nulls = map[string]bool{}
+				}
+				nulls[col] = true
+			}
+			rowMap[col] = formatValue(values[i], res.Types[i])
+		}
+		if nulls != nil && res.Nulls == nil {
+			res.Nulls = make([]map[string]bool, len(res.Rows), cap(res.Rows))
+		}
+		if res.Nulls != nil {
+			res.Nulls = append(res.Nulls, nulls)
+		}
+		res.Rows = append(res.Rows, rowMap)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+	return res, nil
+}
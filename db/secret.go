@@ -0,0 +1,152 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/term"
+)
+
+// KeyringService is the service name passwords are stored under in the OS keyring.
+const KeyringService = "gaspecgen"
+
+var (
+	// promptMu keeps concurrent connections from prompting at the same time
+	promptMu sync.Mutex
+	// noPrompt is set by DisablePrompt
+	noPrompt atomic.Bool
+)
+
+// DisablePrompt makes a missing password an error instead of asking for it
+// on the terminal. The server sets it, nobody answers a prompt there and
+// requests would wait on it.
+func DisablePrompt() {
+	noPrompt.Store(true)
+}
+
+// KeyringAccount returns the keyring entry name for the connection, it is
+// based on the login so profiles sharing an account share the password.
+func (c *Config) KeyringAccount() string {
+	return c.User + "@" + c.Host
+}
+
+// needsPassword tells if the auth mode logs in with the password.
+func (c *Config) needsPassword() bool {
+	if c.DSN != "" {
+		return false
+	}
+	switch c.Auth {
+	case AuthSQL, AuthADPassword, AuthADServicePrincipal:
+		return c.User != ""
+	case AuthKerberos:
+		return c.User != "" && c.Keytab == ""
+	default:
+		return false
+	}
+}
+
+// resolvePassword fills in the password when it is not set directly. The
+// sources are tried in order: password file, password command, OS keyring
+// and finally an interactive prompt when stdin is a terminal.
+func (c *Config) resolvePassword() error {
+	if c.Password != "" || !c.needsPassword() {
+		return nil
+	}
+
+	if c.PasswordFile != "" {
+		b, err := os.ReadFile(c.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("failed to read password file for connection %q: %w", c.Name, err)
+		}
+		c.Password = strings.TrimRight(string(b), "\r\n")
+		return nil
+	}
+
+	if c.PasswordCmd != "" {
+		password, err := runPasswordCmd(c.PasswordCmd)
+		if err != nil {
+			return fmt.Errorf("password command for connection %q failed: %w", c.Name, err)
+		}
+		c.Password = password
+		return nil
+	}
+
+	if c.Keyring {
+		password, err := keyring.Get(KeyringService, c.KeyringAccount())
+		if err == nil {
+			c.Password = password
+			return nil
+		}
+		if !errors.Is(err, keyring.ErrNotFound) {
+			return fmt.Errorf("failed to read password for %s from the keyring: %w", c.KeyringAccount(), err)
+		}
+	}
+
+	if noPrompt.Load() {
+		return fmt.Errorf("no password for connection %q, the server does not prompt for it, use --db-password-file, --db-password-cmd or --db-keyring", c.Name)
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("no password for connection %q, use --db-password-file, --db-password-cmd or --db-keyring when not running in a terminal", c.Name)
+	}
+	password, err := PromptPassword(fmt.Sprintf("Password for %s (%s): ", c.KeyringAccount(), c.Name))
+	if err != nil {
+		return err
+	}
+	c.Password = password
+	return nil
+}
+
+// PromptPassword reads a password from the terminal without echoing it, the
+// prompt is written to stderr to keep stdout free for output.
+func PromptPassword(prompt string) (string, error) {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return string(b), nil
+}
+
+// SetKeyringPassword stores the password for the connection in the OS keyring.
+func (c *Config) SetKeyringPassword(password string) error {
+	return keyring.Set(KeyringService, c.KeyringAccount(), password)
+}
+
+// DeleteKeyringPassword removes the password for the connection from the OS keyring.
+func (c *Config) DeleteKeyringPassword() error {
+	return keyring.Delete(KeyringService, c.KeyringAccount())
+}
+
+func runPasswordCmd(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	var stderr bytes.Buffer
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	// tools like pass print the secret on the first line
+	password, _, _ := strings.Cut(string(out), "\n")
+	return strings.TrimRight(password, "\r"), nil
+}
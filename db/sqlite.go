@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite"
+)
+
+// SQLite runs queries against a SQLite database, an in-memory one by
+// default. Fixture scripts are run when it is opened, so templates can be
+// developed without a SQL Server. The templates have to stick to SQL that
+// both dialects understand.
+type SQLite struct {
+	connection *sql.DB
+	config     *Config
+}
+
+func openSQLite(ctx context.Context, cfg *Config) (*SQLite, error) {
+	path := cfg.Path
+	if path == "" {
+		path = ":memory:"
+	}
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database %q: %w", path, err)
+	}
+	// every connection to :memory: is a separate database, and a single
+	// writer avoids SQLITE_BUSY for file databases
+	conn.SetMaxOpenConns(1)
+	conn.SetConnMaxLifetime(0)
+	conn.SetConnMaxIdleTime(0)
+
+	s := &SQLite{connection: conn, config: cfg}
+	for _, fixture := range cfg.Fixtures {
+		script, err := os.ReadFile(fixture)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to read fixture: %w", err)
+		}
+		if _, err := conn.ExecContext(ctx, string(script)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to load fixture %s: %w", fixture, err)
+		}
+		zap.L().Debug("Loaded fixture", zap.String("connection", cfg.Name), zap.String("fixture", fixture))
+	}
+	return s, nil
+}
+
+// Execute runs the query and reads every result set it produces.
+func (s *SQLite) Execute(ctx context.Context, query string) ([]*Result, error) {
+	return execute(ctx, s.connection, s.config.ReadOnly, query)
+}
+
+// Query runs the query and returns its first result set.
+func (s *SQLite) Query(ctx context.Context, query string) (*Result, error) {
+	sets, err := s.Execute(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return FirstResult(sets), nil
+}
+
+// Config returns the configuration the database was opened with.
+func (s *SQLite) Config() *Config {
+	return s.config
+}
+
+// Close closes the database, an in-memory database is gone afterwards.
+func (s *SQLite) Close() error {
+	forget(s)
+	return s.connection.Close()
+}
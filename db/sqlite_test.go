@@ -0,0 +1,181 @@
+package db
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testFixture = `
+CREATE TABLE articles (artNr TEXT PRIMARY KEY, qty INTEGER, price REAL, added DATE);
+INSERT INTO articles VALUES ('A-1', 5, 1.5, '2024-03-01'), ('A-2', NULL, 2, NULL);
+`
+
+// openTestSQLite opens an in-memory SQLite database with the articles
+// fixture.
+func openTestSQLite(t *testing.T, readOnly bool) *SQLite {
+	t.Helper()
+	fixture := filepath.Join(t.TempDir(), "fixture.sql")
+	if err := os.WriteFile(fixture, []byte(testFixture), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := openSQLite(context.Background(), &Config{Name: "test", Driver: DriverSQLite, Fixtures: []string{fixture}, ReadOnly: readOnly})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSQLiteExecute(t *testing.T) {
+	s := openTestSQLite(t, false)
+
+	sets, err := s.Execute(context.Background(), "SELECT artNr, qty, price FROM articles ORDER BY artNr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 1 {
+		t.Fatalf("got %d result sets, want 1", len(sets))
+	}
+	res := sets[0]
+	if want := []string{"artNr", "qty", "price"}; !reflect.DeepEqual(res.Columns, want) {
+		t.Errorf("columns = %v, want %v", res.Columns, want)
+	}
+	if want := []string{"TEXT", "INTEGER", "REAL"}; !reflect.DeepEqual(res.Types, want) {
+		t.Errorf("types = %v, want %v", res.Types, want)
+	}
+	want := []map[string]string{
+		{"artNr": "A-1", "qty": "5", "price": "1.5"},
+		{"artNr": "A-2", "qty": "", "price": "2"},
+	}
+	if !reflect.DeepEqual(res.Rows, want) {
+		t.Errorf("rows = %v, want %v", res.Rows, want)
+	}
+	if wantNulls := []map[string]bool{nil, {"qty": true}}; !reflect.DeepEqual(res.Nulls, wantNulls) {
+		t.Errorf("nulls = %v, want %v", res.Nulls, wantNulls)
+	}
+}
+
+func TestSQLiteExecuteWithoutNulls(t *testing.T) {
+	s := openTestSQLite(t, false)
+
+	res, err := s.Query(context.Background(), "SELECT artNr FROM articles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != 2 || res.Nulls != nil {
+		t.Errorf("got %d rows with nulls %v, want 2 rows without", len(res.Rows), res.Nulls)
+	}
+}
+
+func TestSQLiteExecuteErrors(t *testing.T) {
+	s := openTestSQLite(t, false)
+
+	if _, err := s.Execute(context.Background(), "SELECT nosuch FROM articles"); err == nil {
+		t.Error("got no error for an unknown column")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.Execute(ctx, "SELECT * FROM articles"); err == nil {
+		t.Error("got no error for a cancelled context")
+	}
+}
+
+func TestSQLiteReadOnly(t *testing.T) {
+	s := openTestSQLite(t, true)
+	ctx := context.Background()
+
+	if _, err := s.Execute(ctx, "DELETE FROM articles"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Query(ctx, "SELECT COUNT(*) AS n FROM articles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Rows[0]["n"]; got != "2" {
+		t.Errorf("read-only connection kept the delete, %s rows left", got)
+	}
+}
+
+func TestSQLiteWrites(t *testing.T) {
+	s := openTestSQLite(t, false)
+	ctx := context.Background()
+
+	sets, err := s.Execute(ctx, "DELETE FROM articles WHERE artNr = 'A-2'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 0 {
+		t.Errorf("got %d result sets for a delete, want none", len(sets))
+	}
+	res, err := s.Query(ctx, "SELECT COUNT(*) AS n FROM articles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Rows[0]["n"]; got != "1" {
+		t.Errorf("got %s rows after the delete, want 1", got)
+	}
+}
+
+func TestSQLiteRowLimit(t *testing.T) {
+	s := openTestSQLite(t, false)
+
+	for _, tt := range []struct {
+		limit     int
+		rows      int
+		truncated bool
+	}{
+		{0, 2, false},
+		{1, 1, true},
+		{2, 2, false},
+		{3, 2, false},
+	} {
+		res, err := s.Query(WithRowLimit(context.Background(), tt.limit), "SELECT artNr, qty FROM articles ORDER BY artNr")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Rows) != tt.rows || res.Truncated != tt.truncated {
+			t.Errorf("limit %d: got %d rows, truncated %t, want %d rows, truncated %t", tt.limit, len(res.Rows), res.Truncated, tt.rows, tt.truncated)
+		}
+		if len(res.Nulls) > len(res.Rows) {
+			t.Errorf("limit %d: got nulls for %d rows", tt.limit, len(res.Nulls))
+		}
+	}
+}
+
+func TestSQLiteDryRun(t *testing.T) {
+	s := openTestSQLite(t, false)
+	ctx, messages := WithMessages(WithDryRun(context.Background()))
+
+	res, err := s.Query(ctx, "UPDATE articles SET qty = 99 RETURNING artNr, qty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != 2 || res.Rows[0]["qty"] != "99" {
+		t.Errorf("got %v, want the updated rows", res.Rows)
+	}
+	if len(messages.List()) != 1 {
+		t.Errorf("got messages %v, want a note about the rollback", messages.List())
+	}
+	res, err = s.Query(context.Background(), "SELECT COUNT(*) AS n FROM articles WHERE qty = 99")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Rows[0]["n"]; got != "0" {
+		t.Errorf("the dry run kept the update of %s rows", got)
+	}
+
+	if _, err := s.Execute(ctx, "UPDATE articles SET qty = 99; COMMIT"); err == nil {
+		t.Error("ran a dry run that commits")
+	}
+	ctx, messages = WithMessages(WithDryRun(context.Background()))
+	if _, err := s.Execute(ctx, "SELECT artNr FROM articles"); err != nil {
+		t.Fatal(err)
+	}
+	if len(messages.List()) != 0 {
+		t.Errorf("got messages %v for a read-only batch", messages.List())
+	}
+}
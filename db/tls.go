@@ -0,0 +1,57 @@
+package db
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// TLSVersions lists the accepted values for the minimum TLS version.
+var TLSVersions = []string{"1.0", "1.1", "1.2", "1.3"}
+
+// tlsParams adds the encryption parameters to the connection string query.
+func (c *Config) tlsParams(q url.Values) error {
+	switch {
+	case c.Strict:
+		if c.TrustCert {
+			return fmt.Errorf("connection %q: strict encryption always verifies the server certificate, remove db-trust-cert", c.Name)
+		}
+		q.Set("encrypt", "strict")
+	default:
+		q.Set("encrypt", strconv.FormatBool(c.Encrypt))
+		if c.TrustCert {
+			if c.CAFile != "" {
+				return fmt.Errorf("connection %q: db-trust-cert disables verification, it cannot be combined with db-ca-file", c.Name)
+			}
+			q.Set("TrustServerCertificate", "true")
+		}
+	}
+
+	if c.CAFile != "" {
+		// the driver picks the format from the extension
+		switch strings.ToLower(filepath.Ext(c.CAFile)) {
+		case ".pem", ".der":
+		default:
+			return fmt.Errorf("connection %q: CA file %q must be a .pem or .der file", c.Name, c.CAFile)
+		}
+		if _, err := os.Stat(c.CAFile); err != nil {
+			return fmt.Errorf("connection %q: %w", c.Name, err)
+		}
+		q.Set("certificate", c.CAFile)
+	}
+	if c.CertHostname != "" {
+		q.Set("hostnameincertificate", c.CertHostname)
+	}
+	if c.TLSMin != "" {
+		v := strings.TrimPrefix(strings.ToLower(c.TLSMin), "tls")
+		if !slices.Contains(TLSVersions, v) {
+			return fmt.Errorf("connection %q: invalid minimum TLS version %q, expected one of %s", c.Name, c.TLSMin, strings.Join(TLSVersions, ", "))
+		}
+		q.Set("tlsmin", v)
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources an execution is recorded from.
+const (
+	SourceCLI     = "cli"
+	SourceServer  = "server"
+	SourcePreview = "preview"
+	SourceJob     = "job"
+)
+
+var (
+	ErrNotFound  = errors.New("audit entry not found")
+	ErrAmbiguous = errors.New("audit entry id is ambiguous, give more of it")
+)
+
+// Entry records one execution of a query.
+type Entry struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	// User is the OS user for the CLI and the authenticated principal, as
+	// method:name, for the server
+	User string `json:"user"`
+	// Source is where the query ran: cli, server, preview or job
+	Source     string `json:"source"`
+	Connection string `json:"connection"`
+
+	// Query is the name of the approved query, Template the file name of a
+	// template given as a file
+	Query          string            `json:"query,omitempty"`
+	Template       string            `json:"template,omitempty"`
+	TemplateSHA256 string            `json:"templateSha256"`
+	Input          string            `json:"input,omitempty"`
+	InputSHA256    string            `json:"inputSha256,omitempty"`
+	Vars           map[string]string `json:"vars,omitempty"`
+	// RerunOf is the id of the entry whose SQL was run again
+	RerunOf string `json:"rerunOf,omitempty"`
+
+	SQL        string `json:"sql"`
+	DurationMs int64  `json:"durationMs"`
+	// RowCounts has the number of rows of every result set
+	RowCounts []int `json:"rowCounts"`
+	// Cached is set when the results came from the result cache, the
+	// database was not queried
+	Cached bool   `json:"cached,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Name is the approved query or the template file of the entry.
+func (e Entry) Name() string {
+	if e.Query != "" {
+		return e.Query
+	}
+	return e.Template
+}
+
+// Rows is the number of rows of every result set together.
+func (e Entry) Rows() int {
+	n := 0
+	for _, c := range e.RowCounts {
+		n += c
+	}
+	return n
+}
+
+// Log is an append-only file with one JSON entry per line. Every append
+// opens the file in append mode, so several processes can share it.
+type Log struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Open opens the log at path, creating it when it does not exist.
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &Log{path: path}, nil
+}
+
+// Path is the file of the log.
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Append writes the entry to the end of the log, setting its id and time
+// when they are empty.
+func (l *Log) Append(e Entry) error {
+	if e.ID == "" {
+		id, err := newID()
+		if err != nil {
+			return err
+		}
+		e.ID = id
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+	if e.RowCounts == nil {
+		e.RowCounts = []int{}
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	// a single write keeps lines of concurrent writers apart
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return f.Close()
+}
+
+// Filter selects entries, empty fields match everything.
+type Filter struct {
+	// User, Connection and Name match case-insensitively, Name matches the
+	// query or template name
+	User       string
+	Connection string
+	Name       string
+	// Text is searched for in the SQL, case-insensitively
+	Text   string
+	Since  time.Time
+	Failed bool
+	// Limit keeps the newest entries only, 0 keeps all
+	Limit int
+}
+
+// Match tells if the entry is selected by the filter.
+func (f Filter) Match(e Entry) bool {
+	switch {
+	case f.User != "" && !strings.EqualFold(e.User, f.User):
+		return false
+	case f.Connection != "" && !strings.EqualFold(e.Connection, f.Connection):
+		return false
+	case f.Name != "" && !strings.EqualFold(e.Name(), f.Name):
+		return false
+	case f.Text != "" && !strings.Contains(strings.ToLower(e.SQL), strings.ToLower(f.Text)):
+		return false
+	case !f.Since.IsZero() && e.Time.Before(f.Since):
+		return false
+	case f.Failed && e.Error == "":
+		return false
+	}
+	return true
+}
+
+// List returns the entries selected by the filter, oldest first.
+func (l *Log) List(f Filter) ([]Entry, error) {
+	var entries []Entry
+	err := l.each(func(e Entry) bool {
+		if f.Match(e) {
+			entries = append(entries, e)
+		}
+		return true
+	})
+	if f.Limit > 0 && len(entries) > f.Limit {
+		entries = entries[len(entries)-f.Limit:]
+	}
+	return entries, err
+}
+
+// Get returns the entry with the id, or the only one starting with it.
+func (l *Log) Get(id string) (Entry, error) {
+	var found []Entry
+	err := l.each(func(e Entry) bool {
+		if e.ID == id {
+			found = []Entry{e}
+			return false
+		}
+		if strings.HasPrefix(e.ID, id) {
+			found = append(found, e)
+		}
+		return true
+	})
+	switch {
+	case err != nil:
+		return Entry{}, err
+	case id == "" || len(found) == 0:
+		return Entry{}, ErrNotFound
+	case len(found) > 1:
+		return Entry{}, ErrAmbiguous
+	}
+	return found[0], nil
+}
+
+// each calls fn for every entry until it returns false. Lines that do not
+// parse, e.g. cut short by a crash, are skipped.
+func (l *Log) each(fn func(Entry) bool) error {
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		var e Entry
+		if len(line) > 0 && json.Unmarshal(line, &e) == nil && e.ID != "" {
+			if !fn(e) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read audit log: %w", err)
+		}
+	}
+}
+
+// Hash returns the hex SHA-256 of b.
+func Hash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// HashFile returns the hex SHA-256 of the file at path.
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return HashReader(f)
+}
+
+// HashReader returns the hex SHA-256 of everything r has left.
+func HashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// OSUser returns the name of the user running the process.
+func OSUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	for _, key := range []string{"USER", "USERNAME"} {
+		if name := os.Getenv(key); name != "" {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate audit entry id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Role is what a principal may do, every role includes the ones below it.
+type Role int
+
+const (
+	RoleNone Role = iota
+	// RoleViewer runs approved queries
+	RoleViewer
+	// RoleAuthor also uploads and runs SQL templates
+	RoleAuthor
+	// RoleAdmin is allowed everything
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{
+	RoleNone:   "none",
+	RoleViewer: "viewer",
+	RoleAuthor: "author",
+	RoleAdmin:  "admin",
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// ParseRole parses viewer, author, admin or none.
+func ParseRole(s string) (Role, error) {
+	for role, name := range roleNames {
+		if strings.EqualFold(s, name) {
+			return role, nil
+		}
+	}
+	return RoleNone, fmt.Errorf("unknown role %q, expected viewer, author, admin or none", s)
+}
+
+var (
+	// ErrNoCredentials is returned by an Authenticator when the request has
+	// no credentials of its kind, the next one is tried.
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials is returned for credentials that do not check out.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+	// Method is the authenticator that accepted the request
+	Method string `json:"method"`
+}
+
+// Can tells if the principal has at least the role.
+func (p *Principal) Can(role Role) bool {
+	return p != nil && p.Role >= role
+}
+
+// Anonymous is the principal of every request when no authentication is
+// configured, only acceptable when the server is bound to localhost.
+var Anonymous = &Principal{Name: "anonymous", Role: RoleAdmin, Method: "none"}
+
+// Authenticator checks the credentials of a request.
+type Authenticator interface {
+	// Authenticate returns the caller, ErrNoCredentials when the request
+	// has no credentials this authenticator understands.
+	Authenticate(r *http.Request) (*Principal, error)
+	// Challenge is the WWW-Authenticate header value sent with a 401.
+	Challenge() string
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of the request, nil when there is none.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// Middleware authenticates every request with the first authenticator that
+// accepts it and rejects the request with a 401 when none does. Without
+// authenticators every request runs as Anonymous.
+func Middleware(l *zap.Logger, authenticators ...Authenticator) func(http.Handler) http.Handler {
+	var challenges []string
+	for _, a := range authenticators {
+		if c := a.Challenge(); !slices.Contains(challenges, c) {
+			challenges = append(challenges, c)
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(authenticators) == 0 {
+				next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), Anonymous)))
+				return
+			}
+
+			invalid := false
+			for _, a := range authenticators {
+				p, err := a.Authenticate(r)
+				if err == nil {
+					next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+					return
+				}
+				if !errors.Is(err, ErrNoCredentials) {
+					invalid = true
+					l.Debug("Rejected credentials", zap.String("remote", r.RemoteAddr), zap.Error(err))
+				}
+			}
+
+			for _, c := range challenges {
+				w.Header().Add("WWW-Authenticate", c)
+			}
+			if invalid {
+				l.Warn("Authentication failed", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path))
+				http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+				return
+			}
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+		})
+	}
+}
+
+// Require only lets principals with at least the role through, the request
+// must have passed Middleware.
+func Require(role Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := FromContext(r.Context())
+		if p == nil {
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+		if !p.Can(role) {
+			http.Error(w, fmt.Sprintf("Forbidden, %s needs the %s role", r.URL.Path, role), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Load builds the authenticators configured with the auth-* keys, none means
+// authentication is disabled.
+//
+//	auth-default-role: viewer
+//	auth-tokens:
+//	  - name: ci
+//	    sha256: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+//	    role: viewer
+//	auth-htpasswd: /etc/gaspecgen/htpasswd
+//	auth-users:
+//	  alice: admin
+//	auth-oidc-issuer: https://login.example.com/realms/main
+//	auth-oidc-audience: gaspecgen
+//	auth-oidc-roles:
+//	  sql-authors: author
+//	auth-oidc-role-names: false
+func Load(ctx context.Context) ([]Authenticator, error) {
+	defaultRole, err := ParseRole(viper.GetString("auth-default-role"))
+	if err != nil {
+		return nil, fmt.Errorf("auth-default-role: %w", err)
+	}
+
+	var authenticators []Authenticator
+
+	var tokens []Token
+	if err := viper.UnmarshalKey("auth-tokens", &tokens); err != nil {
+		return nil, fmt.Errorf("invalid auth-tokens: %w", err)
+	}
+	if len(tokens) > 0 {
+		t, err := NewTokens(tokens)
+		if err != nil {
+			return nil, fmt.Errorf("invalid auth-tokens: %w", err)
+		}
+		authenticators = append(authenticators, t)
+	}
+
+	if path := viper.GetString("auth-htpasswd"); path != "" {
+		users, err := roleMap("auth-users")
+		if err != nil {
+			return nil, err
+		}
+		h, err := NewHtpasswd(path, users, defaultRole)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, h)
+	}
+
+	if issuer := viper.GetString("auth-oidc-issuer"); issuer != "" {
+		roles, err := roleMap("auth-oidc-roles")
+		if err != nil {
+			return nil, err
+		}
+		o, err := NewOIDC(ctx, OIDCConfig{
+			Issuer:        issuer,
+			Audience:      viper.GetString("auth-oidc-audience"),
+			RoleClaim:     viper.GetString("auth-oidc-role-claim"),
+			UsernameClaim: viper.GetString("auth-oidc-username-claim"),
+			Roles:         roles,
+			RoleNames:     viper.GetBool("auth-oidc-role-names"),
+			DefaultRole:   defaultRole,
+		})
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, o)
+	}
+
+	return authenticators, nil
+}
+
+// roleMap reads the map of names to roles at key. Viper lowercases the keys
+// of the config file but not of flags or environment variables, so the
+// names are lowercased and looked up in lowercase.
+func roleMap(key string) (map[string]Role, error) {
+	roles := map[string]Role{}
+	for name, value := range viper.GetStringMapString(key) {
+		role, err := ParseRole(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", key, name, err)
+		}
+		roles[strings.ToLower(name)] = role
+	}
+	return roles, nil
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRoleMapMixedCase(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(strings.NewReader(`
+auth-users:
+  Alice: admin
+  bob: author
+`)); err != nil {
+		t.Fatal(err)
+	}
+	// flags and Set keep the case of the keys
+	viper.Set("auth-oidc-roles", map[string]string{"SQL-Authors": "author"})
+
+	for key, want := range map[string]map[string]Role{
+		"auth-users":      {"alice": RoleAdmin, "bob": RoleAuthor},
+		"auth-oidc-roles": {"sql-authors": RoleAuthor},
+	} {
+		got, err := roleMap(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s = %v, want %v", key, got, want)
+		}
+		for name, role := range want {
+			if got[name] != role {
+				t.Errorf("%s[%s] = %s, want %s", key, name, got[name], role)
+			}
+		}
+	}
+}
+
+func TestHtpasswdRolesMixedCase(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(path, []byte("Alice:"+string(hash)+"\nbob:"+string(hash)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHtpasswd(path, map[string]Role{"alice": RoleAdmin, "Bob": RoleAuthor}, RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for user, want := range map[string]Role{"Alice": RoleAdmin, "bob": RoleAuthor} {
+		r := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+		r.SetBasicAuth(user, "secret")
+		p, err := h.Authenticate(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Role != want {
+			t.Errorf("%s has role %s, want %s", user, p.Role, want)
+		}
+	}
+}
+
+func TestHtpasswdUnknownUser(t *testing.T) {
+	// the dummy hash has to cost as much as a real one
+	if cost, err := bcrypt.Cost(dummyHash); err != nil || cost != bcrypt.DefaultCost {
+		t.Fatalf("dummy hash cost = %d, %v, want %d", cost, err, bcrypt.DefaultCost)
+	}
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(path, []byte("alice:"+string(dummyHash)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHtpasswd(path, nil, RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+	r.SetBasicAuth("mallory", "secret")
+	if _, err := h.Authenticate(r); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("got %v, want invalid credentials", err)
+	}
+}
+
+func TestLoadOIDCRoles(t *testing.T) {
+	m := newMockIssuer(t, "k1")
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(strings.NewReader(`
+auth-default-role: none
+auth-oidc-issuer: ` + m.URL + `
+auth-oidc-audience: gaspecgen
+auth-oidc-roles:
+  SQL-Admins: admin
+`)); err != nil {
+		t.Fatal(err)
+	}
+	authenticators, err := Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(authenticators) != 1 {
+		t.Fatalf("got %d authenticators, want 1", len(authenticators))
+	}
+
+	p, err := authenticators[0].Authenticate(bearerRequest(m.token(t, "k1", m.claims("SQL-Admins"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Role != RoleAdmin {
+		t.Errorf("role = %s, want admin", p.Role)
+	}
+	if _, err := authenticators[0].Authenticate(bearerRequest(m.token(t, "k1", m.claims("admin")))); err == nil {
+		t.Error("a claim value named like a role granted it")
+	}
+}
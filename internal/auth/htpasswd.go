@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash is compared for unknown users, so they take as long to reject as
+// a wrong password and the timing does not tell which users exist.
+var dummyHash = []byte("$2a$10$nIbB1F37V2KZ5G0wdYpyMu2/J1WZv80wd74JmXQEwvL9C7w5Fdjnm")
+
+// Htpasswd authenticates basic auth against an htpasswd file with bcrypt
+// hashes, as written by "htpasswd -B". The file is read again when it
+// changes so users can be added without a restart.
+type Htpasswd struct {
+	path string
+	// roles maps lowercase user names to roles, users not in it get
+	// defaultRole
+	roles       map[string]Role
+	defaultRole Role
+
+	mu      sync.Mutex
+	modTime time.Time
+	hashes  map[string][]byte
+}
+
+// NewHtpasswd reads the htpasswd file at path.
+func NewHtpasswd(path string, roles map[string]Role, defaultRole Role) (*Htpasswd, error) {
+	// viper lowercases the keys of the config file, so user names are
+	// matched case-insensitively
+	lower := make(map[string]Role, len(roles))
+	for user, role := range roles {
+		lower[strings.ToLower(user)] = role
+	}
+	h := &Htpasswd{path: path, roles: lower, defaultRole: defaultRole}
+	if _, err := h.users(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h *Htpasswd) users() (map[string][]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	info, err := os.Stat(h.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read htpasswd file: %w", err)
+	}
+	if h.hashes != nil && info.ModTime().Equal(h.modTime) {
+		return h.hashes, nil
+	}
+
+	f, err := os.Open(h.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read htpasswd file: %w", err)
+	}
+	defer f.Close()
+
+	hashes := map[string][]byte{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("%s:%d: expected user:hash", h.path, n)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s:%d: only bcrypt hashes are supported (htpasswd -B)", h.path, n)
+		}
+		hashes[user] = []byte(hash)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read htpasswd file: %w", err)
+	}
+
+	h.hashes, h.modTime = hashes, info.ModTime()
+	return hashes, nil
+}
+
+// Authenticate checks the basic auth credentials of the request.
+func (h *Htpasswd) Authenticate(r *http.Request) (*Principal, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	users, err := h.users()
+	if err != nil {
+		return nil, err
+	}
+	hash, ok := users[user]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, fmt.Errorf("%w: unknown user %q", ErrInvalidCredentials, user)
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return nil, fmt.Errorf("%w: wrong password for %q", ErrInvalidCredentials, user)
+	}
+
+	role, ok := h.roles[strings.ToLower(user)]
+	if !ok {
+		role = h.defaultRole
+	}
+	if role == RoleNone {
+		return nil, fmt.Errorf("%w: user %q has no role", ErrInvalidCredentials, user)
+	}
+	return &Principal{Name: user, Role: role, Method: "htpasswd"}, nil
+}
+
+func (h *Htpasswd) Challenge() string {
+	return `Basic realm="gaspecgen", charset="UTF-8"`
+}
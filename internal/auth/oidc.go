@@ -0,0 +1,339 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwksRefreshInterval limits how often the keys are fetched again for a
+// token signed with an unknown key.
+const jwksRefreshInterval = time.Minute
+
+// OIDCConfig configures validation of ID or access tokens from an OpenID
+// Connect provider.
+type OIDCConfig struct {
+	// Issuer is the issuer URL, the provider metadata is read from
+	// <issuer>/.well-known/openid-configuration
+	Issuer string
+	// Audience is the client id the tokens must be issued for
+	Audience string
+	// RoleClaim holds the role or the list of roles of the user
+	RoleClaim string
+	// UsernameClaim names the user, sub is used when it is missing
+	UsernameClaim string
+	// Roles maps claim values to roles, case-insensitively
+	Roles map[string]Role
+	// RoleNames maps claim values named viewer, author or admin to that
+	// role without an entry in Roles
+	RoleNames   bool
+	DefaultRole Role
+	Client      *http.Client
+}
+
+// OIDC authenticates bearer JWTs signed by the keys of an OIDC provider. The
+// provider metadata is fetched on first use, so the server starts while the
+// provider is unreachable and requests fail until it is back.
+type OIDC struct {
+	cfg OIDCConfig
+	ctx context.Context
+
+	mu      sync.Mutex
+	jwksURI string
+	keys    map[string]any
+	fetched time.Time
+	// fetchErr is why the last fetch failed, returned until the next one
+	fetchErr error
+	// fetching is the running fetch of the keys, nil when none runs
+	fetching *keyFetch
+}
+
+// keyFetch is a fetch of the provider keys the callers of key wait for,
+// done is closed when err is set.
+type keyFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewOIDC validates the config, ctx bounds the requests to the provider.
+func NewOIDC(ctx context.Context, cfg OIDCConfig) (*OIDC, error) {
+	if cfg.Issuer == "" {
+		return nil, errors.New("oidc: issuer is required")
+	}
+	if cfg.Audience == "" {
+		return nil, errors.New("oidc: audience is required, tokens issued for other clients would be accepted without it")
+	}
+	if cfg.RoleClaim == "" {
+		cfg.RoleClaim = "roles"
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "preferred_username"
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	// viper lowercases the keys of the config file
+	roles := make(map[string]Role, len(cfg.Roles))
+	for value, role := range cfg.Roles {
+		roles[strings.ToLower(value)] = role
+	}
+	cfg.Roles = roles
+	return &OIDC{cfg: cfg, ctx: ctx}, nil
+}
+
+// Authenticate validates the signature, issuer, audience and expiry of the
+// bearer token and maps its role claim.
+func (o *OIDC) Authenticate(r *http.Request) (*Principal, error) {
+	raw, ok := bearerToken(r)
+	if !ok || strings.Count(raw, ".") != 2 {
+		return nil, ErrNoCredentials
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, o.key,
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(o.cfg.Issuer),
+		jwt.WithAudience(o.cfg.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+
+	name, _ := claims[o.cfg.UsernameClaim].(string)
+	if name == "" {
+		name, _ = claims["sub"].(string)
+	}
+	role := o.role(claims[o.cfg.RoleClaim])
+	if role == RoleNone {
+		return nil, fmt.Errorf("%w: user %q has no role", ErrInvalidCredentials, name)
+	}
+	return &Principal{Name: name, Role: role, Method: "oidc"}, nil
+}
+
+// role returns the highest role the claim value maps to.
+func (o *OIDC) role(claim any) Role {
+	var values []string
+	switch v := claim.(type) {
+	case string:
+		values = strings.Fields(v)
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	best := o.cfg.DefaultRole
+	for _, v := range values {
+		role, ok := o.cfg.Roles[strings.ToLower(v)]
+		if !ok && o.cfg.RoleNames {
+			role, _ = ParseRole(v)
+		}
+		best = max(best, role)
+	}
+	return best
+}
+
+func (o *OIDC) Challenge() string {
+	return `Bearer realm="gaspecgen"`
+}
+
+// key returns the verification key of the token, the keys are fetched again
+// when the provider rotated them. The fetch runs without holding the lock,
+// concurrent callers wait for it instead of fetching again.
+func (o *OIDC) key(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	o.mu.Lock()
+	if k, ok := o.lookup(kid); ok {
+		o.mu.Unlock()
+		return k, nil
+	}
+	f := o.fetching
+	if f == nil {
+		if time.Since(o.fetched) < jwksRefreshInterval {
+			err := o.fetchErr
+			o.mu.Unlock()
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("no key %q in the provider keys", kid)
+		}
+		f = &keyFetch{done: make(chan struct{})}
+		o.fetching, o.fetched = f, time.Now()
+		jwksURI := o.jwksURI
+		o.mu.Unlock()
+
+		jwksURI, keys, err := o.fetchKeys(jwksURI)
+
+		o.mu.Lock()
+		if err == nil {
+			o.jwksURI, o.keys = jwksURI, keys
+		}
+		o.fetching, o.fetchErr = nil, err
+		f.err = err
+		o.mu.Unlock()
+		close(f.done)
+	} else {
+		o.mu.Unlock()
+		<-f.done
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if k, ok := o.lookup(kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("no key %q in the provider keys", kid)
+}
+
+func (o *OIDC) lookup(kid string) (any, bool) {
+	if kid != "" {
+		k, ok := o.keys[kid]
+		return k, ok
+	}
+	// tokens without a kid are only accepted from providers with one key
+	if len(o.keys) == 1 {
+		for _, k := range o.keys {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// fetchKeys fetches the signing keys, discovering the jwks_uri first when
+// it is empty.
+func (o *OIDC) fetchKeys(jwksURI string) (string, map[string]any, error) {
+	if jwksURI == "" {
+		var meta struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := o.getJSON(o.cfg.Issuer+"/.well-known/openid-configuration", &meta); err != nil {
+			return "", nil, fmt.Errorf("failed to discover oidc provider: %w", err)
+		}
+		if strings.TrimSuffix(meta.Issuer, "/") != o.cfg.Issuer {
+			return "", nil, fmt.Errorf("oidc provider reports issuer %q, expected %q", meta.Issuer, o.cfg.Issuer)
+		}
+		if meta.JWKSURI == "" {
+			return "", nil, errors.New("oidc provider metadata has no jwks_uri")
+		}
+		jwksURI = meta.JWKSURI
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := o.getJSON(jwksURI, &set); err != nil {
+		return "", nil, fmt.Errorf("failed to fetch oidc keys: %w", err)
+	}
+	keys := make(map[string]any, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			// one odd key must not lock everybody out
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	return jwksURI, keys, nil
+}
+
+func (o *OIDC) getJSON(url string, v any) error {
+	req, err := http.NewRequestWithContext(o.ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := o.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// jwk is a JSON web key, only RSA and EC signing keys are supported.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, errors.New("rsa exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
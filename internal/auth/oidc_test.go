@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIssuer is an OIDC provider serving the discovery document and the
+// public keys of its current signing keys.
+type mockIssuer struct {
+	*httptest.Server
+
+	mu   sync.Mutex
+	keys map[string]*ecdsa.PrivateKey
+	// fetches counts the requests for the keys
+	fetches atomic.Int32
+	// gate holds the key requests until it is closed when set
+	gate chan struct{}
+}
+
+func newMockIssuer(t *testing.T, kids ...string) *mockIssuer {
+	t.Helper()
+	m := &mockIssuer{}
+	m.rotate(t, kids...)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": m.URL, "jwks_uri": m.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		m.fetches.Add(1)
+		if m.gate != nil {
+			<-m.gate
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		var set struct {
+			Keys []jwk `json:"keys"`
+		}
+		for kid, k := range m.keys {
+			set.Keys = append(set.Keys, jwk{
+				Kty: "EC", Kid: kid, Use: "sig", Crv: "P-256",
+				X: base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, 32))),
+				Y: base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, 32))),
+			})
+		}
+		json.NewEncoder(w).Encode(set)
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// rotate replaces the signing keys with new ones named kids.
+func (m *mockIssuer) rotate(t *testing.T, kids ...string) {
+	t.Helper()
+	keys := map[string]*ecdsa.PrivateKey{}
+	for _, kid := range kids {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[kid] = k
+	}
+	m.mu.Lock()
+	m.keys = keys
+	m.mu.Unlock()
+}
+
+// token signs the claims with the key kid, a missing key signs with a
+// key the issuer does not publish.
+func (m *mockIssuer) token(t *testing.T, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	m.mu.Lock()
+	k, ok := m.keys[kid]
+	m.mu.Unlock()
+	if !ok {
+		var err error
+		if k, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	tok.Header["kid"] = kid
+	raw, err := tok.SignedString(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func (m *mockIssuer) claims(roles ...any) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":                m.URL,
+		"aud":                "gaspecgen",
+		"sub":                "1234",
+		"preferred_username": "alice",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"roles":              roles,
+	}
+}
+
+func bearerRequest(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func newTestOIDC(t *testing.T, m *mockIssuer, cfg OIDCConfig) *OIDC {
+	t.Helper()
+	cfg.Issuer, cfg.Audience = m.URL, "gaspecgen"
+	o, err := NewOIDC(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestOIDCAuthenticate(t *testing.T) {
+	m := newMockIssuer(t, "k1")
+	o := newTestOIDC(t, m, OIDCConfig{Roles: map[string]Role{"SQL-Authors": RoleAuthor}, DefaultRole: RoleViewer})
+
+	expired := m.claims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	wrongAudience := m.claims()
+	wrongAudience["aud"] = "other-client"
+	wrongIssuer := m.claims()
+	wrongIssuer["iss"] = "https://evil.example.com"
+
+	tests := []struct {
+		name  string
+		token string
+		role  Role
+	}{
+		{"valid", m.token(t, "k1", m.claims()), RoleViewer},
+		{"mapped role", m.token(t, "k1", m.claims("sql-authors")), RoleAuthor},
+		{"mapped role in another case", m.token(t, "k1", m.claims("SQL-AUTHORS")), RoleAuthor},
+		{"role named claim is not mapped", m.token(t, "k1", m.claims("admin")), RoleViewer},
+		{"expired", m.token(t, "k1", expired), RoleNone},
+		{"wrong audience", m.token(t, "k1", wrongAudience), RoleNone},
+		{"wrong issuer", m.token(t, "k1", wrongIssuer), RoleNone},
+		{"wrong kid", m.token(t, "k2", m.claims()), RoleNone},
+		{"unknown key with a known kid", newMockIssuer(t, "k1").token(t, "k1", m.claims()), RoleNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := o.Authenticate(bearerRequest(tt.token))
+			if tt.role == RoleNone {
+				if !errors.Is(err, ErrInvalidCredentials) {
+					t.Errorf("got %v, %v, want invalid credentials", p, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Name != "alice" || p.Role != tt.role {
+				t.Errorf("got %+v, want alice as %s", p, tt.role)
+			}
+		})
+	}
+}
+
+func TestOIDCRoleNames(t *testing.T) {
+	m := newMockIssuer(t, "k1")
+	o := newTestOIDC(t, m, OIDCConfig{RoleNames: true, DefaultRole: RoleNone})
+
+	p, err := o.Authenticate(bearerRequest(m.token(t, "k1", m.claims("admin"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Role != RoleAdmin {
+		t.Errorf("role = %s, want admin", p.Role)
+	}
+	if _, err := o.Authenticate(bearerRequest(m.token(t, "k1", m.claims("guest")))); err == nil {
+		t.Error("accepted a user without a role")
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	m := newMockIssuer(t, "k1")
+	o := newTestOIDC(t, m, OIDCConfig{DefaultRole: RoleViewer})
+
+	if _, err := o.Authenticate(bearerRequest(m.token(t, "k1", m.claims()))); err != nil {
+		t.Fatal(err)
+	}
+	m.rotate(t, "k2")
+	rotated := m.token(t, "k2", m.claims())
+
+	// unknown keys are not fetched again right away
+	if _, err := o.Authenticate(bearerRequest(rotated)); err == nil {
+		t.Fatal("fetched the keys again within the refresh interval")
+	}
+	o.mu.Lock()
+	o.fetched = time.Now().Add(-jwksRefreshInterval)
+	o.mu.Unlock()
+
+	if _, err := o.Authenticate(bearerRequest(rotated)); err != nil {
+		t.Fatalf("token signed with the rotated key: %v", err)
+	}
+	if _, err := o.Authenticate(bearerRequest(m.token(t, "k1", m.claims()))); err == nil {
+		t.Error("accepted a token signed with the retired key")
+	}
+	if n := m.fetches.Load(); n != 2 {
+		t.Errorf("fetched the keys %d times, want 2", n)
+	}
+}
+
+func TestOIDCFetchesKeysOnce(t *testing.T) {
+	m := newMockIssuer(t, "k1")
+	o := newTestOIDC(t, m, OIDCConfig{DefaultRole: RoleViewer})
+	token := m.token(t, "k1", m.claims())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := o.Authenticate(bearerRequest(token))
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if n := m.fetches.Load(); n != 1 {
+		t.Errorf("fetched the keys %d times, want 1", n)
+	}
+}
+
+func TestOIDCFetchesKeysWithoutTheLock(t *testing.T) {
+	m := newMockIssuer(t, "k1")
+	m.gate = make(chan struct{})
+	o := newTestOIDC(t, m, OIDCConfig{DefaultRole: RoleViewer})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := o.Authenticate(bearerRequest(m.token(t, "k1", m.claims())))
+		done <- err
+	}()
+	for m.fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if !o.mu.TryLock() {
+		t.Fatal("the lock is held while the keys are fetched")
+	}
+	o.mu.Unlock()
+
+	close(m.gate)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCProviderDown(t *testing.T) {
+	m := newMockIssuer(t, "k1")
+	token := m.token(t, "k1", m.claims())
+	o := newTestOIDC(t, m, OIDCConfig{DefaultRole: RoleViewer})
+	m.Close()
+
+	_, first := o.Authenticate(bearerRequest(token))
+	if first == nil {
+		t.Fatal("accepted a token without the provider keys")
+	}
+	// within the refresh interval the cause is returned again, not a
+	// missing key
+	if _, err := o.Authenticate(bearerRequest(token)); err == nil || err.Error() != first.Error() {
+		t.Errorf("got %v, want %v again", err, first)
+	}
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Token is a static API token, either given in plain text or as the hex
+// encoded SHA-256 of the token so the config file does not hold the secret.
+type Token struct {
+	Name   string `mapstructure:"name"`
+	Token  string `mapstructure:"token"`
+	SHA256 string `mapstructure:"sha256"`
+	Role   string `mapstructure:"role"`
+}
+
+type tokenEntry struct {
+	hash      [sha256.Size]byte
+	principal *Principal
+}
+
+// Tokens authenticates "Authorization: Bearer <token>" against static tokens.
+type Tokens struct {
+	entries []tokenEntry
+}
+
+// NewTokens validates the tokens, a token without a role is a viewer.
+func NewTokens(tokens []Token) (*Tokens, error) {
+	t := &Tokens{}
+	for i, tok := range tokens {
+		name := tok.Name
+		if name == "" {
+			name = fmt.Sprintf("token-%d", i+1)
+		}
+
+		var e tokenEntry
+		switch {
+		case tok.Token != "" && tok.SHA256 != "":
+			return nil, fmt.Errorf("token %q: set either token or sha256, not both", name)
+		case tok.Token != "":
+			e.hash = sha256.Sum256([]byte(tok.Token))
+		case tok.SHA256 != "":
+			b, err := hex.DecodeString(strings.TrimSpace(tok.SHA256))
+			if err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("token %q: sha256 must be 64 hex characters", name)
+			}
+			copy(e.hash[:], b)
+		default:
+			return nil, fmt.Errorf("token %q: token or sha256 is required", name)
+		}
+
+		role := RoleViewer
+		if tok.Role != "" {
+			var err error
+			if role, err = ParseRole(tok.Role); err != nil {
+				return nil, fmt.Errorf("token %q: %w", name, err)
+			}
+		}
+		e.principal = &Principal{Name: name, Role: role, Method: "token"}
+		t.entries = append(t.entries, e)
+	}
+	return t, nil
+}
+
+// Authenticate matches the bearer token in constant time.
+func (t *Tokens) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	hash := sha256.Sum256([]byte(token))
+	var found *Principal
+	for _, e := range t.entries {
+		if subtle.ConstantTimeCompare(hash[:], e.hash[:]) == 1 {
+			found = e.principal
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: unknown API token", ErrInvalidCredentials)
+	}
+	return found, nil
+}
+
+func (t *Tokens) Challenge() string {
+	return `Bearer realm="gaspecgen"`
+}
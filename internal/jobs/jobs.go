@@ -0,0 +1,428 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const errServerStopped = "the server stopped before the job finished"
+
+type State string
+
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+	StateCancelled State = "cancelled"
+)
+
+// Done tells if the job will not change anymore.
+func (s State) Done() bool {
+	return s == StateSucceeded || s == StateFailed || s == StateCancelled
+}
+
+var (
+	ErrNotFound  = errors.New("job not found")
+	ErrQueueFull = errors.New("job queue is full, try again later")
+	ErrNoResult  = errors.New("job has no result")
+)
+
+// Output describes the result a job wrote.
+type Output struct {
+	ContentType string `json:"contentType"`
+	Filename    string `json:"filename"`
+	// RowCounts has the number of rows of every result set
+	RowCounts []int    `json:"rowCounts"`
+	Messages  []string `json:"messages,omitempty"`
+}
+
+// Func does the work of a job and writes its result to w.
+type Func func(ctx context.Context, w io.Writer) (*Output, error)
+
+// Job is the status of a submitted job, it is stored next to the result so
+// finished jobs survive a restart.
+type Job struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner"`
+	// Description is shown in listings, e.g. the query name
+	Description string `json:"description,omitempty"`
+	Connection  string `json:"connection,omitempty"`
+	State       State  `json:"state"`
+	Error       string `json:"error,omitempty"`
+
+	SubmittedAt time.Time  `json:"submittedAt"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+	ElapsedMs   int64      `json:"elapsedMs,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+
+	*Output `json:"output,omitempty"`
+
+	fn     Func
+	cancel context.CancelFunc
+}
+
+// Options configures the Queue.
+type Options struct {
+	// Dir keeps the results and the status of the jobs
+	Dir string
+	// Workers is the number of jobs running at the same time
+	Workers int
+	// Size is the number of jobs waiting for a worker before new jobs are
+	// rejected
+	Size int
+	// TTL is how long finished jobs and their results are kept
+	TTL time.Duration
+}
+
+// Queue runs jobs on a bounded pool of workers.
+type Queue struct {
+	opts Options
+	ctx  context.Context
+	l    *zap.Logger
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+	// pending are the jobs waiting for a worker, oldest first, cancelled
+	// jobs are taken out so they do not hold a place
+	pending []*Job
+	// idle is the number of workers waiting for a job
+	idle int
+	// ready is signalled when a job is queued or the queue stops
+	ready *sync.Cond
+	wg    sync.WaitGroup
+}
+
+// New starts the workers, they stop when ctx is cancelled. Finished jobs
+// found in Dir are picked up again, unfinished ones are marked as failed.
+func New(ctx context.Context, opts Options) (*Queue, error) {
+	if opts.Workers < 1 {
+		return nil, fmt.Errorf("at least one worker is required")
+	}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create job directory: %w", err)
+	}
+
+	q := &Queue{
+		opts: opts,
+		ctx:  ctx,
+		l:    zap.L().Named("[JOBS]"),
+		jobs: map[string]*Job{},
+	}
+	q.ready = sync.NewCond(&q.mu)
+	if err := q.restore(); err != nil {
+		return nil, err
+	}
+
+	for range opts.Workers {
+		q.wg.Add(1)
+		go q.work()
+	}
+	q.wg.Add(1)
+	go q.stop()
+	go q.expire()
+	return q, nil
+}
+
+// Submit queues fn and returns the queued job.
+func (q *Queue) Submit(owner, description, connection string, fn Func) (Job, error) {
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+	j := &Job{
+		ID:          id,
+		Owner:       owner,
+		Description: description,
+		Connection:  connection,
+		State:       StateQueued,
+		SubmittedAt: time.Now().UTC(),
+		fn:          fn,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	// idle workers take the job right away, it waits in the queue otherwise
+	if q.ctx.Err() != nil || len(q.pending) >= max(q.opts.Size, 0)+q.idle {
+		return Job{}, ErrQueueFull
+	}
+	q.pending = append(q.pending, j)
+	q.jobs[id] = j
+	q.save(j)
+	q.ready.Signal()
+	return *j, nil
+}
+
+// Get returns a copy of the job.
+func (q *Queue) Get(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return *j, nil
+}
+
+// List returns the jobs of the owner, or of everyone when owner is empty,
+// the newest first.
+func (q *Queue) List(owner string) []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := []Job{}
+	for _, j := range q.jobs {
+		if owner == "" || j.Owner == owner {
+			jobs = append(jobs, *j)
+		}
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].SubmittedAt.After(jobs[k].SubmittedAt) })
+	return jobs
+}
+
+// Cancel stops a queued or running job, a finished job is deleted with its
+// result.
+func (q *Queue) Cancel(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+
+	switch {
+	case j.State == StateQueued:
+		q.unqueue(j)
+		q.finish(j, StateCancelled, nil)
+	case j.State == StateRunning:
+		// the worker records the state when the job returns
+		j.cancel()
+	default:
+		q.remove(j)
+	}
+	return *j, nil
+}
+
+// Result opens the result file of a succeeded job.
+func (q *Queue) Result(id string) (*os.File, Job, error) {
+	j, err := q.Get(id)
+	if err != nil {
+		return nil, j, err
+	}
+	if j.State != StateSucceeded {
+		return nil, j, fmt.Errorf("%w, it is %s", ErrNoResult, j.State)
+	}
+	f, err := os.Open(q.resultPath(id))
+	return f, j, err
+}
+
+// Wait blocks until the workers stopped.
+func (q *Queue) Wait() {
+	q.wg.Wait()
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for {
+		q.mu.Lock()
+		q.idle++
+		for len(q.pending) == 0 && q.ctx.Err() == nil {
+			q.ready.Wait()
+		}
+		q.idle--
+		if q.ctx.Err() != nil {
+			q.mu.Unlock()
+			return
+		}
+		j := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+
+		q.run(j)
+	}
+}
+
+// stop wakes the workers when the queue stops and fails the jobs still
+// waiting for one.
+func (q *Queue) stop() {
+	defer q.wg.Done()
+	<-q.ctx.Done()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.pending {
+		j.Error = errServerStopped
+		q.finish(j, StateFailed, nil)
+	}
+	q.pending = nil
+	q.ready.Broadcast()
+}
+
+// unqueue takes a waiting job out of the queue, q.mu must be held.
+func (q *Queue) unqueue(j *Job) {
+	for i, p := range q.pending {
+		if p == j {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+func (q *Queue) run(j *Job) {
+	ctx, cancel := context.WithCancel(q.ctx)
+	defer cancel()
+
+	q.mu.Lock()
+	if j.State != StateQueued {
+		q.mu.Unlock()
+		return
+	}
+	now := time.Now().UTC()
+	j.State, j.StartedAt, j.cancel = StateRunning, &now, cancel
+	q.save(j)
+	q.mu.Unlock()
+
+	out, err := q.execute(ctx, j)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	switch {
+	case q.ctx.Err() != nil:
+		j.Error = errServerStopped
+		q.finish(j, StateFailed, out)
+		os.Remove(q.resultPath(j.ID))
+	case ctx.Err() != nil:
+		q.finish(j, StateCancelled, nil)
+		os.Remove(q.resultPath(j.ID))
+	case err != nil:
+		j.Error = err.Error()
+		q.finish(j, StateFailed, out)
+		os.Remove(q.resultPath(j.ID))
+	default:
+		q.finish(j, StateSucceeded, out)
+	}
+	q.l.Info("Job finished", zap.String("id", j.ID), zap.String("state", string(j.State)), zap.Int64("elapsedMs", j.ElapsedMs))
+}
+
+func (q *Queue) execute(ctx context.Context, j *Job) (*Output, error) {
+	f, err := os.OpenFile(q.resultPath(j.ID), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create result file: %w", err)
+	}
+	out, err := j.fn(ctx, f)
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("failed to write result file: %w", cerr)
+	}
+	return out, err
+}
+
+// finish records the final state, q.mu must be held.
+func (q *Queue) finish(j *Job, state State, out *Output) {
+	now := time.Now().UTC()
+	expires := now.Add(q.opts.TTL)
+	j.State, j.FinishedAt, j.ExpiresAt = state, &now, &expires
+	if j.StartedAt != nil {
+		j.ElapsedMs = now.Sub(*j.StartedAt).Milliseconds()
+	}
+	if out != nil {
+		j.Output = out
+	}
+	j.fn, j.cancel = nil, nil
+	q.save(j)
+}
+
+// remove deletes the job and its files, q.mu must be held.
+func (q *Queue) remove(j *Job) {
+	delete(q.jobs, j.ID)
+	os.Remove(q.resultPath(j.ID))
+	os.Remove(q.statusPath(j.ID))
+}
+
+// expire deletes finished jobs once their TTL has passed.
+func (q *Queue) expire() {
+	ticker := time.NewTicker(min(max(q.opts.TTL/10, time.Second), time.Minute))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case now := <-ticker.C:
+			q.mu.Lock()
+			for _, j := range q.jobs {
+				if j.ExpiresAt != nil && now.After(*j.ExpiresAt) {
+					q.remove(j)
+				}
+			}
+			q.mu.Unlock()
+		}
+	}
+}
+
+// save writes the status of the job, q.mu must be held.
+func (q *Queue) save(j *Job) {
+	b, err := json.Marshal(j)
+	if err == nil {
+		err = os.WriteFile(q.statusPath(j.ID), b, 0o600)
+	}
+	if err != nil {
+		q.l.Error("Failed to save job status", zap.String("id", j.ID), zap.Error(err))
+	}
+}
+
+func (q *Queue) restore() error {
+	entries, err := os.ReadDir(q.opts.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to read job directory: %w", err)
+	}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		b, err := os.ReadFile(q.statusPath(id))
+		if err != nil {
+			return fmt.Errorf("failed to read job status: %w", err)
+		}
+		j := &Job{}
+		if err := json.Unmarshal(b, j); err != nil || j.ID != id {
+			q.l.Warn("Skipping invalid job status", zap.String("file", e.Name()))
+			continue
+		}
+		q.jobs[id] = j
+		if !j.State.Done() {
+			j.Error = errServerStopped
+			q.finish(j, StateFailed, nil)
+			os.Remove(q.resultPath(id))
+		}
+	}
+	return nil
+}
+
+func (q *Queue) resultPath(id string) string {
+	return filepath.Join(q.opts.Dir, id+".result")
+}
+
+func (q *Queue) statusPath(id string) string {
+	return filepath.Join(q.opts.Dir, id+".json")
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
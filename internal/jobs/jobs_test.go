@@ -0,0 +1,98 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// blocking returns a job that runs until release is closed or it is
+// cancelled.
+func blocking(release chan struct{}) Func {
+	return func(ctx context.Context, w io.Writer) (*Output, error) {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return &Output{}, ctx.Err()
+	}
+}
+
+func waitFor(t *testing.T, q *Queue, id string, state State) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		j, err := q.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.State == state {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, want %s", id, j.State, state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	q, err := New(ctx, Options{Dir: t.TempDir(), Workers: 1, Size: 1, TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+
+	running, err := q.Submit("alice", "", "", blocking(release))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, q, running.ID, StateRunning)
+
+	queued, err := q.Submit("alice", "", "", blocking(release))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Submit("alice", "", "", blocking(release)); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("got %v, want a full queue", err)
+	}
+
+	// a cancelled job gives up its place right away
+	if j, err := q.Cancel(queued.ID); err != nil || j.State != StateCancelled {
+		t.Fatalf("cancel: got %s, %v", j.State, err)
+	}
+	waiting, err := q.Submit("alice", "", "", blocking(release))
+	if err != nil {
+		t.Fatalf("the cancelled job still holds its place: %v", err)
+	}
+
+	// jobs still waiting when the queue stops are failed, on disk as well
+	stop()
+	q.Wait()
+	for _, id := range []string{running.ID, waiting.ID} {
+		j, err := q.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j.State != StateFailed || j.Error != errServerStopped {
+			t.Errorf("job %s is %s (%s), want failed", id, j.State, j.Error)
+		}
+	}
+	b, err := os.ReadFile(q.statusPath(waiting.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved Job
+	if err := json.Unmarshal(b, &saved); err != nil || saved.State != StateFailed {
+		t.Errorf("saved state %s, %v, want failed", saved.State, err)
+	}
+	if j, _ := q.Get(queued.ID); j.State != StateCancelled {
+		t.Errorf("the cancelled job is %s", j.State)
+	}
+}
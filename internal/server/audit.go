@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/NiclasZi/gaspecgen/db"
+	"github.com/NiclasZi/gaspecgen/internal/audit"
+	"github.com/NiclasZi/gaspecgen/internal/auth"
+	"go.uber.org/zap"
+)
+
+// record writes the execution of the spec by the caller of the request to
+// the audit log.
+func (s *Server) record(r *http.Request, source string, spec *runSpec, query string, started time.Time, sets []*db.Result, err error) {
+	s.recordAs(jobOwner(auth.FromContext(r.Context())), source, spec, query, started, sets, err)
+}
+
+// recordAs writes the execution of the spec by user to the audit log, the
+// duration is measured from started.
+func (s *Server) recordAs(user, source string, spec *runSpec, query string, started time.Time, sets []*db.Result, err error) {
+	if s.audit == nil {
+		return
+	}
+	e := audit.Entry{
+		Time:           started,
+		User:           user,
+		Source:         source,
+		Connection:     spec.connection,
+		Query:          spec.name,
+		Template:       spec.file,
+		TemplateSHA256: audit.Hash([]byte(spec.template)),
+		Input:          spec.input,
+		InputSHA256:    spec.inputHash,
+		Vars:           spec.data.Vars,
+		SQL:            query,
+		DurationMs:     time.Since(started).Milliseconds(),
+		RowCounts:      make([]int, 0, len(sets)),
+	}
+	for _, set := range sets {
+		e.RowCounts = append(e.RowCounts, len(set.Rows))
+		e.Cached = e.Cached || !set.CachedAt.IsZero()
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if err := s.audit.Append(e); err != nil {
+		s.l.Error("Failed to write the audit log", zap.Error(err))
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	csrfCookie   = "gaspecgen_csrf"
+	csrfHeader   = "X-CSRF-Token"
+	launchCookie = "gaspecgen_launch"
+	launchHeader = "X-Launch-Token"
+	launchParam  = "token"
+	// csrfPlaceholder in the index is replaced with the token of the session
+	csrfPlaceholder = "__CSRF_TOKEN__"
+)
+
+// guard protects the server against pages in the browser of the user:
+// DNS rebinding is stopped by checking the Host header, cross-origin
+// requests by checking Origin and Sec-Fetch-Site, CORS preflights are
+// rejected and no CORS headers are ever sent. With a launch token every
+// request needs the cookie set when the launch URL was opened.
+func (s *Server) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowedHost(r.Host) {
+			s.l.Warn("Rejected request with an unknown Host header, DNS rebinding?")
+			http.Error(w, "Forbidden, unknown Host header, add the name to --allowed-hosts", http.StatusForbidden)
+			return
+		}
+		if r.Method == http.MethodOptions || !sameOrigin(r) {
+			http.Error(w, "Forbidden, cross-origin requests are not allowed", http.StatusForbidden)
+			return
+		}
+
+		if s.launchToken != "" && !s.launched(r) {
+			if s.acceptLaunchToken(w, r) {
+				return
+			}
+			http.Error(w, "Forbidden, open the URL with the launch token the server printed on start", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedHost tells if the Host header names this server. Rebinding needs a
+// name the attacker controls, so IP addresses are always fine.
+func (s *Server) allowedHost(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	if net.ParseIP(host) != nil || strings.EqualFold(host, "localhost") || strings.EqualFold(host, s.host) {
+		return true
+	}
+	for _, allowed := range s.allowedHosts {
+		if strings.EqualFold(host, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOrigin rejects requests a browser marks as cross-site or sends with
+// the Origin of another site. Clients that are not browsers send neither.
+func sameOrigin(r *http.Request) bool {
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// launched tells if the request has the launch cookie, or the launch token
+// in a header for clients without cookies.
+func (s *Server) launched(r *http.Request) bool {
+	if c, err := r.Cookie(launchCookie); err == nil && s.validSigned(c.Value, "launch") {
+		return true
+	}
+	token := r.Header.Get(launchHeader)
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.launchToken)) == 1
+}
+
+// acceptLaunchToken swaps the launch token in the URL of a page for the
+// launch cookie and redirects to drop the token from the address bar. It
+// tells if it answered the request.
+func (s *Server) acceptLaunchToken(w http.ResponseWriter, r *http.Request) bool {
+	token := r.URL.Query().Get(launchParam)
+	if r.Method != http.MethodGet || token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.launchToken)) != 1 {
+		return false
+	}
+	value, err := s.newSigned("launch")
+	if err != nil {
+		return false
+	}
+	http.SetCookie(w, &http.Cookie{Name: launchCookie, Value: value, Path: "/", HttpOnly: true, Secure: s.tlsCert != "", SameSite: http.SameSiteStrictMode})
+	q := r.URL.Query()
+	q.Del(launchParam)
+	target := url.URL{Path: r.URL.Path, RawQuery: q.Encode()}
+	http.Redirect(w, r, target.String(), http.StatusSeeOther)
+	return true
+}
+
+// csrf requires the token of the session on requests that change anything
+// when they come from a browser. The token is handed to the page in the
+// index and checked against the session cookie set with it.
+func (s *Server) csrf(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !fromBrowser(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c, err := r.Cookie(csrfCookie)
+		token := r.Header.Get(csrfHeader)
+		if err != nil || token == "" || !hmac.Equal([]byte(token), []byte(c.Value)) || !s.validSigned(token, "csrf") {
+			http.Error(w, "Forbidden, missing or invalid CSRF token, reload the page", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fromBrowser tells if the request carries anything a browser adds on its
+// own, scripts and curl send none of it.
+func fromBrowser(r *http.Request) bool {
+	return r.Header.Get("Origin") != "" || r.Header.Get("Sec-Fetch-Site") != "" || r.Header.Get("Cookie") != ""
+}
+
+// csrfToken returns the token of the session and sets its cookie when the
+// request has none yet.
+func (s *Server) csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if c, err := r.Cookie(csrfCookie); err == nil && s.validSigned(c.Value, "csrf") {
+		return c.Value, nil
+	}
+	token, err := s.newSigned("csrf")
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{Name: csrfCookie, Value: token, Path: "/", HttpOnly: true, Secure: s.tlsCert != "", SameSite: http.SameSiteStrictMode})
+	return token, nil
+}
+
+// newSigned returns a random value signed with the key of the server, so
+// values from earlier runs or made up by someone else are rejected.
+func (s *Server) newSigned(purpose string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	nonce := hex.EncodeToString(b)
+	return nonce + "." + s.sign(purpose, nonce), nil
+}
+
+func (s *Server) validSigned(value, purpose string) bool {
+	nonce, sig, ok := strings.Cut(value, ".")
+	return ok && hmac.Equal([]byte(sig), []byte(s.sign(purpose, nonce)))
+}
+
+func (s *Server) sign(purpose, nonce string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(purpose + ":" + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/NiclasZi/gaspecgen/db"
+	"github.com/NiclasZi/gaspecgen/internal/audit"
+	"github.com/NiclasZi/gaspecgen/internal/auth"
+	"github.com/NiclasZi/gaspecgen/internal/jobs"
+	"github.com/NiclasZi/gaspecgen/pkg/generator"
+	"github.com/Phillezi/common/utils/or"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// jobOwner identifies the principal owning a job.
+func jobOwner(p *auth.Principal) string {
+	return p.Method + ":" + p.Name
+}
+
+// handleSubmitJob queues a run of an approved query, named in the query form
+// field, or of an uploaded sql_file. The form is the same as for running it
+// directly.
+func (s *Server) handleSubmitJob(w http.ResponseWriter, r *http.Request) {
+	p := auth.FromContext(r.Context())
+
+	spec, err := s.formSpec(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// render now so template errors are reported right away
+	query, err := spec.render()
+	if err != nil {
+		http.Error(w, "Failed to render SQL query with the provided input data, error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := s.jobs.Submit(jobOwner(p), or.Or(spec.name, "uploaded template"), spec.connection, s.jobFunc(jobOwner(p), spec, query))
+	if errors.Is(err, jobs.ErrQueueFull) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.l.Info("Job submitted", zap.String("id", job.ID), zap.String("owner", job.Owner), zap.String("query", job.Description))
+	w.Header().Set("Location", "/api/jobs/"+job.ID)
+	s.writeJSON(w, http.StatusAccepted, job)
+}
+
+// jobFunc runs the rendered query for the owner and writes the output of the
+// spec, csv when no output format is set.
+func (s *Server) jobFunc(owner string, spec *runSpec, query string) jobs.Func {
+	config := maps.Clone(spec.config)
+	if config == nil {
+		config = map[string]any{}
+	}
+	if getString(config, "output") == "" && getString(config, "output-format") == "" {
+		config["output-format"] = "csv"
+	}
+
+	return func(ctx context.Context, w io.Writer) (*jobs.Output, error) {
+		ctx, messages := db.WithMessages(spec.context(ctx))
+		started := time.Now()
+		e, err := db.OpenNamed(ctx, spec.connection)
+		if err != nil {
+			s.recordAs(owner, audit.SourceJob, spec, query, started, nil, err)
+			return nil, fmt.Errorf("failed to connect to the database: %w", err)
+		}
+		sets, err := e.Execute(ctx, query)
+		s.recordAs(owner, audit.SourceJob, spec, query, started, sets, err)
+		out := &jobs.Output{Messages: messages.List(), RowCounts: []int{}}
+		if err != nil {
+			return out, err
+		}
+		for _, set := range sets {
+			out.RowCounts = append(out.RowCounts, len(set.Rows))
+		}
+
+		if getString(config, "output-format") == "json" {
+			out.ContentType, out.Filename = "application/json", "result.json"
+			res := QueryResponse{Connection: spec.connection, ResultSets: make([]ResultSet, 0, len(sets)), Messages: out.Messages}
+			for _, set := range sets {
+				res.ResultSets = append(res.ResultSets, newResultSet(set, nil))
+			}
+			return out, json.NewEncoder(w).Encode(res)
+		}
+
+		g, err := s.generator(config)
+		if err != nil {
+			return out, err
+		}
+		var ext string
+		out.ContentType, ext = fileType(g)
+		out.Filename = "result" + ext
+		if name := getString(config, "output"); name != "" {
+			out.Filename = filepath.Base(name)
+		}
+		first := db.FirstResult(sets)
+		generator.SetTyped(g, generator.Typed{Types: first.TypeMap(), Nulls: first.Nulls})
+		return out, g.GenerateIO(w, first.Rows)
+	}
+}
+
+// handleListJobs lists the jobs of the caller, admins see every job.
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	p := auth.FromContext(r.Context())
+	owner := jobOwner(p)
+	if p.Can(auth.RoleAdmin) {
+		owner = ""
+	}
+	s.writeJSON(w, http.StatusOK, s.jobs.List(owner))
+}
+
+// job returns the job of the request if the caller may see it.
+func (s *Server) job(r *http.Request) (jobs.Job, error) {
+	job, err := s.jobs.Get(mux.Vars(r)["id"])
+	if err == nil && !s.canSeeJob(r, job) {
+		err = jobs.ErrNotFound
+	}
+	if errors.Is(err, jobs.ErrNotFound) {
+		return job, &requestError{status: http.StatusNotFound, msg: err.Error()}
+	}
+	return job, err
+}
+
+func (s *Server) canSeeJob(r *http.Request, job jobs.Job) bool {
+	p := auth.FromContext(r.Context())
+	return p.Can(auth.RoleAdmin) || job.Owner == jobOwner(p)
+}
+
+// handleGetJob returns the status, timing and row counts of a job.
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.job(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, job)
+}
+
+// handleJobResult downloads the result of a succeeded job.
+func (s *Server) handleJobResult(w http.ResponseWriter, r *http.Request) {
+	job, err := s.job(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	f, job, err := s.jobs.Result(job.ID)
+	if errors.Is(err, jobs.ErrNoResult) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to open the job result: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", job.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", job.Filename))
+	http.ServeContent(w, r, job.Filename, *job.FinishedAt, f)
+}
+
+// handleCancelJob cancels a queued or running job and deletes a finished
+// one with its result.
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.job(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	finished := job.State.Done()
+	job, err = s.jobs.Cancel(job.ID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if finished {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.l.Info("Job cancelled", zap.String("id", job.ID), zap.String("by", jobOwner(auth.FromContext(r.Context()))))
+	s.writeJSON(w, http.StatusAccepted, job)
+}
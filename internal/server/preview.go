@@ -0,0 +1,169 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NiclasZi/gaspecgen/db"
+	"github.com/NiclasZi/gaspecgen/internal/audit"
+	"github.com/NiclasZi/gaspecgen/pkg/renderer"
+)
+
+const (
+	defaultPreviewRows = 50
+	maxPreviewRows     = 500
+)
+
+// PreviewResponse shows what a run would do before exporting it: the
+// rendered SQL, a summary of the parsed input and the first rows of every
+// result set. The query does not run when the template fails to render.
+type PreviewResponse struct {
+	Connection  string       `json:"connection,omitempty"`
+	SQL         string       `json:"sql,omitempty"`
+	RenderError string       `json:"renderError,omitempty"`
+	Input       PreviewInput `json:"input"`
+	ResultSets  []ResultSet  `json:"resultSets,omitempty"`
+	Messages    []string     `json:"messages,omitempty"`
+	Timing      *Timing      `json:"timing,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// PreviewInput summarizes the parsed values file.
+type PreviewInput struct {
+	// Headers are the column keys after normalization, as the template sees
+	// them
+	Headers  []string `json:"headers"`
+	RowCount int      `json:"rowCount"`
+	// Used are the columns the template reads, Unused the remaining headers
+	Used     []string `json:"used"`
+	Unused   []string `json:"unused"`
+	Problems []string `json:"problems"`
+}
+
+// handlePreview renders the approved query named in the query form field, or
+// the uploaded sql_file, and runs it, answering with the first rows only.
+// The limit query parameter sets the number of rows, 50 by default, no more
+// rows than that are read from the database. Batches that may write run as a
+// dry run, see db.WithDryRun.
+func (s *Server) handlePreview(w http.ResponseWriter, r *http.Request) {
+	limit := defaultPreviewRows
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxPreviewRows {
+			s.writeJSONError(w, badRequest("limit must be a number from 1 to %d", maxPreviewRows))
+			return
+		}
+		limit = n
+	}
+
+	spec, err := s.formSpec(r)
+	if err != nil {
+		s.writeJSONError(w, err)
+		return
+	}
+
+	res := PreviewResponse{Connection: spec.connection}
+	res.Input, err = previewInput(spec)
+	if err != nil {
+		res.RenderError = err.Error()
+		s.writeJSON(w, http.StatusOK, res)
+		return
+	}
+
+	res.SQL, err = spec.render()
+	if err != nil {
+		res.RenderError = err.Error()
+		s.writeJSON(w, http.StatusOK, res)
+		return
+	}
+
+	// a template that writes must not write twice, once for the preview and
+	// again for the export
+	ctx, messages := db.WithMessages(db.WithDryRun(db.WithRowLimit(spec.context(r.Context()), limit)))
+	e, err := db.OpenNamed(ctx, spec.connection)
+	if err != nil {
+		s.record(r, audit.SourcePreview, spec, res.SQL, time.Now(), nil, err)
+		res.Error = fmt.Sprintf("failed to connect to the database: %s", err.Error())
+		s.writeJSON(w, http.StatusOK, res)
+		return
+	}
+
+	res.Timing = &Timing{StartedAt: time.Now().UTC()}
+	sets, err := e.Execute(ctx, res.SQL)
+	res.Timing.ElapsedMs = time.Since(res.Timing.StartedAt).Milliseconds()
+	s.record(r, audit.SourcePreview, spec, res.SQL, res.Timing.StartedAt, sets, err)
+	res.Messages = messages.List()
+	if err != nil {
+		res.Error = err.Error()
+		s.writeJSON(w, http.StatusOK, res)
+		return
+	}
+	res.ResultSets = make([]ResultSet, 0, len(sets))
+	for _, set := range sets {
+		res.ResultSets = append(res.ResultSets, newResultSet(set, &Page{Limit: limit}))
+	}
+	s.writeJSON(w, http.StatusOK, res)
+}
+
+// previewInput compares the parsed input with the columns the template uses,
+// the error is set when the template does not parse.
+func previewInput(spec *runSpec) (PreviewInput, error) {
+	rows := spec.data.Rows
+	in := PreviewInput{
+		Headers:  []string{},
+		RowCount: len(rows),
+		Used:     []string{},
+		Unused:   []string{},
+		Problems: []string{},
+	}
+
+	headers := map[string]bool{}
+	for _, row := range rows {
+		for key := range row {
+			headers[key] = true
+		}
+	}
+	for key := range headers {
+		in.Headers = append(in.Headers, key)
+	}
+	slices.Sort(in.Headers)
+
+	fields, err := renderer.RowFields(spec.template)
+	if err != nil {
+		return in, err
+	}
+	for _, header := range in.Headers {
+		if slices.Contains(fields, header) {
+			in.Used = append(in.Used, header)
+		} else {
+			in.Unused = append(in.Unused, header)
+		}
+	}
+
+	if len(rows) == 0 {
+		if len(fields) > 0 {
+			in.Problems = append(in.Problems, fmt.Sprintf("The query uses the input columns %s, but no values file was given", strings.Join(fields, ", ")))
+		}
+		return in, nil
+	}
+	for _, field := range fields {
+		if !headers[field] {
+			in.Problems = append(in.Problems, fmt.Sprintf("Column %q is used by the query but missing from the input, xlsx headers are normalized to lowerCamel by default, other input is used as it is", field))
+			continue
+		}
+		empty := 0
+		for _, row := range rows {
+			if strings.TrimSpace(row[field]) == "" {
+				empty++
+			}
+		}
+		if empty > 0 {
+			in.Problems = append(in.Problems, fmt.Sprintf("Column %q is empty in %d of %d rows", field, empty, len(rows)))
+		}
+	}
+	return in, nil
+}
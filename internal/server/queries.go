@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/NiclasZi/gaspecgen/db"
+	"github.com/NiclasZi/gaspecgen/pkg/renderer"
+	"github.com/NiclasZi/gaspecgen/pkg/repository"
+	"github.com/Phillezi/common/utils/or"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// handleListQueries lists the approved queries with their variables
+func (s *Server) handleListQueries(w http.ResponseWriter, r *http.Request) {
+	queries := []*repository.Query{}
+	if s.queries != nil {
+		var err error
+		if queries, err = s.queries.List(); err != nil {
+			http.Error(w, "Failed to list queries, error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(queries); err != nil {
+		s.l.Error("Failed to encode queries", zap.Error(err))
+	}
+}
+
+// handleRunQuery runs an approved query by name.
+func (s *Server) handleRunQuery(w http.ResponseWriter, r *http.Request) {
+	spec, err := s.approvedSpec(r, mux.Vars(r)["name"])
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	s.run(w, r, spec)
+}
+
+// approvedSpec reads the form for running the approved query. The form takes
+// only the optional values_file, column_spec, config with the input and
+// output options, and variables as a JSON object.
+func (s *Server) approvedSpec(r *http.Request, name string) (*runSpec, error) {
+	if s.queries == nil {
+		return nil, &requestError{status: http.StatusNotFound, msg: "No query repository is configured on this server"}
+	}
+	q, err := s.queries.Get(name)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, &requestError{status: http.StatusNotFound, msg: err.Error()}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.ParseMultipartForm(or.Or(s.maxMemoryUploadBytes, defaultMaxMemoryUploadBytes))
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return nil, badRequest("Invalid multipart form")
+	}
+
+	given := map[string]string{}
+	if raw := r.FormValue("variables"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &given); err != nil {
+			return nil, badRequest("Invalid variables, expected a JSON object of strings: %s", err.Error())
+		}
+	}
+	vars, err := q.Vars(given)
+	if err != nil {
+		return nil, badRequest("%s", err.Error())
+	}
+
+	config := readConfig(r)
+	dataRows, hasValues, err := s.loadValues(r, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.CheckInput(hasValues); err != nil {
+		return nil, badRequest("%s", err.Error())
+	}
+
+	if config == nil {
+		config = map[string]any{}
+	}
+	if getString(config, "output", s.l) == "" && getString(config, "output-format", s.l) == "" && !wantsJSON(r, config) {
+		config["output-format"] = q.Output
+	}
+
+	// a query pinned to a connection always runs there
+	connection := db.ProfileName(q.Connection)
+	if connection == "" {
+		if connection, err = s.connection(r, config, q.Connections); err != nil {
+			return nil, err
+		}
+	}
+
+	data := *renderer.FromMapArr(dataRows)
+	data.Vars = vars
+	spec := &runSpec{
+		config:     config,
+		connection: connection,
+		template:   q.Template,
+		data:       data,
+		name:       q.Name,
+		cache:      s.cachePolicy(r, config),
+	}
+	spec.input, spec.inputHash = valuesFile(r)
+	return spec, nil
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/NiclasZi/gaspecgen/internal/auth"
+	"github.com/NiclasZi/gaspecgen/pkg/repository"
+	"github.com/spf13/viper"
+)
+
+func TestQueryConnection(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"free.sql":    "SELECT 1 AS n",
+		"open.sql":    "SELECT 1 AS n",
+		"open.yaml":   "connections: [Other]\n",
+		"pinned.sql":  "SELECT 1 AS n",
+		"pinned.yaml": "connection: other\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo, err := repository.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := auth.NewTokens([]auth.Token{
+		{Name: "viewer", Token: "viewer-token"},
+		{Name: "author", Token: "author-token", Role: "author"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newTestServer(t, WithQueries(repo), WithAuth(tokens))
+	// the profiles fall back to the sqlite driver and fixture of the flat keys
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(strings.NewReader(`
+default-connection: main
+connections:
+  main:
+    app-name: main
+  other:
+    app-name: other
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		path       string
+		connection string
+		want       string
+		status     int
+	}{
+		{"selected profile", "viewer", "/api/queries/free/run", "", "main", http.StatusOK},
+		{"selected profile named", "viewer", "/api/queries/free/run", "MAIN", "main", http.StatusOK},
+		{"viewer picks another profile", "viewer", "/api/queries/free/run", "other", "", http.StatusForbidden},
+		{"viewer picks an allowed profile", "viewer", "/api/queries/open/run", "other", "other", http.StatusOK},
+		{"pinned query", "viewer", "/api/queries/pinned/run", "main", "other", http.StatusOK},
+		{"author picks another profile", "author", "/api/queries/free/run", "other", "other", http.StatusOK},
+		{"viewer previews on another profile", "viewer", "/api/preview?query=free", "other", "", http.StatusForbidden},
+		{"viewer previews on an allowed profile", "viewer", "/api/preview?query=open", "other", "other", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{"config": `{"output-format": "json", "connection": "` + tt.connection + `"}`}
+			path, query, _ := strings.Cut(tt.path, "?query=")
+			if query != "" {
+				files["query"] = query
+			}
+			res := postForm(t, ts.URL+path, files, http.Header{"Authorization": {"Bearer " + tt.token + "-token"}})
+			if res.StatusCode != tt.status {
+				t.Fatalf("got %s, want %d", res.Status, tt.status)
+			}
+			if tt.want == "" {
+				return
+			}
+			var got QueryResponse
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Connection != tt.want {
+				t.Errorf("ran on %q, want %q", got.Connection, tt.want)
+			}
+		})
+	}
+}
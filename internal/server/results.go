@@ -0,0 +1,209 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NiclasZi/gaspecgen/db"
+	"github.com/NiclasZi/gaspecgen/internal/audit"
+	"go.uber.org/zap"
+)
+
+// QueryResponse is the JSON answer of a query for programmatic clients.
+// Failed requests only carry the error, and the messages and timing when
+// the query ran.
+type QueryResponse struct {
+	Connection string      `json:"connection,omitempty"`
+	ResultSets []ResultSet `json:"resultSets,omitempty"`
+	Messages   []string    `json:"messages,omitempty"`
+	Timing     *Timing     `json:"timing,omitempty"`
+	Page       *Page       `json:"page,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// ResultSet is a single result set, the values of a row are in column order
+// and NULL is an empty string.
+type ResultSet struct {
+	Columns []Column   `json:"columns"`
+	Rows    [][]string `json:"rows"`
+	// TotalRows counts the rows of the set before paging
+	TotalRows int `json:"totalRows"`
+	// Truncated is set when the set had more rows than were read, e.g. in
+	// a preview, TotalRows is then only the rows read
+	Truncated bool `json:"truncated,omitempty"`
+	// CachedAt is set when the set was served from the result cache
+	CachedAt *time.Time `json:"cachedAt,omitempty"`
+}
+
+type Column struct {
+	Name string `json:"name"`
+	// Type is the database type name, e.g. NVARCHAR
+	Type string `json:"type,omitempty"`
+}
+
+type Timing struct {
+	StartedAt time.Time `json:"startedAt"`
+	ElapsedMs int64     `json:"elapsedMs"`
+}
+
+// Page is the paging applied to every result set.
+type Page struct {
+	Limit  int `json:"limit,omitempty"`
+	Offset int `json:"offset"`
+}
+
+// wantsJSON tells if the result should be answered as JSON, either asked
+// for with the json output format or through the Accept header when no
+// output file or format is set.
+func wantsJSON(r *http.Request, config map[string]any) bool {
+	format := getString(config, "output-format")
+	if format != "" || getString(config, "output") != "" {
+		return format == "json"
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil || mediaType != "application/json" {
+			continue
+		}
+		if q, ok := params["q"]; !ok || q != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// readPage reads limit and offset from the URL, falling back to the config.
+func readPage(r *http.Request, config map[string]any) (*Page, error) {
+	value := func(key string) (int, error) {
+		raw := r.URL.Query().Get(key)
+		if raw == "" {
+			return getInt(config, key), nil
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return 0, badRequest("%s must be a number", key)
+		}
+		return n, nil
+	}
+
+	limit, err := value("limit")
+	if err != nil {
+		return nil, err
+	}
+	offset, err := value("offset")
+	if err != nil {
+		return nil, err
+	}
+	if limit < 0 || offset < 0 {
+		return nil, badRequest("limit and offset must not be negative")
+	}
+	if limit == 0 && offset == 0 {
+		return nil, nil
+	}
+	return &Page{Limit: limit, Offset: offset}, nil
+}
+
+// newResultSet converts the result, limit 0 means every row after offset.
+func newResultSet(res *db.Result, page *Page) ResultSet {
+	set := ResultSet{
+		Columns:   make([]Column, len(res.Columns)),
+		Rows:      [][]string{},
+		TotalRows: len(res.Rows),
+		Truncated: res.Truncated,
+	}
+	if !res.CachedAt.IsZero() {
+		set.CachedAt = &res.CachedAt
+	}
+	for i, name := range res.Columns {
+		set.Columns[i] = Column{Name: name}
+		if i < len(res.Types) {
+			set.Columns[i].Type = res.Types[i]
+		}
+	}
+
+	rows := res.Rows
+	if page != nil {
+		rows = rows[min(page.Offset, len(rows)):]
+		if page.Limit > 0 {
+			rows = rows[:min(page.Limit, len(rows))]
+		}
+	}
+	for _, row := range rows {
+		values := make([]string, len(res.Columns))
+		for i, col := range res.Columns {
+			values[i] = row[col]
+		}
+		set.Rows = append(set.Rows, values)
+	}
+	return set
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.l.Error("Failed to encode response", zap.Error(err))
+	}
+}
+
+// runJSON runs the template and answers with every result set, the server
+// messages and the timing as a QueryResponse.
+func (s *Server) runJSON(w http.ResponseWriter, r *http.Request, spec *runSpec) {
+	page, err := readPage(r, spec.config)
+	if err != nil {
+		s.writeJSONError(w, err)
+		return
+	}
+
+	query, err := spec.render()
+	if err != nil {
+		s.writeJSONError(w, badRequest("Failed to render SQL query with the provided input data, error: %s", err.Error()))
+		return
+	}
+
+	ctx, messages := db.WithMessages(spec.context(r.Context()))
+	e, err := db.OpenNamed(ctx, spec.connection)
+	if err != nil {
+		s.record(r, audit.SourceServer, spec, query, time.Now(), nil, err)
+		s.writeJSONError(w, fmt.Errorf("failed to connect to the database: %w", err))
+		return
+	}
+
+	timing := &Timing{StartedAt: time.Now().UTC()}
+	sets, err := e.Execute(ctx, query)
+	timing.ElapsedMs = time.Since(timing.StartedAt).Milliseconds()
+	s.record(r, audit.SourceServer, spec, query, timing.StartedAt, sets, err)
+
+	res := QueryResponse{
+		Connection: spec.connection,
+		Messages:   messages.List(),
+		Timing:     timing,
+	}
+	if err != nil {
+		res.Error = err.Error()
+		s.writeJSON(w, http.StatusInternalServerError, res)
+		return
+	}
+	res.ResultSets = make([]ResultSet, 0, len(sets))
+	for _, set := range sets {
+		res.ResultSets = append(res.ResultSets, newResultSet(set, page))
+	}
+	res.Page = page
+	s.writeJSON(w, http.StatusOK, res)
+}
+
+// writeJSONError answers with the error as a QueryResponse.
+func (s *Server) writeJSONError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var re *requestError
+	if errors.As(err, &re) {
+		status = re.status
+	}
+	s.writeJSON(w, status, QueryResponse{Error: err.Error()})
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/NiclasZi/gaspecgen/pkg/repository"
+	"github.com/NiclasZi/gaspecgen/pkg/scaffold"
+	"github.com/Phillezi/common/utils/or"
+	"go.uber.org/zap"
+)
+
+// handleScaffold answers with a blank input workbook for the approved query
+// named in the query parameter or form field, or for the uploaded sql_file.
+// The template is only parsed, never run, so viewers may scaffold uploads.
+func (s *Server) handleScaffold(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(or.Or(s.maxMemoryUploadBytes, defaultMaxMemoryUploadBytes))
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		http.Error(w, "Invalid multipart form", http.StatusBadRequest)
+		return
+	}
+
+	template, base, err := s.scaffoldTemplate(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	spec, err := scaffold.Describe(template)
+	if err != nil {
+		http.Error(w, "Failed to read the template input, error: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if spec.Title == "" {
+		spec.Title = base
+	}
+
+	var buf bytes.Buffer
+	if err := scaffold.WriteWorkbook(&buf, spec); err != nil {
+		s.l.Error("Failed to write input workbook", zap.Error(err))
+		http.Error(w, "Failed to write the input workbook", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", base+"-input.xlsx"))
+	w.Write(buf.Bytes())
+}
+
+// scaffoldTemplate returns the template to scaffold and the base of the
+// workbook name.
+func (s *Server) scaffoldTemplate(r *http.Request) (string, string, error) {
+	if name := r.FormValue("query"); name != "" {
+		if s.queries == nil {
+			return "", "", &requestError{status: http.StatusNotFound, msg: "No query repository is configured on this server"}
+		}
+		q, err := s.queries.Get(name)
+		if errors.Is(err, repository.ErrNotFound) {
+			return "", "", &requestError{status: http.StatusNotFound, msg: err.Error()}
+		}
+		if err != nil {
+			return "", "", err
+		}
+		return q.Template, q.Name, nil
+	}
+
+	sqlFile, header, err := r.FormFile("sql_file")
+	if err != nil {
+		return "", "", badRequest("Missing sql_file or query")
+	}
+	defer sqlFile.Close()
+	content, err := io.ReadAll(sqlFile)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read sql_file: %w", err)
+	}
+	base := strings.TrimSuffix(filepath.Base(header.Filename), filepath.Ext(header.Filename))
+	return string(content), or.Or(base, "template"), nil
+}
@@ -2,17 +2,28 @@ package server
 
 import (
 	"context"
+	"crypto/rand"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/NiclasZi/gaspecgen/db"
+	"github.com/NiclasZi/gaspecgen/internal/audit"
+	"github.com/NiclasZi/gaspecgen/internal/auth"
+	"github.com/NiclasZi/gaspecgen/internal/jobs"
 	"github.com/NiclasZi/gaspecgen/pkg/generator"
 	"github.com/NiclasZi/gaspecgen/pkg/loader"
+	"github.com/NiclasZi/gaspecgen/pkg/preprocess"
 	"github.com/NiclasZi/gaspecgen/pkg/renderer"
+	"github.com/NiclasZi/gaspecgen/pkg/repository"
 	"github.com/Phillezi/common/utils/or"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
@@ -26,58 +37,218 @@ const (
 //go:embed embed/index.html
 var indexHTML string
 
+//go:embed embed/openapi.json
+var openAPIJSON []byte
+
 type Server struct {
 	host       string
 	httpServer *http.Server
 	ctx        context.Context
 	cancel     context.CancelFunc
 
+	// listener is set by Listen, Start listens itself when it is nil
+	listener net.Listener
+	// tlsCert and tlsKey are the PEM files served over HTTPS, plain HTTP
+	// when empty
+	tlsCert, tlsKey string
+	// allowedHosts are more names the server is reached by besides
+	// localhost, its host and IP addresses
+	allowedHosts []string
+	// launchToken is required once per browser session when set
+	launchToken string
+	// secret signs the CSRF and launch cookies
+	secret []byte
+
 	maxMemoryUploadBytes int64
 
+	// queries is the repository of approved queries, nil when not configured
+	queries *repository.Repository
+	// sqlUpload allows running uploaded SQL templates on /api/query
+	sqlUpload bool
+	// jobs runs queries in the background, nil disables /api/jobs
+	jobs *jobs.Queue
+	// authenticators check the callers of /api, every caller is an admin
+	// when there are none
+	authenticators []auth.Authenticator
+	// audit records every executed query, nil records nothing
+	audit *audit.Log
+
 	l *zap.Logger
 }
 
-func New(ctx context.Context, port int, hostOpt ...string) *Server {
+// Option configures the Server.
+type Option func(*Server)
+
+// WithHost sets the address to listen on, localhost by default.
+func WithHost(host string) Option {
+	return func(s *Server) { s.host = or.Or(host, s.host) }
+}
+
+// WithTLS serves HTTPS with the certificate and key PEM files.
+func WithTLS(certFile, keyFile string) Option {
+	return func(s *Server) { s.tlsCert, s.tlsKey = certFile, keyFile }
+}
+
+// WithAllowedHosts accepts requests for the host names, e.g. the name of a
+// reverse proxy. Other names are rejected against DNS rebinding.
+func WithAllowedHosts(hosts ...string) Option {
+	return func(s *Server) { s.allowedHosts = hosts }
+}
+
+// WithLaunchToken requires the token from LaunchURL before the UI or the API
+// can be used.
+func WithLaunchToken(token string) Option {
+	return func(s *Server) { s.launchToken = token }
+}
+
+// WithQueries serves the approved queries of the repository.
+func WithQueries(repo *repository.Repository) Option {
+	return func(s *Server) { s.queries = repo }
+}
+
+// WithSQLUpload allows or forbids running uploaded SQL templates, they are
+// allowed by default.
+func WithSQLUpload(allowed bool) Option {
+	return func(s *Server) { s.sqlUpload = allowed }
+}
+
+// WithAuth requires callers of the API to authenticate with one of the
+// authenticators.
+func WithAuth(authenticators ...auth.Authenticator) Option {
+	return func(s *Server) { s.authenticators = authenticators }
+}
+
+// WithJobs serves the job queue on /api/jobs.
+func WithJobs(q *jobs.Queue) Option {
+	return func(s *Server) { s.jobs = q }
+}
+
+// WithAudit records every query the server executes in the log.
+func WithAudit(l *audit.Log) Option {
+	return func(s *Server) { s.audit = l }
+}
+
+// New configures the server, it fails when no secret for the session
+// cookies can be generated.
+func New(ctx context.Context, port int, opts ...Option) (*Server, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate the session secret: %w", err)
+	}
 	ctxx, cancel := context.WithCancel(ctx)
 
 	s := &Server{
-		host:   or.Or(or.Or(hostOpt...), "localhost"),
-		ctx:    ctxx,
-		cancel: cancel,
-		l:      zap.L().Named("[SERVER]"),
+		host:      "localhost",
+		ctx:       ctxx,
+		cancel:    cancel,
+		sqlUpload: true,
+		secret:    secret,
+		l:         zap.L().Named("[SERVER]"),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
 
 	router := mux.NewRouter()
+	router.Use(s.guard)
 
 	// API endpoints
 	api := router.PathPrefix("/api").Subrouter()
-	api.HandleFunc("/query", s.handleStreamTransform).Methods("POST")
+	api.Use(s.csrf, auth.Middleware(s.l, s.authenticators...))
+	api.Handle("/query", auth.Require(auth.RoleAuthor, http.HandlerFunc(s.handleStreamTransform))).Methods("POST")
+	api.Handle("/connections", auth.Require(auth.RoleViewer, http.HandlerFunc(s.handleConnections))).Methods("GET")
+	api.Handle("/queries", auth.Require(auth.RoleViewer, http.HandlerFunc(s.handleListQueries))).Methods("GET")
+	api.Handle("/queries/{name}/run", auth.Require(auth.RoleViewer, http.HandlerFunc(s.handleRunQuery))).Methods("POST")
+	api.Handle("/preview", auth.Require(auth.RoleViewer, http.HandlerFunc(s.handlePreview))).Methods("POST")
+	api.Handle("/scaffold", auth.Require(auth.RoleViewer, http.HandlerFunc(s.handleScaffold))).Methods("GET", "POST")
+	if s.jobs != nil {
+		api.Handle("/jobs", auth.Require(auth.RoleViewer, http.HandlerFunc(s.handleSubmitJob))).Methods("POST")
+		api.Handle("/jobs", auth.Require(auth.RoleViewer, http.HandlerFunc(s.handleListJobs))).Methods("GET")
+		api.Handle("/jobs/{id}", auth.Require(auth.RoleViewer, http.HandlerFunc(s.handleGetJob))).Methods("GET")
+		api.Handle("/jobs/{id}", auth.Require(auth.RoleViewer, http.HandlerFunc(s.handleCancelJob))).Methods("DELETE")
+		api.Handle("/jobs/{id}/result", auth.Require(auth.RoleViewer, http.HandlerFunc(s.handleJobResult))).Methods("GET")
+	}
+	api.HandleFunc("/me", s.handleMe).Methods("GET")
+	api.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPIJSON)
+	}).Methods("GET")
 
-	router.PathPrefix("/").Handler(func() http.HandlerFunc {
-		return func(w http.ResponseWriter, r *http.Request) {
-			fmt.Fprintln(w, indexHTML)
-		}
-	}())
+	router.PathPrefix("/").HandlerFunc(s.handleIndex)
 
 	s.httpServer = &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", s.host, port),
+		Addr:    net.JoinHostPort(s.host, strconv.Itoa(port)),
 		Handler: router,
 	}
 
-	return s
+	return s, nil
 }
 
+// handleIndex serves the UI with the CSRF token of the session.
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	token, err := s.csrfToken(w, r)
+	if err != nil {
+		http.Error(w, "Failed to create a session", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// other sites must not frame the UI and trick clicks into it
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintln(w, strings.Replace(indexHTML, csrfPlaceholder, token, 1))
+}
+
+// LaunchURL returns the URL to open the UI with, it carries the launch token
+// when one is required.
+func (s *Server) LaunchURL() string {
+	if s.launchToken == "" {
+		return s.Addr()
+	}
+	return s.Addr() + "/?" + url.Values{launchParam: {s.launchToken}}.Encode()
+}
+
+// Addr returns the URL of the server, with the port picked by the system
+// for port 0 once it listens.
 func (s *Server) Addr() string {
-	return fmt.Sprintf("http://%s", s.httpServer.Addr)
+	scheme, addr := "http", s.httpServer.Addr
+	if s.tlsCert != "" {
+		scheme = "https"
+	}
+	if s.listener != nil {
+		_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+		addr = net.JoinHostPort(s.host, port)
+	}
+	return fmt.Sprintf("%s://%s", scheme, addr)
+}
+
+// Listen binds the address, so a port in use is reported before Start.
+func (s *Server) Listen() error {
+	ln, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = ln
+	return nil
 }
 
 func (s *Server) Start() error {
+	if s.listener == nil {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+	}
 	errCh := make(chan error, 1)
 
 	// Start server
 	go func() {
-		s.l.Info("Starting server on", zap.String("address", s.httpServer.Addr))
-		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		s.l.Info("Starting server on", zap.String("address", s.Addr()))
+		var err error
+		if s.tlsCert != "" {
+			err = s.httpServer.ServeTLS(s.listener, s.tlsCert, s.tlsKey)
+		} else {
+			err = s.httpServer.Serve(s.listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			errCh <- err
 			s.cancel()
 		}
@@ -95,30 +266,175 @@ func (s *Server) Start() error {
 	}
 }
 
-// Very large method...
-// Divide into multiple functions
-func (s *Server) handleStreamTransform(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+// requestError is an error caused by the request, reported with its status.
+type requestError struct {
+	status int
+	msg    string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, args ...any) error {
+	return &requestError{status: http.StatusBadRequest, msg: fmt.Sprintf(format, args...)}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	var re *requestError
+	if errors.As(err, &re) {
+		http.Error(w, re.msg, re.status)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
 
+func forbidden(format string, args ...any) error {
+	return &requestError{status: http.StatusForbidden, msg: fmt.Sprintf(format, args...)}
+}
+
+// runSpec is a template with its input data and the options of the request,
+// ready to render and run.
+type runSpec struct {
+	config     map[string]any
+	connection string
+	template   string
+	data       renderer.QueryData
+	// name is the approved query, empty for uploaded templates
+	name string
+	// file is the file name of the uploaded template
+	file string
+	// input and inputHash are the file name and SHA-256 of the values file
+	input, inputHash string
+	// cache is set when the request overrides --refresh and --no-cache
+	cache *db.CachePolicy
+}
+
+// context returns ctx with the cache policy of the request.
+func (spec *runSpec) context(ctx context.Context) context.Context {
+	if spec.cache == nil {
+		return ctx
+	}
+	return db.WithCachePolicy(ctx, *spec.cache)
+}
+
+// render renders the template with the input data.
+func (spec *runSpec) render() (string, error) {
+	return renderer.NewGoTemplateRenderer().Render(spec.template, spec.data)
+}
+
+// formSpec reads the approved query named in the query form field, or the
+// uploaded sql_file when there is none, which needs the author role.
+func (s *Server) formSpec(r *http.Request) (*runSpec, error) {
 	err := r.ParseMultipartForm(or.Or(s.maxMemoryUploadBytes, defaultMaxMemoryUploadBytes))
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return nil, badRequest("Invalid multipart form")
+	}
+
+	if name := r.FormValue("query"); name != "" {
+		return s.approvedSpec(r, name)
+	}
+	if !auth.FromContext(r.Context()).Can(auth.RoleAuthor) {
+		return nil, forbidden("Forbidden, uploading SQL needs the %s role", auth.RoleAuthor)
+	}
+	return s.uploadSpec(r)
+}
+
+// handleStreamTransform renders an uploaded SQL template with the optional
+// values file, runs it and streams the result back.
+func (s *Server) handleStreamTransform(w http.ResponseWriter, r *http.Request) {
+	spec, err := s.uploadSpec(r)
 	if err != nil {
-		http.Error(w, "Invalid multipart form", http.StatusBadRequest)
+		writeError(w, err)
 		return
 	}
+	s.run(w, r, spec)
+}
+
+// uploadSpec reads the uploaded sql_file with the optional values file.
+func (s *Server) uploadSpec(r *http.Request) (*runSpec, error) {
+	if !s.sqlUpload {
+		return nil, forbidden("Uploading SQL is disabled on this server, run an approved query from /api/queries instead")
+	}
 
-	sqlFile, _, err := r.FormFile("sql_file")
+	err := r.ParseMultipartForm(or.Or(s.maxMemoryUploadBytes, defaultMaxMemoryUploadBytes))
 	if err != nil {
-		http.Error(w, "Missing sql_file", http.StatusBadRequest)
-		return
+		return nil, badRequest("Invalid multipart form")
+	}
+
+	sqlFile, header, err := r.FormFile("sql_file")
+	if err != nil {
+		return nil, badRequest("Missing sql_file")
 	}
 	defer sqlFile.Close()
 
 	sqlBytes, err := io.ReadAll(sqlFile)
 	if err != nil {
-		http.Error(w, "Failed to read sql_file", http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("failed to read sql_file: %w", err)
+	}
+
+	config := readConfig(r)
+	dataRows, _, err := s.loadValues(r, config)
+	if err != nil {
+		return nil, err
+	}
+
+	connection, err := s.connection(r, config, nil)
+	if err != nil {
+		return nil, err
 	}
 
+	spec := &runSpec{
+		config:     config,
+		connection: connection,
+		template:   string(sqlBytes),
+		data:       *renderer.FromMapArr(dataRows),
+		file:       header.Filename,
+		cache:      s.cachePolicy(r, config),
+	}
+	spec.input, spec.inputHash = valuesFile(r)
+	return spec, nil
+}
+
+// connection returns the connection profile the config of the request asks
+// for, the selected profile when it names none. Authors pick any profile,
+// other callers only the allowed ones.
+func (s *Server) connection(r *http.Request, config map[string]any, allowed []string) (string, error) {
+	selected := db.SelectedProfile()
+	name := db.ProfileName(getString(config, "connection", s.l))
+	if name == "" || name == selected {
+		return selected, nil
+	}
+	if auth.FromContext(r.Context()).Can(auth.RoleAuthor) {
+		return name, nil
+	}
+	for _, a := range allowed {
+		if db.ProfileName(a) == name {
+			return name, nil
+		}
+	}
+	return "", forbidden("Forbidden, running on connection %s needs the %s role", name, auth.RoleAuthor)
+}
+
+// cachePolicy returns the result cache policy the request asks for with the
+// no-cache and refresh options of the config or a Cache-Control header, nil
+// when it asks for none.
+func (s *Server) cachePolicy(r *http.Request, config map[string]any) *db.CachePolicy {
+	cc := strings.ToLower(r.Header.Get("Cache-Control"))
+	var p db.CachePolicy
+	switch {
+	case getT[bool](config, "no-cache", s.l) || strings.Contains(cc, "no-store"):
+		p = db.CacheSkip
+	case getT[bool](config, "refresh", s.l) || strings.Contains(cc, "no-cache"):
+		p = db.CacheRefresh
+	default:
+		return nil
+	}
+	return &p
+}
+
+// readConfig parses the optional config file of the form as YAML or JSON.
+func readConfig(r *http.Request) map[string]any {
 	var config map[string]any
 	if cf, _, err := r.FormFile("config"); err == nil {
 		defer cf.Close()
@@ -128,104 +444,127 @@ func (s *Server) handleStreamTransform(w http.ResponseWriter, r *http.Request) {
 			json.Unmarshal(data, &config)
 		}
 	}
+	return config
+}
 
-	rend := renderer.NewGoTemplateRenderer()
-
-	var query string
-	var dataRows []map[string]string
-	if vf, header, err := r.FormFile("values_file"); err == nil {
-		defer vf.Close()
-
-		loaderOpts := loader.LoadOpts{
-			Sheet:      getString(config, "sheet-name-in", s.l),
-			SheetIndex: getT[int](config, "sheet-index-in", s.l),
-		}
-		ld, err := loader.GetLoaderIO(header.Filename, vf, loaderOpts)
-		if err != nil {
-			http.Error(w, "Failed to get loader for values_file: "+err.Error(), http.StatusBadRequest)
-			return
-		}
-		dataRows, err = ld.LoadIO(vf)
-		if err != nil {
-			http.Error(w, "Failed to load values_file: "+err.Error(), http.StatusBadRequest)
-			return
-		}
-		q, err := rend.Render(string(sqlBytes), *renderer.FromMapArr(dataRows))
-		if err != nil {
-			http.Error(w, "Failed to render SQL query with the provided input data, error: "+err.Error(), http.StatusBadRequest)
-			return
-		}
-		query = q
-	} else {
-		q, err := rend.Render(string(sqlBytes), renderer.QueryData{})
+// loadValues reads and preprocesses the values file of the form, ok is false
+// when the form has none.
+func (s *Server) loadValues(r *http.Request, config map[string]any) (rows []map[string]string, ok bool, err error) {
+	vf, header, err := r.FormFile("values_file")
+	if err != nil {
+		return nil, false, nil
+	}
+	defer vf.Close()
+
+	loaderOpts := loader.LoadOpts{
+		Format:     getString(config, "input-format", s.l),
+		Sheet:      getString(config, "sheet-name-in", s.l),
+		SheetIndex: getInt(config, "sheet-index-in", s.l),
+		JSONPath:   getString(config, "json-path", s.l),
+
+		HeaderRow:     getInt(config, "header-row", s.l),
+		Range:         getString(config, "range", s.l),
+		Table:         getString(config, "table", s.l),
+		SkipBlankRows: getT[bool](config, "skip-blank-rows", s.l),
+		RawValues:     getT[bool](config, "raw-values", s.l),
+		FillMerged:    getT[bool](config, "fill-merged", s.l),
+
+		Encoding:      getString(config, "csv-encoding", s.l),
+		BOM:           getString(config, "csv-bom", s.l),
+		SkipMalformed: getT[bool](config, "csv-skip-malformed", s.l),
+	}
+	if loaderOpts.Delimiter, err = loader.ParseDelimiter(getString(config, "csv-delimiter", s.l)); err != nil {
+		return nil, true, badRequest("%s", err.Error())
+	}
+	headerMode, err := loader.ParseHeaderMode(getString(config, "headers", s.l))
+	if err != nil {
+		return nil, true, badRequest("%s", err.Error())
+	}
+	loaderOpts.Headers = &loader.HeaderNormalizer{
+		Mode:    headerMode,
+		Aliases: getStringMap(config, "header-alias", s.l),
+	}
+	if sf, _, err := r.FormFile("column_spec"); err == nil {
+		defer sf.Close()
+		spec, err := loader.ParseFixedWidthSpec(sf)
 		if err != nil {
-			http.Error(w, "Failed to render SQL query with the provided input data, error: "+err.Error(), http.StatusBadRequest)
-			return
+			return nil, true, badRequest("Failed to parse column_spec: %s", err.Error())
 		}
-		query = q
+		loaderOpts.FixedWidth = spec
 	}
-
-	db, err := db.Get()
+	ld, err := loader.GetLoaderIO(header.Filename, vf, loaderOpts)
 	if err != nil {
-		http.Error(w, "Failed to connect to the database, error: "+err.Error(), http.StatusInternalServerError)
-		return
+		return nil, true, badRequest("Failed to get loader for values_file: %s", err.Error())
+	}
+	rows, err = ld.LoadIO(vf)
+	if err != nil {
+		return nil, true, badRequest("Failed to load values_file: %s", err.Error())
+	}
+	spec := &preprocess.Spec{
+		Filter:    getString(config, "filter", s.l),
+		Dedupe:    getStringSlice(config, "dedupe", s.l),
+		GroupBy:   getStringSlice(config, "group-by", s.l),
+		Aggregate: getStringMap(config, "aggregate", s.l),
+		Separator: getString(config, "separator", s.l),
+	}
+	rows, err = spec.Apply(rows)
+	if err != nil {
+		return nil, true, badRequest("Failed to preprocess values_file: %s", err.Error())
 	}
+	return rows, true, nil
+}
 
-	stmt, err := db.GetConnection().Prepare(query)
+// valuesFile returns the file name and SHA-256 of the values file of the
+// form, empty when there is none.
+func valuesFile(r *http.Request) (name, hash string) {
+	vf, header, err := r.FormFile("values_file")
 	if err != nil {
-		http.Error(w, "Failed to prepare query, error: "+err.Error(), http.StatusInternalServerError)
+		return "", ""
+	}
+	defer vf.Close()
+	hash, _ = audit.HashReader(vf)
+	return header.Filename, hash
+}
+
+// run renders the template, executes it on the connection and streams the
+// generated output.
+func (s *Server) run(w http.ResponseWriter, r *http.Request, spec *runSpec) {
+	if wantsJSON(r, spec.config) {
+		s.runJSON(w, r, spec)
 		return
 	}
-	defer stmt.Close()
+	ctx, config := spec.context(r.Context()), spec.config
 
-	rows, err := stmt.Query()
+	query, err := spec.render()
 	if err != nil {
-		http.Error(w, "Query execution failed, error: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to render SQL query with the provided input data, error: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer rows.Close()
 
-	columns, err := rows.Columns()
+	// the request context cancels the query when the client goes away
+	started := time.Now()
+	e, err := db.OpenNamed(ctx, spec.connection)
 	if err != nil {
-		http.Error(w, "Failed to get columns, error: "+err.Error(), http.StatusInternalServerError)
+		s.record(r, audit.SourceServer, spec, query, started, nil, err)
+		http.Error(w, "Failed to connect to the database, error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	var results []map[string]string
-	for rows.Next() {
-		values := make([]any, len(columns))
-		valuePtrs := make([]any, len(columns))
-		for i := range values {
-			valuePtrs[i] = &values[i]
-		}
-
-		if err := rows.Scan(valuePtrs...); err != nil {
-			http.Error(w, "Failed to scan row, error: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		rowMap := make(map[string]string)
-		for i, col := range columns {
-			var val string
-			if b, ok := values[i].([]byte); ok {
-				val = string(b)
-			} else if values[i] != nil {
-				val = fmt.Sprintf("%v", values[i])
-			} else {
-				val = ""
-			}
-			rowMap[col] = val
-		}
-		results = append(results, rowMap)
+	res, err := e.Query(ctx, query)
+	if err != nil {
+		s.record(r, audit.SourceServer, spec, query, started, nil, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	s.record(r, audit.SourceServer, spec, query, started, []*db.Result{res}, nil)
+	results := res.Rows
 
-	g, err := generator.GetGenerator(getString(config, "output", s.l), generator.GenerationOptions{
-		SheetName: getString(config, "sheet", s.l),
-	})
+	g, err := s.generator(config)
 	if err != nil {
 		http.Error(w, "Failed to get generator, error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	generator.SetTyped(g, generator.Typed{Types: res.TypeMap(), Nulls: res.Nulls})
 
 	// Pipe for streaming
 	pr, pw := io.Pipe()
@@ -237,16 +576,8 @@ func (s *Server) handleStreamTransform(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	switch g.(type) {
-	case *generator.XLSXGenerator:
-		// For .xlsx
-		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-	case *generator.CSVGenerator:
-		// For .csv
-		w.Header().Set("Content-Type", "text/csv")
-	default:
-		w.Header().Set("Content-Type", "text/plain")
-	}
+	ct, _ := fileType(g)
+	w.Header().Set("Content-Type", ct)
 
 	w.WriteHeader(http.StatusOK)
 
@@ -272,3 +603,58 @@ func (s *Server) handleStreamTransform(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 }
+
+// generator returns the generator for the output options of the config.
+func (s *Server) generator(config map[string]any) (generator.Generator, error) {
+	return generator.GetGenerator(getString(config, "output", s.l), generator.GenerationOptions{
+		Format:    getString(config, "output-format", s.l),
+		SheetName: getString(config, "sheet", s.l),
+		SQLTable:  getString(config, "sql-table", s.l),
+		SQLMode:   getString(config, "sql-mode", s.l),
+		SQLKeys:   strings.Join(getStringSlice(config, "sql-keys", s.l), ","),
+	})
+}
+
+// fileType returns the content type and file extension of the output.
+func fileType(g generator.Generator) (contentType, ext string) {
+	switch g.(type) {
+	case *generator.XLSXGenerator:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", ".xlsx"
+	case *generator.CSVGenerator:
+		return "text/csv", ".csv"
+	case *generator.SQLGenerator:
+		return "application/sql", ".sql"
+	default:
+		return "text/plain", ".txt"
+	}
+}
+
+// handleConnections lists the connection profiles without any credentials
+func (s *Server) handleConnections(w http.ResponseWriter, r *http.Request) {
+	conns, err := db.Connections()
+	if err != nil {
+		http.Error(w, "Failed to list connections, error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(conns); err != nil {
+		s.l.Error("Failed to encode connections", zap.Error(err))
+	}
+}
+
+// handleMe describes the caller, the UI uses it to hide what the role of
+// the caller does not allow
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
+	p := auth.FromContext(r.Context())
+	me := struct {
+		*auth.Principal
+		SQLUpload   bool `json:"sqlUpload"`
+		ResultCache bool `json:"resultCache"`
+	}{p, s.sqlUpload && p.Can(auth.RoleAuthor), db.ResultCacheEnabled()}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(me); err != nil {
+		s.l.Error("Failed to encode caller", zap.Error(err))
+	}
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/NiclasZi/gaspecgen/db"
+	"github.com/spf13/viper"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	testFixture = `
+CREATE TABLE articles (artNr TEXT PRIMARY KEY, price REAL);
+INSERT INTO articles VALUES ('A-1', 1.5), ('A-2', 2);
+`
+	testTemplate = `WITH input(artNr, qty) AS (VALUES
+{{- range $i, $row := .Rows }}{{ if $i }},{{ end }}
+    ('{{ $row.artNr }}', {{ $row.qty }})
+{{- end }}
+)
+SELECT input.artNr, input.qty, articles.price
+FROM input LEFT JOIN articles ON articles.artNr = input.artNr
+ORDER BY input.artNr`
+	testValues = "Art Nr;Qty\nA-2;3\nA-1;5\nA-3;7\n"
+)
+
+// newTestServer serves the API on an in-memory SQLite database with the
+// articles fixture.
+func newTestServer(t *testing.T, opts ...Option) *httptest.Server {
+	t.Helper()
+	fixture := filepath.Join(t.TempDir(), "fixture.sql")
+	if err := os.WriteFile(fixture, []byte(testFixture), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	viper.Reset()
+	viper.Set("db-driver", db.DriverSQLite)
+	viper.Set("db-fixture", []string{fixture})
+	t.Cleanup(func() {
+		db.CloseAll()
+		viper.Reset()
+	})
+
+	s, err := New(context.Background(), 0, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.httpServer.Handler)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// postForm posts the files and fields of the multipart form to the url with
+// the optional headers.
+func postForm(t *testing.T, url string, files map[string]string, header ...http.Header) *http.Response {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for field, content := range files {
+		var name string
+		switch field {
+		case "sql_file":
+			name = "query.sql"
+		case "values_file":
+			name = "values.csv"
+		case "config":
+			name = "config.json"
+		default:
+			mw.WriteField(field, content)
+			continue
+		}
+		fw, err := mw.CreateFormFile(field, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(fw, content)
+	}
+	mw.Close()
+
+	req, err := http.NewRequest(http.MethodPost, url, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range header {
+		req.Header = h.Clone()
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+func TestQueryRoundTrip(t *testing.T) {
+	ts := newTestServer(t)
+	// the header row is turned into the template keys, csv headers are only
+	// normalized on request
+	want := [][]string{
+		{"artNr", "price", "qty"},
+		{"A-1", "1.5", "5"},
+		{"A-2", "2", "3"},
+		{"A-3", "", "7"},
+	}
+
+	query := func(t *testing.T, format string) []byte {
+		t.Helper()
+		res := postForm(t, ts.URL+"/api/query", map[string]string{
+			"sql_file":    testTemplate,
+			"values_file": testValues,
+			"config":      `{"output-format": "` + format + `", "sql-table": "dbo.prices", "headers": "lower-camel"}`,
+		})
+		b, _ := io.ReadAll(res.Body)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("got %s: %s", res.Status, b)
+		}
+		return b
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		rows, err := csv.NewReader(bytes.NewReader(query(t, "csv"))).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rows, want) {
+			t.Errorf("rows = %v, want %v", rows, want)
+		}
+	})
+
+	t.Run("xlsx", func(t *testing.T) {
+		f, err := excelize.OpenReader(bytes.NewReader(query(t, "xlsx")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		rows, err := f.GetRows(f.GetSheetName(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rows, want) {
+			t.Errorf("rows = %v, want %v", rows, want)
+		}
+	})
+
+	t.Run("sql", func(t *testing.T) {
+		got := string(query(t, "sql"))
+		for _, line := range []string{
+			"INSERT INTO [dbo].[prices] ([artNr], [price], [qty])",
+			"(N'A-1', 1.5, 5)",
+			"(N'A-3', NULL, 7)",
+		} {
+			if !strings.Contains(got, line) {
+				t.Errorf("output lacks %q:\n%s", line, got)
+			}
+		}
+	})
+}
+
+func TestQueryErrors(t *testing.T) {
+	ts := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		files  map[string]string
+		status int
+	}{
+		{"missing template", map[string]string{"values_file": testValues}, http.StatusBadRequest},
+		{"broken template", map[string]string{"sql_file": "SELECT {{ .Nope"}, http.StatusBadRequest},
+		{"failing query", map[string]string{"sql_file": "SELECT nosuch FROM articles"}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := postForm(t, ts.URL+"/api/query", tt.files)
+			if res.StatusCode != tt.status {
+				t.Errorf("got %s, want %d", res.Status, tt.status)
+			}
+		})
+	}
+}
+
+func TestQueryUploadDisabled(t *testing.T) {
+	ts := newTestServer(t, WithSQLUpload(false))
+
+	res := postForm(t, ts.URL+"/api/query", map[string]string{"sql_file": "SELECT 1"})
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("got %s, want %d", res.Status, http.StatusForbidden)
+	}
+}
+
+func TestPreviewRowLimit(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, tt := range []struct {
+		limit     string
+		rows      int
+		truncated bool
+	}{
+		{"1", 1, true},
+		{"3", 3, false},
+	} {
+		res := postForm(t, ts.URL+"/api/preview?limit="+tt.limit, map[string]string{
+			"sql_file":    testTemplate,
+			"values_file": testValues,
+			"config":      `{"headers": "lower-camel"}`,
+		})
+		var got PreviewResponse
+		if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Error != "" || len(got.ResultSets) != 1 {
+			t.Fatalf("limit %s: got %+v", tt.limit, got)
+		}
+		set := got.ResultSets[0]
+		if len(set.Rows) != tt.rows || set.TotalRows != tt.rows || set.Truncated != tt.truncated {
+			t.Errorf("limit %s: got %d of %d rows, truncated %t, want %d, truncated %t", tt.limit, len(set.Rows), set.TotalRows, set.Truncated, tt.rows, tt.truncated)
+		}
+	}
+}
+
+func TestPreviewDryRun(t *testing.T) {
+	ts := newTestServer(t)
+
+	res := postForm(t, ts.URL+"/api/preview", map[string]string{
+		"sql_file": "UPDATE articles SET price = 0 RETURNING artNr, price",
+	})
+	var got PreviewResponse
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Error != "" || len(got.ResultSets) != 1 || len(got.ResultSets[0].Rows) != 2 || len(got.Messages) == 0 {
+		t.Fatalf("got %+v, want the updated rows and a note about the rollback", got)
+	}
+
+	res = postForm(t, ts.URL+"/api/preview", map[string]string{
+		"sql_file": "SELECT COUNT(*) AS n FROM articles WHERE price = 0",
+	})
+	got = PreviewResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Error != "" || len(got.ResultSets) != 1 || got.ResultSets[0].Rows[0][0] != "0" {
+		t.Errorf("got %+v, want the prices unchanged after the preview", got)
+	}
+}
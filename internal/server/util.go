@@ -2,6 +2,8 @@ package server
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/Phillezi/common/utils/or"
 	"go.uber.org/zap"
@@ -42,3 +44,89 @@ func getT[T any](m map[string]interface{}, key string, logger ...*zap.Logger) T
 	}
 	return zero
 }
+
+// getStringSlice accepts both a list of strings and a single comma separated string.
+func getStringSlice(m map[string]interface{}, key string, logger ...*zap.Logger) []string {
+	l := or.Or(logger...)
+	if val, exists := m[key]; exists {
+		switch v := val.(type) {
+		case string:
+			if v == "" {
+				return nil
+			}
+			parts := strings.Split(v, ",")
+			for i, p := range parts {
+				parts[i] = strings.TrimSpace(p)
+			}
+			return parts
+		case []any:
+			out := make([]string, 0, len(v))
+			for _, e := range v {
+				if s, ok := e.(string); ok {
+					out = append(out, s)
+				}
+			}
+			return out
+		}
+		if l != nil {
+			l.Warn("Unexpected type for config value",
+				zap.String("key", key),
+				zap.String("expected", "[]string"),
+				zap.String("actual", fmt.Sprintf("%T", val)),
+			)
+		}
+	}
+	return nil
+}
+
+func getStringMap(m map[string]interface{}, key string, logger ...*zap.Logger) map[string]string {
+	l := or.Or(logger...)
+	if val, exists := m[key]; exists {
+		if mm, ok := val.(map[string]any); ok {
+			out := make(map[string]string, len(mm))
+			for k, v := range mm {
+				if s, ok := v.(string); ok {
+					out[k] = s
+				}
+			}
+			return out
+		}
+		if l != nil {
+			l.Warn("Unexpected type for config value",
+				zap.String("key", key),
+				zap.String("expected", "map[string]string"),
+				zap.String("actual", fmt.Sprintf("%T", val)),
+			)
+		}
+	}
+	return nil
+}
+
+// getInt also accepts float64 and numeric strings since that is what
+// numbers decode to from json and html form values.
+func getInt(m map[string]interface{}, key string, logger ...*zap.Logger) int {
+	l := or.Or(logger...)
+	if val, exists := m[key]; exists {
+		switch v := val.(type) {
+		case int:
+			return v
+		case float64:
+			return int(v)
+		case string:
+			if v == "" {
+				return 0
+			}
+			if i, err := strconv.Atoi(v); err == nil {
+				return i
+			}
+		}
+		if l != nil {
+			l.Warn("Unexpected type for config value",
+				zap.String("key", key),
+				zap.String("expected", "int"),
+				zap.String("actual", fmt.Sprintf("%T", val)),
+			)
+		}
+	}
+	return 0
+}
@@ -3,6 +3,8 @@ package generator
 import (
 	"fmt"
 	"io"
+	"path/filepath"
+	"strings"
 
 	"github.com/Phillezi/common/utils/or"
 )
@@ -12,26 +14,74 @@ type Generator interface {
 	GenerateIO(w io.Writer, data []map[string]string) error
 }
 
+// Typed carries what the database knows about the values beyond their text.
+type Typed struct {
+	// Types maps columns to their database type name, e.g. DATE or INT
+	Types map[string]string
+	// Nulls marks the NULL columns of every row, the text of a NULL is empty
+	Nulls []map[string]bool
+}
+
+// TypedGenerator is a Generator that writes values differently by type or
+// nullability, like the SQL script.
+type TypedGenerator interface {
+	Generator
+	SetTyped(t Typed)
+}
+
+// SetTyped hands the types of the data to g when it uses them.
+func SetTyped(g Generator, t Typed) {
+	if tg, ok := g.(TypedGenerator); ok {
+		tg.SetTyped(t)
+	}
+}
+
 type GenerationOptions struct {
+	// Format overrides the format otherwise taken from the file extension,
+	// one of csv, xlsx, sql or table
+	Format string
+
 	SheetName string
+
+	// SQL script output
+	SQLTable string
+	SQLMode  string
+	SQLKeys  string // comma separated key columns for merge
 }
 
 func GetGenerator(path string, generatorOptions ...GenerationOptions) (Generator, error) {
-	if path == "" {
+	opt := or.Or(generatorOptions...)
+	if path == "" && opt.Format == "" {
 		return &CLIGenerator{}, nil
 	}
-	opt := or.Or(generatorOptions...)
 
-	switch {
-	case hasSuffixCI(path, ".csv"):
+	format := strings.ToLower(opt.Format)
+	if format == "" {
+		format = strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	}
+
+	switch format {
+	case "table":
+		return &CLIGenerator{}, nil
+	case "csv":
 		return &CSVGenerator{Filename: path}, nil
-	case hasSuffixCI(path, ".xlsx"):
+	case "xlsx":
 		return &XLSXGenerator{Filename: path, OutSheet: opt.SheetName, Overwrite: true}, nil
+	case "sql":
+		return &SQLGenerator{Filename: path, Table: opt.SQLTable, Mode: opt.SQLMode, Keys: splitList(opt.SQLKeys)}, nil
+	case "":
+		return nil, fmt.Errorf("unknown output format for %q, specify it explicitly", path)
 	default:
-		return nil, fmt.Errorf("unsupported file format: %s", path)
+		return nil, fmt.Errorf("unsupported file format: %s", format)
 	}
 }
 
-func hasSuffixCI(s, suffix string) bool {
-	return len(s) >= len(suffix) && s[len(s)-len(suffix):] == suffix
+func splitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
 }
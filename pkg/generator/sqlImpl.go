@@ -0,0 +1,362 @@
+package generator
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	SQLModeInsert = "insert"
+	SQLModeMerge  = "merge"
+
+	defaultSQLTable     = "#Results"
+	defaultSQLBatchSize = 1000 // max number of row value expressions allowed by T-SQL in a VALUES clause
+)
+
+var (
+	numericLiteral = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?$`)
+	// typedNumber is a number of a numeric database column, floats may be
+	// written with an exponent
+	typedNumber = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+	isoDate           = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}$`)
+	isoTime           = regexp.MustCompile(`^[0-9]{2}:[0-9]{2}(:[0-9]{2}(\.[0-9]{1,7})?)?$`)
+	isoDateTime       = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}[ T][0-9]{2}:[0-9]{2}(:[0-9]{2}(\.[0-9]{1,7})?)?$`)
+	isoDateTimeOffset = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}[ T][0-9]{2}:[0-9]{2}(:[0-9]{2}(\.[0-9]{1,7})?)? ?(Z|[-+][0-9]{2}:[0-9]{2})$`)
+)
+
+// literalKind is how the values of a column are written.
+type literalKind int
+
+const (
+	literalString literalKind = iota
+	// literalNumber is a number found by looking at the values
+	literalNumber
+	// literalTypedNumber is a number of a numeric database column
+	literalTypedNumber
+	literalBit
+	literalDate
+	literalTime
+	literalDateTime
+	literalDateTimeOffset
+)
+
+// castTypes are the types ISO date and time literals are cast to.
+var castTypes = map[literalKind]struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	literalDate:           {"date", isoDate},
+	literalTime:           {"time", isoTime},
+	literalDateTime:       {"datetime2", isoDateTime},
+	literalDateTimeOffset: {"datetimeoffset", isoDateTimeOffset},
+}
+
+// SQLGenerator writes the results as a T-SQL script, either as batched
+// INSERT statements or as a single MERGE matching on the key columns.
+type SQLGenerator struct {
+	Filename string
+	Table    string
+	Mode     string
+	Keys     []string
+	// BatchSize is the number of rows per INSERT or MERGE statement, capped
+	// at 1000.
+	BatchSize int
+	// Typed are the database types and NULLs of the data, without them
+	// columns holding only numbers are written as numbers
+	Typed Typed
+}
+
+func (g *SQLGenerator) SetTyped(t Typed) {
+	g.Typed = t
+}
+
+func (g *SQLGenerator) Generate(data []map[string]string) error {
+	f, err := os.Create(g.Filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return g.GenerateIO(f, data)
+}
+
+func (g *SQLGenerator) GenerateIO(w io.Writer, data []map[string]string) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	// Extract headers from first row and sort for consistency
+	headers := make([]string, 0, len(data[0]))
+	for k := range data[0] {
+		headers = append(headers, k)
+	}
+	sort.Strings(headers)
+
+	kinds := g.columnKinds(headers, data)
+
+	table := g.Table
+	if table == "" {
+		table = defaultSQLTable
+	}
+
+	bw := bufio.NewWriter(w)
+	var err error
+	switch strings.ToLower(g.Mode) {
+	case "", SQLModeInsert:
+		err = g.writeInserts(bw, table, headers, kinds, data)
+	case SQLModeMerge:
+		err = g.writeMerge(bw, table, headers, kinds, data)
+	default:
+		return fmt.Errorf("unsupported sql mode: %s", g.Mode)
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (g *SQLGenerator) batchSize() int {
+	if g.BatchSize <= 0 || g.BatchSize > defaultSQLBatchSize {
+		return defaultSQLBatchSize
+	}
+	return g.BatchSize
+}
+
+// nulls returns the NULL marks of the rows from start to end, nil entries
+// when there are none.
+func (g *SQLGenerator) nulls(start, end int) []map[string]bool {
+	nulls := make([]map[string]bool, end-start)
+	for i := range nulls {
+		if start+i < len(g.Typed.Nulls) {
+			nulls[i] = g.Typed.Nulls[start+i]
+		}
+	}
+	return nulls
+}
+
+func (g *SQLGenerator) writeInserts(w *bufio.Writer, table string, headers []string, kinds map[string]literalKind, data []map[string]string) error {
+	batchSize := g.batchSize()
+	columnList := quoteIdentList(headers)
+	for start := 0; start < len(data); start += batchSize {
+		end := min(start+batchSize, len(data))
+
+		fmt.Fprintf(w, "INSERT INTO %s (%s)\nVALUES\n", quoteTableName(table), columnList)
+		writeValueRows(w, headers, kinds, data[start:end], g.nulls(start, end))
+		fmt.Fprint(w, ";\n\n")
+	}
+	return nil
+}
+
+// writeMerge writes one MERGE per batch of rows, a VALUES source takes at
+// most 1000 rows.
+func (g *SQLGenerator) writeMerge(w *bufio.Writer, table string, headers []string, kinds map[string]literalKind, data []map[string]string) error {
+	if len(g.Keys) == 0 {
+		return errors.New("merge requires at least one key column")
+	}
+
+	isKey := make(map[string]bool, len(g.Keys))
+	for _, k := range g.Keys {
+		if _, ok := data[0][k]; !ok {
+			return fmt.Errorf("merge key column %q is not present in the results", k)
+		}
+		isKey[k] = true
+	}
+
+	on := make([]string, 0, len(g.Keys))
+	for _, k := range g.Keys {
+		on = append(on, fmt.Sprintf("target.%s = source.%s", quoteIdent(k), quoteIdent(k)))
+	}
+
+	var set []string
+	for _, h := range headers {
+		if !isKey[h] {
+			set = append(set, fmt.Sprintf("%s = source.%s", quoteIdent(h), quoteIdent(h)))
+		}
+	}
+
+	sourceCols := make([]string, len(headers))
+	for i, h := range headers {
+		sourceCols[i] = "source." + quoteIdent(h)
+	}
+
+	columnList := quoteIdentList(headers)
+
+	batchSize := g.batchSize()
+	for start := 0; start < len(data); start += batchSize {
+		end := min(start+batchSize, len(data))
+		if start > 0 {
+			fmt.Fprint(w, "\n")
+		}
+
+		fmt.Fprintf(w, "MERGE INTO %s AS target\nUSING (\n    VALUES\n", quoteTableName(table))
+		writeValueRows(w, headers, kinds, data[start:end], g.nulls(start, end))
+		fmt.Fprintf(w, "\n) AS source (%s)\nON %s\n", columnList, strings.Join(on, " AND "))
+		if len(set) > 0 {
+			fmt.Fprintf(w, "WHEN MATCHED THEN\n    UPDATE SET %s\n", strings.Join(set, ", "))
+		}
+		fmt.Fprintf(w, "WHEN NOT MATCHED BY TARGET THEN\n    INSERT (%s)\n    VALUES (%s);\n", columnList, strings.Join(sourceCols, ", "))
+	}
+	return nil
+}
+
+func writeValueRows(w *bufio.Writer, headers []string, kinds map[string]literalKind, rows []map[string]string, nulls []map[string]bool) {
+	for i, row := range rows {
+		values := make([]string, len(headers))
+		for j, h := range headers {
+			values[j] = sqlLiteral(row[h], kinds[h], nulls[i][h])
+		}
+		if i > 0 {
+			fmt.Fprint(w, ",\n")
+		}
+		fmt.Fprintf(w, "    (%s)", strings.Join(values, ", "))
+	}
+}
+
+// columnKinds decides how the values of each column are written, from the
+// database type when it is known and otherwise by looking at the values.
+func (g *SQLGenerator) columnKinds(headers []string, data []map[string]string) map[string]literalKind {
+	kinds := make(map[string]literalKind, len(headers))
+	var untyped []string
+	for _, h := range headers {
+		if kind, ok := kindOfType(g.Typed.Types[h]); ok {
+			kinds[h] = kind
+		} else {
+			untyped = append(untyped, h)
+		}
+	}
+	for h, isNum := range numericColumns(untyped, data) {
+		if isNum {
+			kinds[h] = literalNumber
+		}
+	}
+	return kinds
+}
+
+// kindOfType maps a database type name to the kind of its literals, ok is
+// false for an unknown type.
+func kindOfType(dbType string) (kind literalKind, ok bool) {
+	name, _, _ := strings.Cut(strings.ToUpper(strings.TrimSpace(dbType)), "(")
+	switch strings.TrimSpace(name) {
+	case "":
+		return literalString, false
+	case "INT", "INTEGER", "BIGINT", "SMALLINT", "TINYINT", "DECIMAL", "NUMERIC", "FLOAT", "REAL", "DOUBLE", "MONEY", "SMALLMONEY":
+		return literalTypedNumber, true
+	case "BIT":
+		return literalBit, true
+	case "DATE":
+		return literalDate, true
+	case "TIME":
+		return literalTime, true
+	case "DATETIME", "DATETIME2", "SMALLDATETIME":
+		return literalDateTime, true
+	case "DATETIMEOFFSET":
+		return literalDateTimeOffset, true
+	}
+	return literalString, true
+}
+
+// numericColumns reports for each column whether every non-empty value is a plain
+// decimal number, values with leading zeros such as article numbers stay strings.
+func numericColumns(headers []string, data []map[string]string) map[string]bool {
+	numeric := make(map[string]bool, len(headers))
+	for _, h := range headers {
+		seen := false
+		isNum := true
+		for _, row := range data {
+			v := row[h]
+			if v == "" {
+				continue
+			}
+			seen = true
+			if !numericLiteral.MatchString(v) {
+				isNum = false
+				break
+			}
+		}
+		numeric[h] = seen && isNum
+	}
+	return numeric
+}
+
+// sqlLiteral writes a value of a column of the kind, values that do not fit
+// the kind are written as strings.
+func sqlLiteral(val string, kind literalKind, null bool) string {
+	quoted := "N'" + strings.ReplaceAll(val, "'", "''") + "'"
+	switch {
+	case null:
+		return "NULL"
+	case val == "" && kind != literalString:
+		return "NULL"
+	case kind == literalNumber:
+		return val
+	case kind == literalTypedNumber && typedNumber.MatchString(val):
+		return val
+	case kind == literalBit && (val == "1" || strings.EqualFold(val, "true")):
+		return "1"
+	case kind == literalBit && (val == "0" || strings.EqualFold(val, "false")):
+		return "0"
+	}
+	if cast, ok := castTypes[kind]; ok && cast.pattern.MatchString(val) {
+		return "CAST('" + val + "' AS " + cast.name + ")"
+	}
+	return quoted
+}
+
+func quoteIdent(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+func quoteIdentList(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = quoteIdent(n)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// quoteTableName quotes each part of a possibly schema qualified table name,
+// parts that already are bracket quoted are kept as they are.
+func quoteTableName(name string) string {
+	parts := splitTableName(name)
+	for i, p := range parts {
+		if strings.HasPrefix(p, "[") && strings.HasSuffix(p, "]") {
+			continue
+		}
+		parts[i] = quoteIdent(p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// splitTableName splits a table name on the dots that are not within
+// brackets, ]] inside brackets is an escaped bracket.
+func splitTableName(name string) []string {
+	var parts []string
+	var part strings.Builder
+	quoted := false
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case quoted && c == ']' && i+1 < len(name) && name[i+1] == ']':
+			part.WriteString("]]")
+			i++
+			continue
+		case quoted && c == ']':
+			quoted = false
+		case !quoted && c == '[':
+			quoted = true
+		case !quoted && c == '.':
+			parts = append(parts, part.String())
+			part.Reset()
+			continue
+		}
+		part.WriteByte(c)
+	}
+	return append(parts, part.String())
+}
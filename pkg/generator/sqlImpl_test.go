@@ -0,0 +1,128 @@
+package generator
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSQLLiteral(t *testing.T) {
+	tests := []struct {
+		name string
+		val  string
+		kind literalKind
+		null bool
+		want string
+	}{
+		{"string", "abc", literalString, false, "N'abc'"},
+		{"quote", "O'Brien", literalString, false, "N'O''Brien'"},
+		{"empty string", "", literalString, false, "N''"},
+		{"null string", "", literalString, true, "NULL"},
+		{"number", "12.5", literalNumber, false, "12.5"},
+		{"empty number", "", literalNumber, false, "NULL"},
+		{"typed number", "1.5e+06", literalTypedNumber, false, "1.5e+06"},
+		{"typed number not a number", "NaN", literalTypedNumber, false, "N'NaN'"},
+		{"bit true", "true", literalBit, false, "1"},
+		{"bit false", "false", literalBit, false, "0"},
+		{"date", "2024-03-01", literalDate, false, "CAST('2024-03-01' AS date)"},
+		{"date not iso", "01/03/2024", literalDate, false, "N'01/03/2024'"},
+		{"null date", "", literalDate, true, "NULL"},
+		{"time", "13:45:00.5", literalTime, false, "CAST('13:45:00.5' AS time)"},
+		{"datetime", "2024-03-01 13:45:00", literalDateTime, false, "CAST('2024-03-01 13:45:00' AS datetime2)"},
+		{"datetime quote", "2024-03-01'; DROP TABLE x;--", literalDateTime, false, "N'2024-03-01''; DROP TABLE x;--'"},
+		{"datetimeoffset", "2024-03-01 13:45:00 +02:00", literalDateTimeOffset, false, "CAST('2024-03-01 13:45:00 +02:00' AS datetimeoffset)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sqlLiteral(tt.val, tt.kind, tt.null); got != tt.want {
+				t.Errorf("sqlLiteral(%q) = %s, want %s", tt.val, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKindOfType(t *testing.T) {
+	tests := []struct {
+		dbType string
+		want   literalKind
+		ok     bool
+	}{
+		{"", literalString, false},
+		{"INT", literalTypedNumber, true},
+		{"decimal(10,2)", literalTypedNumber, true},
+		{"NVARCHAR", literalString, true},
+		{"DATE", literalDate, true},
+		{"DATETIME2", literalDateTime, true},
+		{"DATETIMEOFFSET", literalDateTimeOffset, true},
+		{"BIT", literalBit, true},
+	}
+	for _, tt := range tests {
+		got, ok := kindOfType(tt.dbType)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("kindOfType(%q) = %v, %v, want %v, %v", tt.dbType, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestQuoteTableName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"#Results", "[#Results]"},
+		{"dbo.Articles", "[dbo].[Articles]"},
+		{"[dbo].[Articles]", "[dbo].[Articles]"},
+		{"[my.schema].Articles", "[my.schema].[Articles]"},
+		{"dbo.[a.b]]c]", "[dbo].[a.b]]c]"},
+		{"db.dbo.Articles", "[db].[dbo].[Articles]"},
+	}
+	for _, tt := range tests {
+		if got := quoteTableName(tt.name); got != tt.want {
+			t.Errorf("quoteTableName(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSQLGeneratorTyped(t *testing.T) {
+	data := []map[string]string{
+		{"id": "1", "name": "a", "born": "2024-03-01", "code": "007"},
+		{"id": "2", "name": "", "born": "", "code": ""},
+	}
+	g := &SQLGenerator{Table: "dbo.People"}
+	SetTyped(g, Typed{
+		Types: map[string]string{"id": "INT", "name": "NVARCHAR", "born": "DATE", "code": "NVARCHAR"},
+		Nulls: []map[string]bool{nil, {"name": true, "born": true}},
+	})
+
+	var buf bytes.Buffer
+	if err := g.GenerateIO(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	want := "INSERT INTO [dbo].[People] ([born], [code], [id], [name])\nVALUES\n" +
+		"    (CAST('2024-03-01' AS date), N'007', 1, N'a'),\n" +
+		"    (NULL, N'', 2, NULL);\n\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSQLGeneratorMergeBatches(t *testing.T) {
+	data := make([]map[string]string, 2500)
+	for i := range data {
+		data[i] = map[string]string{"id": "1", "v": "x"}
+	}
+	g := &SQLGenerator{Mode: SQLModeMerge, Keys: []string{"id"}}
+
+	var buf bytes.Buffer
+	if err := g.GenerateIO(&buf, data); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buf.String(), "MERGE INTO"); n != 3 {
+		t.Errorf("got %d MERGE statements for 2500 rows, want 3", n)
+	}
+	for _, stmt := range strings.Split(buf.String(), "MERGE INTO")[1:] {
+		if rows := strings.Count(stmt, "    (1, N'x')"); rows > defaultSQLBatchSize {
+			t.Errorf("got %d rows in a MERGE source, want at most %d", rows, defaultSQLBatchSize)
+		}
+	}
+}
@@ -1,12 +1,54 @@
 package loader
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/csv"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	sniffLines        = 20
+	maxReportedErrors = 20
 )
 
+// candidate delimiters in order of preference when the counts are equal
+var delimiterCandidates = []rune{',', ';', '\t', '|'}
+
 type CSVLoader struct {
+	// Delimiter is the field separator, sniffed from the input when unset
+	Delimiter rune
+	// Encoding of the input, auto detects utf-8, utf-16 (with bom) and windows-1252 when unset
+	Encoding string
+	// BOM is either auto (strip a byte order mark and use it to detect the encoding) or ignore
+	BOM string
+	// SkipMalformed drops malformed lines with a warning instead of failing the load
+	SkipMalformed bool
+}
+
+// MalformedCSVError lists the lines of the input that could not be parsed.
+type MalformedCSVError struct {
+	Errors []*csv.ParseError
+	// Total is the number of malformed lines, Errors is capped
+	Total int
+}
+
+func (e *MalformedCSVError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d malformed line(s) in csv input:", e.Total)
+	for _, pe := range e.Errors {
+		fmt.Fprintf(&sb, "\n  line %d: %v", pe.StartLine, pe.Err)
+	}
+	if e.Total > len(e.Errors) {
+		fmt.Fprintf(&sb, "\n  ... and %d more", e.Total-len(e.Errors))
+	}
+	return sb.String()
 }
 
 func (l *CSVLoader) Load(path string) ([]map[string]string, error) {
@@ -16,51 +58,151 @@ func (l *CSVLoader) Load(path string) ([]map[string]string, error) {
 	}
 	defer f.Close()
 
-	reader := csv.NewReader(f)
+	return l.LoadIO(f)
+}
+
+func (l *CSVLoader) LoadIO(r io.Reader) ([]map[string]string, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	text, enc, err := decodeText(raw, l.Encoding, l.BOM)
+	if err != nil {
+		return nil, err
+	}
+
+	delimiter := l.Delimiter
+	if delimiter == 0 {
+		delimiter = sniffDelimiter(text)
+	}
+	zap.L().Debug("Reading csv input", zap.String("encoding", enc), zap.String("delimiter", string(delimiter)))
+
+	reader := csv.NewReader(bytes.NewReader(text))
+	reader.Comma = delimiter
+	// short rows are filled with empty values below, spreadsheets leave out
+	// trailing empty cells
+	reader.FieldsPerRecord = -1
 
 	headers, err := reader.Read()
 	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
 		return nil, err
 	}
+	for i, h := range headers {
+		headers[i] = strings.TrimSpace(h)
+	}
+	if h, dup := duplicateHeader(headers); dup {
+		return nil, fmt.Errorf("duplicate header %q in csv input", h)
+	}
 
-	var rows []map[string]string
+	var (
+		rows      []map[string]string
+		malformed MalformedCSVError
+	)
 	for {
 		record, err := reader.Read()
-		if err != nil {
+		if err == io.EOF {
 			break
 		}
-		row := map[string]string{}
+		var pe *csv.ParseError
+		if err != nil && !errors.As(err, &pe) {
+			return nil, err
+		}
+		if err == nil && len(record) > len(headers) {
+			// the extra values have no column to go to
+			line, column := reader.FieldPos(len(headers))
+			pe = &csv.ParseError{StartLine: line, Line: line, Column: column, Err: csv.ErrFieldCount}
+		}
+		if pe != nil {
+			malformed.Total++
+			if len(malformed.Errors) < maxReportedErrors {
+				malformed.Errors = append(malformed.Errors, pe)
+			}
+			continue
+		}
+
+		row := make(map[string]string, len(headers))
 		for i, h := range headers {
 			if i < len(record) {
 				row[h] = record[i]
+			} else {
+				row[h] = ""
 			}
 		}
 		rows = append(rows, row)
 	}
+
+	if malformed.Total > 0 {
+		if !l.SkipMalformed {
+			return nil, &malformed
+		}
+		zap.L().Warn("Skipped malformed lines in csv input", zap.Error(&malformed))
+	}
 	return rows, nil
 }
 
-func (l *CSVLoader) LoadIO(r io.Reader) ([]map[string]string, error) {
-	reader := csv.NewReader(r)
-
-	headers, err := reader.Read()
-	if err != nil {
-		return nil, err
+// sniffDelimiter picks the candidate that occurs the same, non zero, number of
+// times on each of the first lines. If no candidate is consistent the one
+// occurring most often on the header line is used, falling back to a comma.
+func sniffDelimiter(text []byte) rune {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() && len(lines) < sniffLines {
+		if line := scanner.Text(); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return ','
 	}
 
-	var rows []map[string]string
-	for {
-		record, err := reader.Read()
-		if err != nil {
-			break
+	best, bestCount := rune(0), 0
+	for _, c := range delimiterCandidates {
+		first := countUnquoted(lines[0], c)
+		if first == 0 {
+			continue
 		}
-		row := map[string]string{}
-		for i, h := range headers {
-			if i < len(record) {
-				row[h] = record[i]
+		consistent := true
+		for _, line := range lines[1:] {
+			if countUnquoted(line, c) != first {
+				consistent = false
+				break
 			}
 		}
-		rows = append(rows, row)
+		if consistent && first > bestCount {
+			best, bestCount = c, first
+		}
 	}
-	return rows, nil
+	if best != 0 {
+		return best
+	}
+
+	// quoted fields spanning lines or ragged rows, go by the header
+	for _, c := range delimiterCandidates {
+		if n := countUnquoted(lines[0], c); n > bestCount {
+			best, bestCount = c, n
+		}
+	}
+	if best != 0 {
+		return best
+	}
+	return ','
+}
+
+func countUnquoted(line string, c rune) int {
+	n := 0
+	quoted := false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == c && !quoted:
+			n++
+		}
+	}
+	return n
 }
@@ -0,0 +1,119 @@
+package loader
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSniffDelimiter(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  rune
+	}{
+		{"empty", "", ','},
+		{"single column", "artNr\nA-1\n", ','},
+		{"comma", "artNr,qty\nA-1,5\n", ','},
+		{"semicolon with decimal commas", "artNr;price\nA-1;1,5\nA-2;2,25\n", ';'},
+		{"tab", "artNr\tqty\tprice\nA-1\t5\t1.5\n", '\t'},
+		{"pipe", "artNr|qty\nA-1|5\n", '|'},
+		{"quoted delimiters", "artNr;text\nA-1;\"a, b, c\"\n", ';'},
+		{"blank lines", "\nartNr;qty\n\nA-1;5\n", ';'},
+		{"equal counts prefer comma", "a,b;c\n1,2;3\n", ','},
+		{"ragged rows go by the header", "a;b;c\n1;2\n1;2;3\n", ';'},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffDelimiter([]byte(tt.input)); got != tt.want {
+				t.Errorf("sniffDelimiter(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVLoader(t *testing.T) {
+	tests := []struct {
+		name   string
+		loader CSVLoader
+		input  []byte
+		want   []map[string]string
+	}{
+		{
+			name:  "sniffed semicolon",
+			input: []byte("artNr; qty \nA-1;5\nA-2;\n"),
+			want:  []map[string]string{{"artNr": "A-1", "qty": "5"}, {"artNr": "A-2", "qty": ""}},
+		},
+		{
+			name:   "given delimiter",
+			loader: CSVLoader{Delimiter: ','},
+			input:  []byte("artNr,text\nA-1,a;b\n"),
+			want:   []map[string]string{{"artNr": "A-1", "text": "a;b"}},
+		},
+		{
+			name:  "windows-1252",
+			input: []byte("artNr;text\r\nA-1;f\xf6r b\xe4nk\r\n"),
+			want:  []map[string]string{{"artNr": "A-1", "text": "för bänk"}},
+		},
+		{
+			name:  "utf-8 bom",
+			input: append([]byte{0xEF, 0xBB, 0xBF}, "artNr;text\nA-1;för\n"...),
+			want:  []map[string]string{{"artNr": "A-1", "text": "för"}},
+		},
+		{
+			name:  "short rows",
+			input: []byte("artNr,qty,text\nA-1,5\nA-2\n"),
+			want:  []map[string]string{{"artNr": "A-1", "qty": "5", "text": ""}, {"artNr": "A-2", "qty": "", "text": ""}},
+		},
+		{
+			name:  "headers only",
+			input: []byte("artNr;qty\n"),
+			want:  nil,
+		},
+		{
+			name:  "empty",
+			input: nil,
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := tt.loader.LoadIO(bytes.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rows, tt.want) {
+				t.Errorf("rows = %v, want %v", rows, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVLoaderMalformed(t *testing.T) {
+	input := []byte("artNr,qty\nA-1,5\nA-2,\"6\nA-3,7,8\n")
+
+	_, err := (&CSVLoader{}).LoadIO(bytes.NewReader(input))
+	var malformed *MalformedCSVError
+	if !errors.As(err, &malformed) {
+		t.Fatalf("got %v, want a MalformedCSVError", err)
+	}
+
+	rows, err := (&CSVLoader{SkipMalformed: true}).LoadIO(bytes.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["artNr"] != "A-1" {
+		t.Errorf("rows = %v, want only A-1", rows)
+	}
+
+	_, err = (&CSVLoader{}).LoadIO(bytes.NewReader([]byte("artNr,qty\nA-1,5\nA-2,6,7\n")))
+	if !errors.As(err, &malformed) || malformed.Total != 1 || malformed.Errors[0].StartLine != 3 || !errors.Is(malformed.Errors[0].Err, csv.ErrFieldCount) {
+		t.Errorf("got %v, want a field count error on line 3", err)
+	}
+
+	if _, err := (&CSVLoader{}).LoadIO(bytes.NewReader([]byte("a,a\n1,2\n"))); err == nil {
+		t.Error("got no error for a duplicate header")
+	}
+}
@@ -0,0 +1,86 @@
+package loader
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const (
+	EncodingAuto = "auto"
+
+	BOMAuto   = "auto"
+	BOMIgnore = "ignore"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// decodeText converts raw input to utf-8.
+//
+// With bomMode auto a leading byte order mark is stripped and, unless an
+// encoding is given, decides the encoding. With encodingName auto (or empty)
+// input that is not valid utf-8 is assumed to be windows-1252, which is what
+// Excel uses when saving csv files on swedish (and most western) Windows installs.
+func decodeText(raw []byte, encodingName, bomMode string) (text []byte, detected string, err error) {
+	var bomEncoding string
+	switch strings.ToLower(bomMode) {
+	case "", BOMAuto:
+		switch {
+		case bytes.HasPrefix(raw, bomUTF8):
+			raw, bomEncoding = raw[len(bomUTF8):], "utf-8"
+		case bytes.HasPrefix(raw, bomUTF16LE):
+			raw, bomEncoding = raw[len(bomUTF16LE):], "utf-16le"
+		case bytes.HasPrefix(raw, bomUTF16BE):
+			raw, bomEncoding = raw[len(bomUTF16BE):], "utf-16be"
+		}
+	case BOMIgnore:
+	default:
+		return nil, "", fmt.Errorf("unsupported bom mode: %s", bomMode)
+	}
+
+	detected = strings.ToLower(encodingName)
+	if detected == "" || detected == EncodingAuto {
+		switch {
+		case strings.HasPrefix(bomEncoding, "utf-16"):
+			detected = bomEncoding
+		case utf8.Valid(raw):
+			detected = "utf-8"
+		default:
+			// A utf-8 bom in front of windows-1252 content is not unusual
+			// when files have passed through several tools, so the content wins.
+			detected = "windows-1252"
+		}
+	}
+
+	var enc encoding.Encoding
+	switch detected {
+	case "utf-8", "utf8":
+		return raw, "utf-8", nil
+	case "utf-16le":
+		enc = unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	case "utf-16be":
+		enc = unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	case "windows-1252", "cp1252":
+		enc = charmap.Windows1252
+	default:
+		if enc, err = htmlindex.Get(detected); err != nil {
+			return nil, "", fmt.Errorf("unsupported encoding: %s", encodingName)
+		}
+	}
+
+	text, err = enc.NewDecoder().Bytes(raw)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode input as %s: %w", detected, err)
+	}
+	return text, detected, nil
+}
@@ -0,0 +1,184 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FixedWidthColumn describes where a column is located on a line.
+// Start is 1 based and counted in characters, the column ends either
+// after Width characters or at End (inclusive), whichever is set.
+// A column without Width or End runs to the end of the line.
+type FixedWidthColumn struct {
+	Name  string `yaml:"name" json:"name"`
+	Start int    `yaml:"start" json:"start"`
+	Width int    `yaml:"width,omitempty" json:"width,omitempty"`
+	End   int    `yaml:"end,omitempty" json:"end,omitempty"`
+}
+
+// FixedWidthSpec is the column spec file used for fixed-width input,
+// for example:
+//
+//	skip: 3          # lines to skip before the data, e.g. SAP list titles
+//	columns:
+//	  - name: artNr
+//	    start: 1
+//	    width: 18
+//	  - name: qty
+//	    start: 20
+//	    end: 29
+type FixedWidthSpec struct {
+	Skip    int                `yaml:"skip" json:"skip"`
+	Columns []FixedWidthColumn `yaml:"columns" json:"columns"`
+	// KeepSpaces disables trimming of surrounding whitespace in values.
+	KeepSpaces bool `yaml:"keep-spaces" json:"keep-spaces"`
+	// SkipPrefixes are line prefixes to ignore, e.g. "---" separator lines.
+	SkipPrefixes []string `yaml:"skip-prefixes" json:"skip-prefixes"`
+}
+
+var errNoColumnSpec = errors.New("fixed-width input requires a column spec")
+
+// LoadFixedWidthSpec reads a column spec from a yaml (or json) file.
+func LoadFixedWidthSpec(path string) (*FixedWidthSpec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseFixedWidthSpec(f)
+}
+
+// ParseFixedWidthSpec reads a column spec in yaml (or json) format.
+func ParseFixedWidthSpec(r io.Reader) (*FixedWidthSpec, error) {
+	var spec FixedWidthSpec
+	if err := yaml.NewDecoder(r).Decode(&spec); err != nil {
+		return nil, fmt.Errorf("failed to parse column spec: %w", err)
+	}
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+func (s *FixedWidthSpec) validate() error {
+	if len(s.Columns) == 0 {
+		return errors.New("column spec has no columns")
+	}
+	for i, c := range s.Columns {
+		switch {
+		case c.Name == "":
+			return fmt.Errorf("column spec: column %d has no name", i+1)
+		case c.Start < 1:
+			return fmt.Errorf("column spec: column %q must have a start of at least 1", c.Name)
+		case c.End != 0 && c.End < c.Start:
+			return fmt.Errorf("column spec: column %q ends before it starts", c.Name)
+		case c.Width < 0:
+			return fmt.Errorf("column spec: column %q has a negative width", c.Name)
+		}
+	}
+	return nil
+}
+
+type FixedWidthLoader struct {
+	Spec *FixedWidthSpec
+	// Encoding of the input, auto detects utf-8, utf-16 (with bom) and windows-1252 when unset
+	Encoding string
+	// BOM is either auto (strip a byte order mark and use it to detect the encoding) or ignore
+	BOM string
+}
+
+func (l *FixedWidthLoader) Load(path string) ([]map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return l.LoadIO(f)
+}
+
+func (l *FixedWidthLoader) LoadIO(r io.Reader) ([]map[string]string, error) {
+	if l.Spec == nil {
+		return nil, errNoColumnSpec
+	}
+	if err := l.Spec.validate(); err != nil {
+		return nil, err
+	}
+
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// SAP and mainframe exports are usually windows-1252, decode them the
+	// way csv input is decoded
+	decoded, _, err := decodeText(raw, l.Encoding, l.BOM)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(decoded))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+
+	var rows []map[string]string
+	line := 0
+	for scanner.Scan() {
+		line++
+		if line <= l.Spec.Skip {
+			continue
+		}
+
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" || l.skipLine(text) {
+			continue
+		}
+
+		runes := []rune(text)
+		row := make(map[string]string, len(l.Spec.Columns))
+		for _, c := range l.Spec.Columns {
+			val := c.slice(runes)
+			if !l.Spec.KeepSpaces {
+				val = strings.TrimSpace(val)
+			}
+			row[c.Name] = val
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (l *FixedWidthLoader) skipLine(text string) bool {
+	trimmed := strings.TrimSpace(text)
+	for _, p := range l.Spec.SkipPrefixes {
+		if p != "" && strings.HasPrefix(trimmed, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c FixedWidthColumn) slice(line []rune) string {
+	start := c.Start - 1
+	if start >= len(line) {
+		return ""
+	}
+
+	end := len(line)
+	switch {
+	case c.Width > 0:
+		end = min(start+c.Width, len(line))
+	case c.End > 0:
+		end = min(c.End, len(line))
+	}
+	return string(line[start:end])
+}
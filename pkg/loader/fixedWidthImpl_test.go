@@ -0,0 +1,41 @@
+package loader
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFixedWidthLoaderEncoding(t *testing.T) {
+	spec := &FixedWidthSpec{Columns: []FixedWidthColumn{
+		{Name: "artNr", Start: 1, Width: 6},
+		{Name: "text", Start: 8},
+	}}
+	tests := []struct {
+		name     string
+		input    []byte
+		encoding string
+	}{
+		{"utf-8", []byte("A-0001 Kugellager Ø20 för bänk\n"), ""},
+		{"windows-1252 detected", []byte("A-0001 Kugellager \xd820 f\xf6r b\xe4nk\r\n"), ""},
+		{"windows-1252 given", []byte("A-0001 Kugellager \xd820 f\xf6r b\xe4nk\n"), "windows-1252"},
+		{"utf-8 bom", append([]byte{0xEF, 0xBB, 0xBF}, "A-0001 Kugellager Ø20 för bänk\n"...), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &FixedWidthLoader{Spec: spec, Encoding: tt.encoding}
+			rows, err := l.LoadIO(bytes.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != 1 {
+				t.Fatalf("got %d rows, want 1", len(rows))
+			}
+			if got, want := rows[0]["artNr"], "A-0001"; got != want {
+				t.Errorf("artNr = %q, want %q", got, want)
+			}
+			if got, want := rows[0]["text"], "Kugellager Ø20 för bänk"; got != want {
+				t.Errorf("text = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package loader
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	HeadersAsIs       = "as-is"
+	HeadersLowerCamel = "lower-camel"
+	HeadersSnake      = "snake"
+	HeadersUpper      = "upper"
+)
+
+// HeaderNormalizer maps the headers of the input data to the keys used in templates.
+// Aliases are matched case insensitively against the trimmed original header and
+// their values are used verbatim, all other headers are converted according to Mode.
+// Aliases that only differ in case must map to the same key.
+type HeaderNormalizer struct {
+	Mode    string
+	Aliases map[string]string
+}
+
+// HeaderCollisionError is returned when several headers end up with the same key.
+type HeaderCollisionError struct {
+	Key     string
+	Headers []string
+}
+
+func (e *HeaderCollisionError) Error() string {
+	quoted := make([]string, len(e.Headers))
+	for i, h := range e.Headers {
+		quoted[i] = fmt.Sprintf("%q", h)
+	}
+	return fmt.Sprintf("headers %s all map to the key %q, add an alias for one of them or change the header mode", strings.Join(quoted, ", "), e.Key)
+}
+
+// ParseHeaderMode validates a header mode, accepting a few common spellings.
+// An empty mode stays empty, the loader then picks the default of the input
+// format, see LoadOpts.Headers.
+func ParseHeaderMode(mode string) (string, error) {
+	switch strings.ToLower(strings.ReplaceAll(mode, "_", "-")) {
+	case "":
+		return "", nil
+	case "lower-camel", "lowercamel", "camel":
+		return HeadersLowerCamel, nil
+	case "as-is", "asis", "none":
+		return HeadersAsIs, nil
+	case "snake":
+		return HeadersSnake, nil
+	case "upper", "upper-snake":
+		return HeadersUpper, nil
+	default:
+		return "", fmt.Errorf("unsupported header mode: %s", mode)
+	}
+}
+
+// Key returns the key a single header is mapped to, empty headers map to an empty key.
+func (n *HeaderNormalizer) Key(header string) string {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return ""
+	}
+	// sorted so the same alias wins on every run
+	for _, from := range slices.Sorted(maps.Keys(n.Aliases)) {
+		if strings.EqualFold(strings.TrimSpace(from), header) {
+			return n.Aliases[from]
+		}
+	}
+
+	switch n.Mode {
+	case HeadersAsIs:
+		return header
+	case HeadersSnake:
+		return joinWords(splitWords(header), "_", strings.ToLower, strings.ToLower)
+	case HeadersUpper:
+		return joinWords(splitWords(header), "_", strings.ToUpper, strings.ToUpper)
+	default:
+		return joinWords(splitWords(header), "", strings.ToLower, capitalize)
+	}
+}
+
+// checkAliases fails when aliases that match the same headers map to
+// different keys, which one is used would be arbitrary.
+func (n *HeaderNormalizer) checkAliases() error {
+	byHeader := map[string][]string{}
+	for _, from := range slices.Sorted(maps.Keys(n.Aliases)) {
+		folded := strings.ToLower(strings.TrimSpace(from))
+		byHeader[folded] = append(byHeader[folded], from)
+	}
+	for _, folded := range slices.Sorted(maps.Keys(byHeader)) {
+		froms := byHeader[folded]
+		for _, from := range froms[1:] {
+			if n.Aliases[from] != n.Aliases[froms[0]] {
+				return fmt.Errorf("header aliases %q and %q match the same headers but map to %q and %q, keep one of them", froms[0], from, n.Aliases[froms[0]], n.Aliases[from])
+			}
+		}
+	}
+	return nil
+}
+
+// Keys maps every header to its key and fails if two different headers share a key.
+func (n *HeaderNormalizer) Keys(headers []string) (map[string]string, error) {
+	if err := n.checkAliases(); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]string, len(headers))
+	sources := map[string][]string{}
+	for _, h := range headers {
+		if _, done := keys[h]; done {
+			continue
+		}
+		k := n.Key(h)
+		keys[h] = k
+		if k != "" {
+			sources[k] = append(sources[k], h)
+		}
+	}
+
+	var collisions []string
+	for k, hs := range sources {
+		if len(hs) > 1 {
+			collisions = append(collisions, k)
+		}
+	}
+	if len(collisions) > 0 {
+		sort.Strings(collisions)
+		hs := sources[collisions[0]]
+		sort.Strings(hs)
+		return nil, &HeaderCollisionError{Key: collisions[0], Headers: hs}
+	}
+	return keys, nil
+}
+
+// Apply renames the keys of all rows, columns with an empty header are dropped.
+func (n *HeaderNormalizer) Apply(rows []map[string]string) ([]map[string]string, error) {
+	var headers []string
+	seen := map[string]struct{}{}
+	for _, row := range rows {
+		for h := range row {
+			if _, ok := seen[h]; !ok {
+				seen[h] = struct{}{}
+				headers = append(headers, h)
+			}
+		}
+	}
+
+	keys, err := n.Keys(headers)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]map[string]string, len(rows))
+	for i, row := range rows {
+		renamed := make(map[string]string, len(row))
+		for h, v := range row {
+			if k := keys[h]; k != "" {
+				renamed[k] = v
+			}
+		}
+		out[i] = renamed
+	}
+	return out, nil
+}
+
+// normalizingLoader applies header normalization on top of any loader.
+type normalizingLoader struct {
+	Loader
+	headers *HeaderNormalizer
+}
+
+func (l *normalizingLoader) Load(path string) ([]map[string]string, error) {
+	rows, err := l.Loader.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return l.headers.Apply(rows)
+}
+
+func (l *normalizingLoader) LoadIO(r io.Reader) ([]map[string]string, error) {
+	rows, err := l.Loader.LoadIO(r)
+	if err != nil {
+		return nil, err
+	}
+	return l.headers.Apply(rows)
+}
+
+// duplicateHeader returns the first non empty header that occurs more than once.
+func duplicateHeader(headers []string) (string, bool) {
+	seen := make(map[string]struct{}, len(headers))
+	for _, h := range headers {
+		if h == "" {
+			continue
+		}
+		if _, ok := seen[h]; ok {
+			return h, true
+		}
+		seen[h] = struct{}{}
+	}
+	return "", false
+}
+
+// splitWords splits a header on everything that is not a letter or digit and on
+// case changes, "Art. nr" gives [Art nr] and "HTTPServer" gives [HTTP Server].
+func splitWords(s string) []string {
+	var words []string
+	var current []rune
+	flush := func() {
+		if len(current) > 0 {
+			words = append(words, string(current))
+			current = current[:0]
+		}
+	}
+
+	runes := []rune(s)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		if len(current) > 0 && unicode.IsUpper(r) {
+			prev := current[len(current)-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				flush()
+			}
+		}
+		current = append(current, r)
+	}
+	flush()
+	return words
+}
+
+func joinWords(words []string, sep string, first, rest func(string) string) string {
+	for i, w := range words {
+		if i == 0 {
+			words[i] = first(w)
+		} else {
+			words[i] = rest(w)
+		}
+	}
+	return strings.Join(words, sep)
+}
+
+func capitalize(s string) string {
+	runes := []rune(strings.ToLower(s))
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
@@ -0,0 +1,166 @@
+package loader
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestHeaderNormalizerKey(t *testing.T) {
+	aliases := map[string]string{"Art. nr": "artNr", "Antal": "qty"}
+	tests := []struct {
+		mode   string
+		header string
+		want   string
+	}{
+		{HeadersLowerCamel, "Article Number", "articleNumber"},
+		{HeadersLowerCamel, "  Art No.  ", "artNo"},
+		{HeadersLowerCamel, "HTTPServer", "httpServer"},
+		{HeadersLowerCamel, "qty_2", "qty2"},
+		{HeadersLowerCamel, "Längd (mm)", "längdMm"},
+		{HeadersLowerCamel, "", ""},
+		{HeadersAsIs, " Article Number ", "Article Number"},
+		{HeadersSnake, "Article Number", "article_number"},
+		{HeadersSnake, "articleNumber", "article_number"},
+		{HeadersUpper, "Article Number", "ARTICLE_NUMBER"},
+		{HeadersLowerCamel, "art. NR", "artNr"},
+		{HeadersSnake, "ANTAL", "qty"},
+		{HeadersAsIs, "Antal", "qty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+"/"+tt.header, func(t *testing.T) {
+			n := &HeaderNormalizer{Mode: tt.mode, Aliases: aliases}
+			if got := n.Key(tt.header); got != tt.want {
+				t.Errorf("Key(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHeaderMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"camel", HeadersLowerCamel, false},
+		{"lower_camel", HeadersLowerCamel, false},
+		{"AS-IS", HeadersAsIs, false},
+		{"none", HeadersAsIs, false},
+		{"snake", HeadersSnake, false},
+		{"upper-snake", HeadersUpper, false},
+		{"kebab", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseHeaderMode(tt.mode)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseHeaderMode(%q) = %q, %v, want %q, error %v", tt.mode, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestHeaderNormalizerKeys(t *testing.T) {
+	tests := []struct {
+		name      string
+		aliases   map[string]string
+		headers   []string
+		want      map[string]string
+		collision bool
+		wantErr   bool
+	}{
+		{
+			name:    "distinct",
+			headers: []string{"Art nr", "Antal"},
+			want:    map[string]string{"Art nr": "artNr", "Antal": "antal"},
+		},
+		{
+			name:      "collision",
+			headers:   []string{"Art nr", "art_nr"},
+			collision: true,
+		},
+		{
+			name:    "collision resolved by alias",
+			aliases: map[string]string{"art_nr": "artNr2"},
+			headers: []string{"Art nr", "art_nr"},
+			want:    map[string]string{"Art nr": "artNr", "art_nr": "artNr2"},
+		},
+		{
+			name:    "aliases differing in case agree",
+			aliases: map[string]string{"Antal": "qty", "ANTAL": "qty"},
+			headers: []string{"antal"},
+			want:    map[string]string{"antal": "qty"},
+		},
+		{
+			name:    "ambiguous aliases",
+			aliases: map[string]string{"Antal": "qty", "ANTAL": "count"},
+			headers: []string{"antal"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &HeaderNormalizer{Mode: HeadersLowerCamel, Aliases: tt.aliases}
+			got, err := n.Keys(tt.headers)
+			var collision *HeaderCollisionError
+			switch {
+			case tt.collision:
+				if !errors.As(err, &collision) {
+					t.Fatalf("got %v, want a collision", err)
+				}
+				return
+			case tt.wantErr:
+				if err == nil {
+					t.Fatal("got no error")
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+			for h, want := range tt.want {
+				if got[h] != want {
+					t.Errorf("key of %q = %q, want %q", h, got[h], want)
+				}
+			}
+		})
+	}
+}
+
+func TestDefaultHeaderMode(t *testing.T) {
+	f := excelize.NewFile()
+	defer f.Close()
+	f.SetSheetRow(f.GetSheetName(0), "A1", &[]any{"Art Nr"})
+	f.SetSheetRow(f.GetSheetName(0), "A2", &[]any{"A-1"})
+	workbook, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filename string
+		input    []byte
+		headers  *HeaderNormalizer
+		want     string
+	}{
+		{"in.xlsx", workbook.Bytes(), nil, "artNr"},
+		{"in.csv", []byte("Art Nr\nA-1\n"), nil, "Art Nr"},
+		{"in.csv", []byte("Art Nr\nA-1\n"), &HeaderNormalizer{Aliases: map[string]string{"Antal": "qty"}}, "Art Nr"},
+		{"in.csv", []byte("Art Nr\nA-1\n"), &HeaderNormalizer{Mode: HeadersLowerCamel}, "artNr"},
+		{"in.xlsx", workbook.Bytes(), &HeaderNormalizer{Mode: HeadersAsIs}, "Art Nr"},
+	}
+	for _, tt := range tests {
+		ld, err := GetLoaderIO(tt.filename, nil, LoadOpts{Headers: tt.headers})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := ld.LoadIO(bytes.NewReader(tt.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 1 || rows[0][tt.want] != "A-1" {
+			t.Errorf("%s with %+v: rows = %v, want the key %q", tt.filename, tt.headers, rows, tt.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Phillezi/common/utils/or"
+)
+
+// JSONLoader loads an array of objects, either from the document root or
+// from the array found at Path (dot separated, e.g. "data.items").
+type JSONLoader struct {
+	Path string
+}
+
+func (l *JSONLoader) Load(path string) ([]map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return l.LoadIO(f)
+}
+
+func (l *JSONLoader) LoadIO(r io.Reader) ([]map[string]string, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	node, err := lookupJSONPath(doc, l.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	arr, ok := node.([]any)
+	if !ok {
+		return nil, fmt.Errorf("expected a json array at %q, got %s", or.Or(l.Path, "$"), jsonKind(node))
+	}
+
+	var rows []map[string]string
+	for i, elem := range arr {
+		obj, ok := elem.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("element %d: expected a json object, got %s", i, jsonKind(elem))
+		}
+		row, err := flattenJSONObject(obj)
+		if err != nil {
+			return nil, fmt.Errorf("element %d: %w", i, err)
+		}
+		rows = append(rows, row)
+	}
+	return fillMissing(rows), nil
+}
+
+// NDJSONLoader loads newline delimited json where every line is an object.
+type NDJSONLoader struct{}
+
+func (l *NDJSONLoader) Load(path string) ([]map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return l.LoadIO(f)
+}
+
+func (l *NDJSONLoader) LoadIO(r io.Reader) ([]map[string]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+
+	var rows []map[string]string
+	line := 0
+	for scanner.Scan() {
+		line++
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+
+		var obj map[string]any
+		if err := dec.Decode(&obj); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		row, err := flattenJSONObject(obj)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return fillMissing(rows), nil
+}
+
+func lookupJSONPath(doc any, path string) (any, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return doc, nil
+	}
+
+	node := doc
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := node.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("json path %q: %q is not an object", path, key)
+		}
+		if node, ok = obj[key]; !ok {
+			return nil, fmt.Errorf("json path %q: key %q not found", path, key)
+		}
+	}
+	return node, nil
+}
+
+// flattenJSONObject converts the values of a json object to strings,
+// nested objects and arrays are kept as their json encoding.
+func flattenJSONObject(obj map[string]any) (map[string]string, error) {
+	row := make(map[string]string, len(obj))
+	for k, v := range obj {
+		switch val := v.(type) {
+		case nil:
+			row[k] = ""
+		case string:
+			row[k] = val
+		case json.Number:
+			row[k] = val.String()
+		case bool:
+			row[k] = fmt.Sprintf("%t", val)
+		default:
+			b, err := json.Marshal(val)
+			if err != nil {
+				return nil, err
+			}
+			row[k] = string(b)
+		}
+	}
+	return row, nil
+}
+
+// fillMissing makes sure that every row has all keys that appear in any row,
+// the renderer only checks the first row for the fields used by a template.
+func fillMissing(rows []map[string]string) []map[string]string {
+	keys := map[string]struct{}{}
+	for _, row := range rows {
+		for k := range row {
+			keys[k] = struct{}{}
+		}
+	}
+	for _, row := range rows {
+		for k := range keys {
+			if _, ok := row[k]; !ok {
+				row[k] = ""
+			}
+		}
+	}
+	return rows
+}
+
+func jsonKind(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
@@ -4,9 +4,10 @@ import (
 	"fmt"
 	"io"
 	"path/filepath"
+	"strings"
 
-	"github.com/Phillezi/common/utils/or"
 	"github.com/NiclasZi/gaspecgen/util"
+	"github.com/Phillezi/common/utils/or"
 )
 
 type Loader interface {
@@ -15,39 +16,136 @@ type Loader interface {
 }
 
 type LoadOpts struct {
+	// Format overrides the format otherwise taken from the file extension,
+	// e.g. csv, tsv, xlsx, json, ndjson or fixed-width
+	Format string
+
 	Sheet      string
 	SheetIndex int
-	CamelCase  *bool
+	// HeaderRow, Range, Table, SkipBlankRows, RawValues and FillMerged
+	// only apply to xlsx input, see XLSXLoader
+	HeaderRow     int
+	Range         string
+	Table         string
+	SkipBlankRows bool
+	RawValues     bool
+	FillMerged    bool
+	// Deprecated: use Headers, false is the same as the as-is header mode
+	CamelCase *bool
+
+	// Headers controls how input headers are turned into template keys.
+	// Without a mode xlsx headers are lower-camel, as they always were, and
+	// the headers of the other formats are used as they are
+	Headers *HeaderNormalizer
+
+	// JSONPath is the dot separated path to the array of objects in json input
+	JSONPath string
+	// FixedWidth is the column spec used for fixed-width text input
+	FixedWidth *FixedWidthSpec
+
+	// Delimiter overrides the sniffed csv delimiter
+	Delimiter rune
+	// Encoding overrides the detected encoding of csv and fixed-width
+	// input, e.g. windows-1252
+	Encoding string
+	// BOM controls byte order mark handling for csv and fixed-width input,
+	// auto or ignore
+	BOM string
+	// SkipMalformed drops malformed csv lines instead of failing
+	SkipMalformed bool
 }
 
 func GetLoader(path string, loadingOpts ...LoadOpts) (Loader, error) {
-	switch {
-	case hasSuffixCI(path, ".csv"):
-		return &CSVLoader{}, nil
-	case hasSuffixCI(path, ".xlsx"):
-		opt := or.Or(loadingOpts...)
-		return &XLSXLoader{Sheet: opt.Sheet, SheetIndex: opt.SheetIndex, ToCamelCase: *or.Or(opt.CamelCase, util.PtrOf(true))}, nil
-	default:
-		return nil, fmt.Errorf("unsupported file format: %s", path)
-	}
+	return newLoader(path, or.Or(loadingOpts...))
 }
 
 func GetLoaderIO(filename string, r io.Reader, loadingOpts ...LoadOpts) (Loader, error) {
-	switch ext := filepath.Ext(filename); ext {
-	case ".csv", ".CSV":
-		return &CSVLoader{}, nil
-	case ".xlsx", ".XLSX":
-		opt := or.Or(loadingOpts...)
+	return newLoader(filename, or.Or(loadingOpts...))
+}
+
+func newLoader(filename string, opt LoadOpts) (Loader, error) {
+	format := opt.Format
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(filename), ".")
+	}
+	ld, err := newFormatLoader(format, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := &HeaderNormalizer{}
+	if opt.Headers != nil {
+		*headers = *opt.Headers
+	}
+	if headers.Mode == "" {
+		headers.Mode = HeadersAsIs
+		if _, ok := ld.(*XLSXLoader); ok && *or.Or(opt.CamelCase, util.PtrOf(true)) {
+			headers.Mode = HeadersLowerCamel
+		}
+	}
+	return &normalizingLoader{Loader: ld, headers: headers}, nil
+}
+
+func newFormatLoader(format string, opt LoadOpts) (Loader, error) {
+	switch strings.ToLower(format) {
+	case "csv":
+		return &CSVLoader{
+			Delimiter:     opt.Delimiter,
+			Encoding:      opt.Encoding,
+			BOM:           opt.BOM,
+			SkipMalformed: opt.SkipMalformed,
+		}, nil
+	case "tsv", "tab":
+		return &CSVLoader{
+			Delimiter:     or.Or(opt.Delimiter, '\t'),
+			Encoding:      opt.Encoding,
+			BOM:           opt.BOM,
+			SkipMalformed: opt.SkipMalformed,
+		}, nil
+	case "xlsx":
 		return &XLSXLoader{
-			Sheet:       opt.Sheet,
-			SheetIndex:  opt.SheetIndex,
-			ToCamelCase: *or.Or(opt.CamelCase, util.PtrOf(true)),
+			Sheet:         opt.Sheet,
+			SheetIndex:    opt.SheetIndex,
+			HeaderRow:     opt.HeaderRow,
+			Range:         opt.Range,
+			Table:         opt.Table,
+			SkipBlankRows: opt.SkipBlankRows,
+			RawValues:     opt.RawValues,
+			FillMerged:    opt.FillMerged,
 		}, nil
+	case "json":
+		return &JSONLoader{Path: opt.JSONPath}, nil
+	case "ndjson", "jsonl":
+		return &NDJSONLoader{}, nil
+	case "fixed-width", "txt", "fwf", "prn":
+		if opt.FixedWidth == nil {
+			return nil, errNoColumnSpec
+		}
+		return &FixedWidthLoader{Spec: opt.FixedWidth, Encoding: opt.Encoding, BOM: opt.BOM}, nil
+	case "":
+		return nil, fmt.Errorf("unknown input format, specify it explicitly")
 	default:
-		return nil, fmt.Errorf("unsupported file format: %s", filename)
+		return nil, fmt.Errorf("unsupported file format: %s", format)
 	}
 }
 
-func hasSuffixCI(s, suffix string) bool {
-	return len(s) >= len(suffix) && s[len(s)-len(suffix):] == suffix
+// ParseDelimiter parses a delimiter given as a single character or by name,
+// an empty string means that the delimiter should be sniffed.
+func ParseDelimiter(s string) (rune, error) {
+	switch strings.ToLower(s) {
+	case "", "auto":
+		return 0, nil
+	case "tab", `\t`:
+		return '\t', nil
+	case "comma":
+		return ',', nil
+	case "semicolon":
+		return ';', nil
+	case "pipe":
+		return '|', nil
+	}
+	if r := []rune(s); len(r) == 1 && r[0] != '"' && r[0] != '\r' && r[0] != '\n' {
+		return r[0], nil
+	}
+	return 0, fmt.Errorf("invalid delimiter: %q", s)
 }
@@ -1,17 +1,38 @@
 package loader
 
 import (
+	"fmt"
 	"io"
+	"strconv"
+	"strings"
 
 	"github.com/Phillezi/common/utils/or"
-	"github.com/iancoleman/strcase"
 	"github.com/xuri/excelize/v2"
 )
 
 type XLSXLoader struct {
-	Sheet       string
-	SheetIndex  int
-	ToCamelCase bool
+	Sheet      string
+	SheetIndex int
+
+	// HeaderRow is the 1 based sheet row holding the headers,
+	// defaults to the first row of the range
+	HeaderRow int
+	// Range limits the cells that are read, e.g. A5:H400
+	Range string
+	// Table reads a named Excel table, its sheet and range take
+	// priority over Sheet, SheetIndex and Range
+	Table string
+	// SkipBlankRows drops rows where every cell is empty
+	SkipBlankRows bool
+	// RawValues reads the unformatted cell values instead of the displayed text
+	RawValues bool
+	// FillMerged repeats the value of a merged cell in every cell it covers
+	FillMerged bool
+}
+
+// cellArea is a 1 based inclusive cell range, zero values mean unbounded
+type cellArea struct {
+	col1, row1, col2, row2 int
 }
 
 func (l *XLSXLoader) Load(path string) ([]map[string]string, error) {
@@ -19,13 +40,29 @@ func (l *XLSXLoader) Load(path string) ([]map[string]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
-	sheetName := or.Call(
-		func() string { return l.Sheet },
-		func() string { return f.GetSheetName(l.SheetIndex) },
-	)
+	return l.read(f)
+}
+
+func (l *XLSXLoader) LoadIO(r io.Reader) ([]map[string]string, error) {
+	f, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return l.read(f)
+}
+
+func (l *XLSXLoader) read(f *excelize.File) ([]map[string]string, error) {
+	sheetName, area, err := l.resolveArea(f)
+	if err != nil {
+		return nil, err
+	}
 
-	rows, err := f.GetRows(sheetName)
+	opts := excelize.Options{RawCellValue: l.RawValues}
+	rows, err := f.GetRows(sheetName, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -33,22 +70,66 @@ func (l *XLSXLoader) Load(path string) ([]map[string]string, error) {
 		return nil, nil
 	}
 
-	headers := rows[0]
-	if l.ToCamelCase {
-		for i, h := range headers {
-			headers[i] = strcase.ToLowerCamel(h)
+	cells := &xlsxCells{f: f, sheet: sheetName, rows: rows, raw: rows, rawValues: l.RawValues, dateStyles: map[int]dateKind{}}
+	if !l.RawValues {
+		// the raw values tell which cells hold numbers, only those can be dates
+		if cells.raw, err = f.GetRows(sheetName, excelize.Options{RawCellValue: true}); err != nil {
+			return nil, err
+		}
+	}
+	if props, err := f.GetWorkbookProps(); err == nil && props.Date1904 != nil {
+		cells.date1904 = *props.Date1904
+	}
+	if l.FillMerged {
+		if err := cells.loadMerged(); err != nil {
+			return nil, err
+		}
+	}
+
+	area.row1 = max(area.row1, 1)
+	area.col1 = max(area.col1, 1)
+	if area.row2 == 0 {
+		area.row2 = len(rows)
+	}
+	if area.col2 == 0 {
+		for _, row := range rows {
+			area.col2 = max(area.col2, len(row))
 		}
 	}
 
+	headerRow := or.Or(l.HeaderRow, area.row1)
+	if headerRow < area.row1 || headerRow > area.row2 {
+		return nil, fmt.Errorf("header row %d is outside of the rows %d-%d being read from sheet %q", headerRow, area.row1, area.row2, sheetName)
+	}
+
+	headers := make([]string, 0, area.col2-area.col1+1)
+	for col := area.col1; col <= area.col2; col++ {
+		h, err := cells.value(col, headerRow)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, strings.TrimSpace(h))
+	}
+	if h, dup := duplicateHeader(headers); dup {
+		return nil, fmt.Errorf("duplicate header %q in sheet %q", h, sheetName)
+	}
+
 	var results []map[string]string
-	for _, row := range rows[1:] {
-		record := map[string]string{}
+	for rowNum := headerRow + 1; rowNum <= area.row2; rowNum++ {
+		record := make(map[string]string, len(headers))
+		blank := true
 		for i, h := range headers {
-			if i < len(row) {
-				record[h] = row[i]
-			} else {
-				record[h] = ""
+			val, err := cells.value(area.col1+i, rowNum)
+			if err != nil {
+				return nil, err
+			}
+			if val != "" {
+				blank = false
 			}
+			record[h] = val
+		}
+		if blank && l.SkipBlankRows {
+			continue
 		}
 		results = append(results, record)
 	}
@@ -56,44 +137,232 @@ func (l *XLSXLoader) Load(path string) ([]map[string]string, error) {
 	return results, nil
 }
 
-func (l *XLSXLoader) LoadIO(r io.Reader) ([]map[string]string, error) {
-	f, err := excelize.OpenReader(r)
-	if err != nil {
-		return nil, err
+func (l *XLSXLoader) resolveArea(f *excelize.File) (string, cellArea, error) {
+	if l.Table != "" {
+		for _, sheet := range f.GetSheetList() {
+			tables, err := f.GetTables(sheet)
+			if err != nil {
+				return "", cellArea{}, err
+			}
+			for _, t := range tables {
+				if strings.EqualFold(t.Name, l.Table) {
+					area, err := parseCellRange(t.Range)
+					return sheet, area, err
+				}
+			}
+		}
+		return "", cellArea{}, fmt.Errorf("no excel table named %q in workbook", l.Table)
 	}
 
 	sheetName := or.Call(
 		func() string { return l.Sheet },
 		func() string { return f.GetSheetName(l.SheetIndex) },
 	)
+	if sheetName == "" {
+		return "", cellArea{}, fmt.Errorf("no sheet with index %d in workbook", l.SheetIndex)
+	}
 
-	rows, err := f.GetRows(sheetName)
+	if l.Range == "" {
+		return sheetName, cellArea{}, nil
+	}
+	area, err := parseCellRange(l.Range)
+	return sheetName, area, err
+}
+
+// parseCellRange parses ranges such as A5:H400, sheet qualified and
+// absolute references (Sheet1!$A$5:$H$400) are accepted as well.
+func parseCellRange(ref string) (cellArea, error) {
+	if i := strings.LastIndex(ref, "!"); i >= 0 {
+		ref = ref[i+1:]
+	}
+	ref = strings.ReplaceAll(ref, "$", "")
+
+	parts := strings.Split(ref, ":")
+	if len(parts) != 2 {
+		return cellArea{}, fmt.Errorf("invalid cell range %q, expected something like A5:H400", ref)
+	}
+	col1, row1, err := excelize.CellNameToCoordinates(parts[0])
 	if err != nil {
-		return nil, err
+		return cellArea{}, fmt.Errorf("invalid cell range %q: %w", ref, err)
 	}
-	if len(rows) == 0 {
-		return nil, nil
+	col2, row2, err := excelize.CellNameToCoordinates(parts[1])
+	if err != nil {
+		return cellArea{}, fmt.Errorf("invalid cell range %q: %w", ref, err)
 	}
+	return cellArea{
+		col1: min(col1, col2), row1: min(row1, row2),
+		col2: max(col1, col2), row2: max(row1, row2),
+	}, nil
+}
 
-	headers := rows[0]
-	if l.ToCamelCase {
-		for i, h := range headers {
-			headers[i] = strcase.ToLowerCamel(h)
+type dateKind int
+
+const (
+	notDate dateKind = iota
+	dateOnly
+	timeOnly
+	dateTime
+)
+
+type xlsxCells struct {
+	f        *excelize.File
+	sheet    string
+	rows     [][]string
+	raw      [][]string
+	date1904 bool
+	// rawValues still turns dates into ISO 8601
+	rawValues bool
+
+	// merged maps every covered cell to the top left cell of its merge
+	merged     map[[2]int][2]int
+	dateStyles map[int]dateKind
+}
+
+func (c *xlsxCells) loadMerged() error {
+	mergeCells, err := c.f.GetMergeCells(c.sheet)
+	if err != nil {
+		return err
+	}
+	c.merged = map[[2]int][2]int{}
+	for _, m := range mergeCells {
+		area, err := parseCellRange(m.GetStartAxis() + ":" + m.GetEndAxis())
+		if err != nil {
+			return err
+		}
+		for row := area.row1; row <= area.row2; row++ {
+			for col := area.col1; col <= area.col2; col++ {
+				c.merged[[2]int{col, row}] = [2]int{area.col1, area.row1}
+			}
 		}
 	}
+	return nil
+}
 
-	var results []map[string]string
-	for _, row := range rows[1:] {
-		record := map[string]string{}
-		for i, h := range headers {
-			if i < len(row) {
-				record[h] = row[i]
-			} else {
-				record[h] = ""
-			}
+// value returns the cell value with date cells converted to ISO 8601.
+func (c *xlsxCells) value(col, row int) (string, error) {
+	if origin, ok := c.merged[[2]int{col, row}]; ok {
+		col, row = origin[0], origin[1]
+	}
+	if row > len(c.rows) || col > len(c.rows[row-1]) {
+		return "", nil
+	}
+	val := c.rows[row-1][col-1]
+	if val == "" {
+		return "", nil
+	}
+
+	// dates are numbers shown differently, text and numbers shown as they
+	// are need no style lookup
+	var raw string
+	if row <= len(c.raw) && col <= len(c.raw[row-1]) {
+		raw = c.raw[row-1][col-1]
+	}
+	if raw == val && !c.rawValues {
+		return val, nil
+	}
+	serial, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		// not a serial date, e.g. text in a date formatted cell
+		return val, nil
+	}
+
+	cell, err := excelize.CoordinatesToCellName(col, row)
+	if err != nil {
+		return "", err
+	}
+	styleID, err := c.f.GetCellStyle(c.sheet, cell)
+	if err != nil || styleID == 0 {
+		return val, nil
+	}
+	kind, err := c.dateKind(styleID)
+	if err != nil || kind == notDate {
+		return val, nil
+	}
+	t, err := excelize.ExcelDateToTime(serial, c.date1904)
+	if err != nil {
+		return val, nil
+	}
+
+	switch kind {
+	case dateOnly:
+		return t.Format("2006-01-02"), nil
+	case timeOnly:
+		return t.Format("15:04:05"), nil
+	default:
+		return t.Format("2006-01-02T15:04:05"), nil
+	}
+}
+
+func (c *xlsxCells) dateKind(styleID int) (dateKind, error) {
+	if kind, ok := c.dateStyles[styleID]; ok {
+		return kind, nil
+	}
+	style, err := c.f.GetStyle(styleID)
+	if err != nil {
+		return notDate, err
+	}
+
+	kind := builtinDateKind(style.NumFmt)
+	if style.CustomNumFmt != nil {
+		kind = customDateKind(*style.CustomNumFmt)
+	}
+	c.dateStyles[styleID] = kind
+	return kind, nil
+}
+
+// builtinDateKind classifies the builtin number formats, see ECMA-376 18.8.30
+func builtinDateKind(numFmt int) dateKind {
+	switch {
+	case numFmt >= 14 && numFmt <= 17, numFmt >= 27 && numFmt <= 31, numFmt >= 34 && numFmt <= 36, numFmt >= 50 && numFmt <= 58:
+		return dateOnly
+	case numFmt >= 18 && numFmt <= 21, numFmt == 32, numFmt == 33, numFmt >= 45 && numFmt <= 47:
+		return timeOnly
+	case numFmt == 22:
+		return dateTime
+	default:
+		return notDate
+	}
+}
+
+// customDateKind looks for date and time tokens outside of quoted
+// text, escaped characters and [bracketed] sections such as colors.
+func customDateKind(format string) dateKind {
+	// only the first section applies to positive numbers
+	if i := strings.Index(format, ";"); i >= 0 {
+		format = format[:i]
+	}
+
+	var hasDate, hasTime bool
+	quoted, bracket, escaped := false, false, false
+	for _, r := range strings.ToLower(format) {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '[':
+			bracket = true
+		case r == ']':
+			bracket = false
+		case bracket:
+		case r == 'y' || r == 'd':
+			hasDate = true
+		case r == 'h' || r == 's':
+			hasTime = true
 		}
-		results = append(results, record)
 	}
 
-	return results, nil
+	switch {
+	case hasDate && hasTime:
+		return dateTime
+	case hasDate:
+		return dateOnly
+	case hasTime:
+		return timeOnly
+	default:
+		return notDate
+	}
 }
@@ -0,0 +1,63 @@
+package loader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NiclasZi/gaspecgen/util"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestXLSXLoaderDates(t *testing.T) {
+	f := excelize.NewFile()
+	defer f.Close()
+	sheet := f.GetSheetName(0)
+
+	dateStyle, err := f.NewStyle(&excelize.Style{NumFmt: 14})
+	if err != nil {
+		t.Fatal(err)
+	}
+	customStyle, err := f.NewStyle(&excelize.Style{CustomNumFmt: util.PtrOf("yyyy-mm-dd hh:mm")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decimalStyle, err := f.NewStyle(&excelize.Style{NumFmt: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.SetSheetRow(sheet, "A1", &[]any{"Text", "Date", "Stamp", "Number", "Decimal"})
+	f.SetSheetRow(sheet, "A2", &[]any{"2024-01-02", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 13, 45, 0, 0, time.UTC), 42, 1.5})
+	f.SetCellStyle(sheet, "B2", "B2", dateStyle)
+	f.SetCellStyle(sheet, "C2", "C2", customStyle)
+	f.SetCellStyle(sheet, "E2", "E2", decimalStyle)
+
+	for _, raw := range []bool{false, true} {
+		l := &XLSXLoader{RawValues: raw}
+		rows, err := l.read(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 1 {
+			t.Fatalf("got %d rows, want 1", len(rows))
+		}
+		want := map[string]string{
+			"Text":   "2024-01-02",
+			"Date":   "2024-03-01",
+			"Stamp":  "2024-03-01T13:45:00",
+			"Number": "42",
+		}
+		for col, v := range want {
+			if got := rows[0][col]; got != v {
+				t.Errorf("raw %v: %s = %q, want %q", raw, col, got, v)
+			}
+		}
+		decimal := "1.50"
+		if raw {
+			decimal = "1.5"
+		}
+		if got := rows[0]["Decimal"]; got != decimal {
+			t.Errorf("raw %v: Decimal = %q, want %q", raw, got, decimal)
+		}
+	}
+}
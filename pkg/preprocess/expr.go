@@ -0,0 +1,500 @@
+package preprocess
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a compiled filter expression.
+//
+// The language supports column references (plain identifiers or `quoted`
+// with backticks for headers containing spaces), "string" and 'string'
+// literals, numbers, true and false, the operators || && ! == != < <= > >=
+// and parentheses, as well as the functions contains, startsWith, endsWith,
+// lower, upper, trim and empty. Comparisons are numeric and exact when both
+// sides are number literals or plain decimal numbers, e.g. qty > 0 or
+// qty > minQty, and string comparisons otherwise, so artNr == 123 does not
+// match 00123 and qty > "10" compares text. A column is false when it is
+// empty, false or 0.
+type Expr struct {
+	src  string
+	eval evalFunc
+	cols []string
+}
+
+type evalFunc func(row map[string]string) (any, error)
+
+// textLiteral is the value of a "string" literal, it always compares as
+// text.
+type textLiteral string
+
+// Compile parses a filter expression.
+func Compile(src string) (*Expr, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	eval, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("filter %q: %w", src, err)
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("filter %q: unexpected %q at position %d", src, t.text, t.pos)
+	}
+	return &Expr{src: src, eval: eval, cols: p.cols}, nil
+}
+
+// Columns returns the columns referenced by the expression.
+func (e *Expr) Columns() []string {
+	return e.cols
+}
+
+// Match evaluates the expression for a row.
+func (e *Expr) Match(row map[string]string) (bool, error) {
+	v, err := e.eval(row)
+	if err != nil {
+		return false, fmt.Errorf("filter %q: %w", e.src, err)
+	}
+	return truthy(v), nil
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+	// quoted is set for `quoted` column names, which are never function calls
+	quoted bool
+}
+
+func tokenize(src string) ([]token, error) {
+	var toks []token
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			toks = append(toks, token{kind: tokLParen, text: "(", pos: i})
+			i++
+		case r == ')':
+			toks = append(toks, token{kind: tokRParen, text: ")", pos: i})
+			i++
+		case r == ',':
+			toks = append(toks, token{kind: tokComma, text: ",", pos: i})
+			i++
+		case r == '"' || r == '\'' || r == '`':
+			start := i
+			var sb strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("filter %q: unterminated quote at position %d", src, start)
+			}
+			i++
+			if r == '`' {
+				toks = append(toks, token{kind: tokIdent, text: sb.String(), pos: start, quoted: true})
+			} else {
+				toks = append(toks, token{kind: tokString, text: sb.String(), pos: start})
+			}
+		case unicode.IsDigit(r) || (r == '.' || r == '-') && i+1 < len(runes) && unicode.IsDigit(runes[i+1]) && (r == '.' || expectsOperand(toks)):
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			toks = append(toks, token{kind: tokNumber, text: string(runes[start:i]), pos: start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			toks = append(toks, token{kind: tokIdent, text: string(runes[start:i]), pos: start})
+		default:
+			start := i
+			op := ""
+			if i+1 < len(runes) {
+				switch two := string(runes[i : i+2]); two {
+				case "&&", "||", "==", "!=", "<=", ">=":
+					op = two
+				}
+			}
+			if op != "" {
+				i += 2
+			} else {
+				switch r {
+				case '<', '>', '!':
+					op = string(r)
+				case '=':
+					op = "=="
+				default:
+					return nil, fmt.Errorf("filter %q: unexpected character %q at position %d", src, r, start)
+				}
+				i++
+			}
+			toks = append(toks, token{kind: tokOp, text: op, pos: start})
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(runes)}), nil
+}
+
+// expectsOperand reports whether a - starts a negative number rather than being an operator.
+func expectsOperand(toks []token) bool {
+	if len(toks) == 0 {
+		return true
+	}
+	switch toks[len(toks)-1].kind {
+	case tokOp, tokLParen, tokComma:
+		return true
+	}
+	return false
+}
+
+type parser struct {
+	toks []token
+	pos  int
+	cols []string
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) parseOr() (evalFunc, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l, r := left, right
+		left = func(row map[string]string) (any, error) {
+			lv, err := l(row)
+			if err != nil || truthy(lv) {
+				return true, err
+			}
+			rv, err := r(row)
+			return truthy(rv), err
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (evalFunc, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "&&" {
+		p.next()
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		l, r := left, right
+		left = func(row map[string]string) (any, error) {
+			lv, err := l(row)
+			if err != nil || !truthy(lv) {
+				return false, err
+			}
+			rv, err := r(row)
+			return truthy(rv), err
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseComparison() (evalFunc, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t.kind != tokOp {
+		return left, nil
+	}
+	switch t.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return left, nil
+	}
+	p.next()
+	right, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	op := t.text
+	return func(row map[string]string) (any, error) {
+		lv, err := left(row)
+		if err != nil {
+			return nil, err
+		}
+		rv, err := right(row)
+		if err != nil {
+			return nil, err
+		}
+		return compare(op, lv, rv), nil
+	}, nil
+}
+
+func (p *parser) parseUnary() (evalFunc, error) {
+	if t := p.peek(); t.kind == tokOp && t.text == "!" {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(row map[string]string) (any, error) {
+			v, err := operand(row)
+			return !truthy(v), err
+		}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (evalFunc, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokRParen {
+			return nil, fmt.Errorf("missing ) for ( at position %d", t.pos)
+		}
+		return inner, nil
+	case tokString:
+		s := textLiteral(t.text)
+		return func(map[string]string) (any, error) { return s, nil }, nil
+	case tokNumber:
+		n, ok := new(big.Rat).SetString(t.text)
+		if !ok {
+			return nil, fmt.Errorf("invalid number %q at position %d", t.text, t.pos)
+		}
+		return func(map[string]string) (any, error) { return n, nil }, nil
+	case tokIdent:
+		if p.peek().kind == tokLParen && !t.quoted {
+			return p.parseCall(t)
+		}
+		switch {
+		case t.quoted:
+		case t.text == "true":
+			return func(map[string]string) (any, error) { return true, nil }, nil
+		case t.text == "false":
+			return func(map[string]string) (any, error) { return false, nil }, nil
+		}
+		col := t.text
+		p.cols = append(p.cols, col)
+		return func(row map[string]string) (any, error) {
+			v, ok := row[col]
+			if !ok {
+				return nil, fmt.Errorf("unknown column %q", col)
+			}
+			return v, nil
+		}, nil
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	default:
+		return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+	}
+}
+
+var funcs = map[string]struct {
+	arity int
+	call  func(args []string) any
+}{
+	"contains":   {2, func(a []string) any { return strings.Contains(a[0], a[1]) }},
+	"startsWith": {2, func(a []string) any { return strings.HasPrefix(a[0], a[1]) }},
+	"endsWith":   {2, func(a []string) any { return strings.HasSuffix(a[0], a[1]) }},
+	"lower":      {1, func(a []string) any { return strings.ToLower(a[0]) }},
+	"upper":      {1, func(a []string) any { return strings.ToUpper(a[0]) }},
+	"trim":       {1, func(a []string) any { return strings.TrimSpace(a[0]) }},
+	"empty":      {1, func(a []string) any { return strings.TrimSpace(a[0]) == "" }},
+}
+
+func (p *parser) parseCall(name token) (evalFunc, error) {
+	fn, ok := funcs[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.text, name.pos)
+	}
+	p.next() // (
+
+	var args []evalFunc
+	for p.peek().kind != tokRParen {
+		if len(args) > 0 {
+			if p.next().kind != tokComma {
+				return nil, fmt.Errorf("expected , between arguments to %s", name.text)
+			}
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next() // )
+
+	if len(args) != fn.arity {
+		return nil, fmt.Errorf("%s takes %d argument(s), got %d", name.text, fn.arity, len(args))
+	}
+	return func(row map[string]string) (any, error) {
+		vals := make([]string, len(args))
+		for i, a := range args {
+			v, err := a(row)
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = toString(v)
+		}
+		return fn.call(vals), nil
+	}, nil
+}
+
+func compare(op string, l, r any) bool {
+	lf, lok := toNumber(l)
+	rf, rok := toNumber(r)
+	if lok && rok {
+		c := lf.Cmp(rf)
+		switch op {
+		case "==":
+			return c == 0
+		case "!=":
+			return c != 0
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		case ">=":
+			return c >= 0
+		}
+	}
+
+	ls, rs := toString(l), toString(r)
+	switch op {
+	case "==":
+		return ls == rs
+	case "!=":
+		return ls != rs
+	case "<":
+		return ls < rs
+	case "<=":
+		return ls <= rs
+	case ">":
+		return ls > rs
+	case ">=":
+		return ls >= rs
+	}
+	return false
+}
+
+var (
+	// plainNumber is a decimal number without leading zeros, exponents or
+	// thousands separators, values like 00123 are codes rather than numbers
+	plainNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?$`)
+	// commaNumber is a number with a (swedish) decimal comma, 1,234 is
+	// ambiguous with a thousands separator and not a number
+	commaNumber = regexp.MustCompile(`^-?(0,[0-9]+|[1-9][0-9]*,([0-9]{1,2}|[0-9]{4,}))$`)
+)
+
+// toNumber accepts both a decimal point and a (swedish) decimal comma in
+// column values, string literals are never numbers.
+func toNumber(v any) (*big.Rat, bool) {
+	switch val := v.(type) {
+	case *big.Rat:
+		return val, true
+	case string:
+		return parseNumber(val)
+	}
+	return nil, false
+}
+
+// parseNumber parses a plain decimal number exactly, 0.1 is 1/10.
+func parseNumber(s string) (*big.Rat, bool) {
+	s = strings.TrimSpace(s)
+	switch {
+	case plainNumber.MatchString(s):
+	case commaNumber.MatchString(s):
+		s = strings.Replace(s, ",", ".", 1)
+	default:
+		return nil, false
+	}
+	return new(big.Rat).SetString(s)
+}
+
+// formatNumber writes the number in decimal without trailing zeros, numbers
+// parsed from decimals are always written exactly.
+func formatNumber(n *big.Rat) string {
+	prec, exact := n.FloatPrec()
+	if !exact {
+		prec = 16
+	}
+	return n.FloatString(prec)
+}
+
+func toString(v any) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case textLiteral:
+		return string(val)
+	case *big.Rat:
+		return formatNumber(val)
+	case bool:
+		return strconv.FormatBool(val)
+	}
+	return ""
+}
+
+func truthy(v any) bool {
+	switch val := v.(type) {
+	case bool:
+		return val
+	case *big.Rat:
+		return val.Sign() != 0
+	case string, textLiteral:
+		switch strings.ToLower(strings.TrimSpace(toString(val))) {
+		case "", "false", "0":
+			return false
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package preprocess
+
+import "testing"
+
+func TestExprMatch(t *testing.T) {
+	row := map[string]string{
+		"artNr":   "00123",
+		"code":    "123",
+		"qty":     "5",
+		"minQty":  "10",
+		"maxQty":  "5.0",
+		"huge":    "9007199254740993",
+		"price":   "1,5",
+		"grouped": "1,234",
+		"big":     "1e5",
+		"nan":     "NaN",
+		"hex":     "0x1p3",
+		"neg":     "-2.5",
+		"active":  "false",
+		"zero":    "0",
+		"flag":    "yes",
+		"empty":   "",
+		"Art nr":  "A-1",
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`qty > 0`, true},
+		{`qty > 10`, false},
+		{`qty == 5.0`, true},
+		{`qty > "10"`, true}, // string literal compares as text
+		{`artNr == 123`, false},
+		{`artNr == "00123"`, true},
+		{`code == 123`, true},
+		{`artNr == code`, false},
+		{`qty < minQty`, true},
+		{`qty == maxQty`, true},
+		{`code > minQty`, true},
+		{`huge > 9007199254740992`, true},
+		{`price == "1,5"`, true},
+		{`price > 1.4`, true},
+		{`price < 2`, true},
+		{`grouped > 1000`, false},
+		{`grouped == "1,234"`, true},
+		{`big == 100000`, false},
+		{`nan != 0`, true},
+		{`nan > 0`, true}, // "NaN" > "0" as text
+		{`hex == 8`, false},
+		{`neg < -2`, true},
+		{`neg >= -2.5`, true},
+		{`active`, false},
+		{`!active`, true},
+		{`zero`, false},
+		{`flag`, true},
+		{`empty`, false},
+		{`empty(empty) && !empty(qty)`, true},
+		{"`Art nr` == 'A-1'", true},
+		{`startsWith(artNr, "00") && contains(lower(flag), "y")`, true},
+		{`qty > 0 || nosuch == 1`, true},
+		{`(qty > 10 || code == 123) && true`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e, err := Compile(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := e.Match(row)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	tests := []struct {
+		expr       string
+		compileErr bool
+	}{
+		{`qty >`, true},
+		{`(qty > 0`, true},
+		{`"open`, true},
+		{`nosuch(qty)`, true},
+		{`contains(qty)`, true},
+		{`qty # 1`, true},
+		{`nosuch == 1`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e, err := Compile(tt.expr)
+			if tt.compileErr {
+				if err == nil {
+					t.Fatal("compiled, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := e.Match(map[string]string{"qty": "1"}); err == nil {
+				t.Error("matched, want an unknown column error")
+			}
+		})
+	}
+}
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"42", "42", true},
+		{" -3.25 ", "-3.25", true},
+		{"0", "0", true},
+		{"0.5", "0.5", true},
+		{"1,5", "1.5", true},
+		{"0,125", "0.125", true},
+		{"12,3456", "12.3456", true},
+		{"9007199254740993", "9007199254740993", true},
+		{"1,234", "", false},
+		{"00123", "", false},
+		{"1e5", "", false},
+		{"NaN", "", false},
+		{"Inf", "", false},
+		{"infinity", "", false},
+		{"0x1p3", "", false},
+		{"1.", "", false},
+		{".5", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		n, ok := parseNumber(tt.in)
+		var got string
+		if ok {
+			got = formatNumber(n)
+		}
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseNumber(%q) = %s, %v, want %s, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package preprocess
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	AggFirst          = "first"
+	AggLast           = "last"
+	AggSum            = "sum"
+	AggMin            = "min"
+	AggMax            = "max"
+	AggCount          = "count"
+	AggConcat         = "concat"
+	AggConcatDistinct = "concat-distinct"
+
+	defaultSeparator = ", "
+)
+
+// Spec declares how the loaded input rows are cleaned up before they are
+// handed to the renderer. The steps run in the order filter, dedupe, group-by.
+//
+//	filter: qty > 0 && artNr != ""
+//	dedupe: [artNr, refDesignator]
+//	group-by: [artNr]
+//	aggregate:
+//	  qty: sum
+//	  refDesignator: concat
+//	separator: ", "
+type Spec struct {
+	// Filter keeps the rows matching the expression, see Expr
+	Filter string `yaml:"filter" json:"filter"`
+	// Dedupe keeps the first row for every combination of the key columns
+	Dedupe []string `yaml:"dedupe" json:"dedupe"`
+	// GroupBy collapses the rows sharing the key columns into one row
+	GroupBy []string `yaml:"group-by" json:"group-by"`
+	// Aggregate maps columns to an aggregation used when grouping, columns
+	// without one keep the value of the first row in the group
+	Aggregate map[string]string `yaml:"aggregate" json:"aggregate"`
+	// Separator is used by concat, defaults to ", "
+	Separator string `yaml:"separator" json:"separator"`
+}
+
+// LoadSpec reads a preprocessing spec from a yaml (or json) file.
+func LoadSpec(path string) (*Spec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var spec Spec
+	if err := yaml.NewDecoder(f).Decode(&spec); err != nil {
+		return nil, fmt.Errorf("failed to parse preprocessing spec: %w", err)
+	}
+	return &spec, nil
+}
+
+// Empty reports whether the spec has no steps.
+func (s *Spec) Empty() bool {
+	return s == nil || (s.Filter == "" && len(s.Dedupe) == 0 && len(s.GroupBy) == 0 && len(s.Aggregate) == 0)
+}
+
+// Apply runs the steps of the spec, a nil or empty spec returns the rows as they are.
+func (s *Spec) Apply(rows []map[string]string) ([]map[string]string, error) {
+	if s.Empty() || len(rows) == 0 {
+		return rows, nil
+	}
+	if err := s.checkColumns(rows[0]); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if s.Filter != "" {
+		if rows, err = filter(rows, s.Filter); err != nil {
+			return nil, err
+		}
+	}
+	if len(s.Dedupe) > 0 {
+		rows = dedupe(rows, s.Dedupe)
+	}
+	if len(s.GroupBy) > 0 || len(s.Aggregate) > 0 {
+		if rows, err = s.group(rows); err != nil {
+			return nil, err
+		}
+	}
+	return rows, nil
+}
+
+func (s *Spec) checkColumns(row map[string]string) error {
+	var unknown []string
+	check := func(col string) {
+		if _, ok := row[col]; !ok && !slices.Contains(unknown, col) {
+			unknown = append(unknown, col)
+		}
+	}
+	for _, c := range s.Dedupe {
+		check(c)
+	}
+	for _, c := range s.GroupBy {
+		check(c)
+	}
+	for c, agg := range s.Aggregate {
+		check(c)
+		if !validAggregation(agg) {
+			return fmt.Errorf("unsupported aggregation %q for column %q", agg, c)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	available := make([]string, 0, len(row))
+	for k := range row {
+		available = append(available, k)
+	}
+	sort.Strings(available)
+	sort.Strings(unknown)
+	return fmt.Errorf("preprocessing refers to unknown column(s) %s, the input has %s",
+		strings.Join(unknown, ", "), strings.Join(available, ", "))
+}
+
+func filter(rows []map[string]string, src string) ([]map[string]string, error) {
+	expr, err := Compile(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []map[string]string
+	for i, row := range rows {
+		ok, err := expr.Match(row)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i+1, err)
+		}
+		if ok {
+			out = append(out, row)
+		}
+	}
+	return out, nil
+}
+
+func dedupe(rows []map[string]string, keys []string) []map[string]string {
+	seen := map[string]struct{}{}
+	var out []map[string]string
+	for _, row := range rows {
+		k := groupKey(row, keys)
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		out = append(out, row)
+	}
+	return out
+}
+
+func (s *Spec) group(rows []map[string]string) ([]map[string]string, error) {
+	sep := s.Separator
+	if sep == "" {
+		sep = defaultSeparator
+	}
+
+	var order []string
+	groups := map[string][]map[string]string{}
+	for _, row := range rows {
+		k := groupKey(row, s.GroupBy)
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], row)
+	}
+
+	out := make([]map[string]string, 0, len(order))
+	for _, k := range order {
+		members := groups[k]
+		merged := make(map[string]string, len(members[0]))
+		for col, val := range members[0] {
+			merged[col] = val
+		}
+		for col, agg := range s.Aggregate {
+			val, err := aggregate(agg, col, members, sep)
+			if err != nil {
+				return nil, err
+			}
+			merged[col] = val
+		}
+		out = append(out, merged)
+	}
+	return out, nil
+}
+
+func validAggregation(agg string) bool {
+	switch agg {
+	case AggFirst, AggLast, AggSum, AggMin, AggMax, AggCount, AggConcat, AggConcatDistinct:
+		return true
+	}
+	return false
+}
+
+func aggregate(agg, col string, rows []map[string]string, sep string) (string, error) {
+	switch agg {
+	case AggFirst:
+		return rows[0][col], nil
+	case AggLast:
+		return rows[len(rows)-1][col], nil
+	case AggCount:
+		return strconv.Itoa(len(rows)), nil
+	case AggConcat, AggConcatDistinct:
+		var parts []string
+		for _, row := range rows {
+			v := row[col]
+			if v == "" || (agg == AggConcatDistinct && slices.Contains(parts, v)) {
+				continue
+			}
+			parts = append(parts, v)
+		}
+		return strings.Join(parts, sep), nil
+	}
+
+	// numeric aggregations in exact decimal arithmetic, the values end up
+	// in SQL, empty values are ignored
+	var result *big.Rat
+	for _, row := range rows {
+		v := row[col]
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		n, ok := parseNumber(v)
+		if !ok {
+			return "", fmt.Errorf("cannot %s column %q, %q is not a number", agg, col, v)
+		}
+		switch {
+		case result == nil:
+			result = n
+		case agg == AggSum:
+			result.Add(result, n)
+		case agg == AggMin && n.Cmp(result) < 0, agg == AggMax && n.Cmp(result) > 0:
+			result = n
+		}
+	}
+	if result == nil {
+		return "", nil
+	}
+	return formatNumber(result), nil
+}
+
+func groupKey(row map[string]string, keys []string) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = row[k]
+	}
+	// the unit separator does not occur in spreadsheet data
+	return strings.Join(parts, "\x1f")
+}
@@ -0,0 +1,36 @@
+package preprocess
+
+import "testing"
+
+func TestAggregate(t *testing.T) {
+	rows := []map[string]string{
+		{"qty": "0.1", "id": "9007199254740993"},
+		{"qty": "0.2", "id": "1"},
+		{"qty": "", "id": "-3"},
+		{"qty": "1,25", "id": "9007199254740992"},
+	}
+	tests := []struct {
+		agg, col, want string
+	}{
+		{AggSum, "qty", "1.55"},
+		{AggSum, "id", "18014398509481983"},
+		{AggMin, "qty", "0.1"},
+		{AggMax, "qty", "1.25"},
+		{AggMin, "id", "-3"},
+		{AggMax, "id", "9007199254740993"},
+		{AggCount, "qty", "4"},
+	}
+	for _, tt := range tests {
+		got, err := aggregate(tt.agg, tt.col, rows, ",")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s(%s) = %s, want %s", tt.agg, tt.col, got, tt.want)
+		}
+	}
+
+	if _, err := aggregate(AggSum, "qty", []map[string]string{{"qty": "many"}}, ","); err == nil {
+		t.Error("summed a value that is not a number")
+	}
+}
@@ -1,10 +1,12 @@
 package renderer
 
 import (
+	"sort"
 	"strings"
 	"text/template"
 	"text/template/parse"
 
+	"github.com/NiclasZi/gaspecgen/util"
 	"github.com/Phillezi/common/utils/or"
 )
 
@@ -110,3 +112,49 @@ func walk(node parse.Node, fields map[string]struct{}, vars map[string]string) {
 func joinIdent(parts []string) string {
 	return strings.Join(parts, ".")
 }
+
+// RowFields returns the sorted input columns the template uses, both as
+// {{ range $r := .Rows }}{{ $r.qty }} and as {{ range .Rows }}{{ .qty }}.
+// The data only has Rows and Vars at the top, so any other single field is
+// read inside a range over the rows.
+func RowFields(tmplText string) ([]string, error) {
+	fields, err := sqlFields(tmplText)
+	if err != nil {
+		return nil, err
+	}
+
+	set := map[string]struct{}{}
+	for _, field := range util.FilterAndTrimPrefix(fields, ".Rows[].") {
+		set[field] = struct{}{}
+	}
+	for _, field := range fields {
+		name, ok := strings.CutPrefix(field, ".")
+		if ok && name != "" && name != "Rows" && name != "Vars" && !strings.ContainsAny(name, ".[") {
+			set[name] = struct{}{}
+		}
+	}
+
+	rowFields := make([]string, 0, len(set))
+	for field := range set {
+		rowFields = append(rowFields, field)
+	}
+	sort.Strings(rowFields)
+	return rowFields, nil
+}
+
+// VarFields returns the sorted variables the template reads as .Vars.<name>.
+func VarFields(tmplText string) ([]string, error) {
+	fields, err := sqlFields(tmplText)
+	if err != nil {
+		return nil, err
+	}
+	vars := util.FilterAndTrimPrefix(fields, ".Vars.")
+	sort.Strings(vars)
+	return vars, nil
+}
+
+// sqlFields extracts the fields with the template functions of the SQL
+// renderer defined.
+func sqlFields(tmplText string) ([]string, error) {
+	return ExtractFields(tmplText, template.New("sql").Funcs(getTemplateFuncs()))
+}
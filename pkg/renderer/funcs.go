@@ -1,6 +1,9 @@
 package renderer
 
-import "text/template"
+import (
+	"strings"
+	"text/template"
+)
 
 func add(x, y int) int {
 	return x + y
@@ -14,6 +17,11 @@ func ne(a, b interface{}) bool {
 	return a != b
 }
 
+// quote returns s as a T-SQL unicode string literal
+func quote(s string) string {
+	return "N'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
 func getTemplateFuncs() template.FuncMap {
 	return template.FuncMap{
 		"add": add,
@@ -38,6 +46,7 @@ func getTemplateFuncs() template.FuncMap {
 				return 0
 			}
 		},
-		"ne": ne,
+		"ne":    ne,
+		"quote": quote,
 	}
 }
@@ -44,7 +44,7 @@ func (r *GoTmplRenderer) Render(templateContent string, data QueryData) (string,
 		}
 	}
 	if missing != "" {
-		return "", fmt.Errorf("%s\n[NOTE]: the input data headers gets camelCased", missing)
+		return "", fmt.Errorf("%s\n[NOTE]: xlsx headers are normalized to lowerCamel by default and the headers of other input are used as they are, use the headers mode or header aliases to change the keys", missing)
 	}
 
 	tmpl, err := templ.Option("missingkey=error").Parse(templateContent)
@@ -4,6 +4,8 @@ type Row map[string]string
 
 type QueryData struct {
 	Rows []Row
+	// Vars are single values passed next to the rows, e.g. {{ .Vars.plant }}
+	Vars map[string]string
 }
 
 func FromMapArr(mapArr []map[string]string) *QueryData {
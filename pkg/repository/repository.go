@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	InputRequired = "required"
+	InputOptional = "optional"
+	InputNone     = "none"
+)
+
+// ErrNotFound is returned for names that are not in the repository.
+var ErrNotFound = errors.New("query not found")
+
+var nameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Query is an approved query template with its metadata. The template is
+// stored as <name>.sql next to an optional <name>.yaml with the metadata:
+//
+//	title: BOM lookup
+//	description: Looks up descriptions and suppliers for a BOM
+//	connection: prod
+//	input: required
+//	output: xlsx
+//	variables:
+//	  - name: plant
+//	    description: Plant code
+//	    default: "1000"
+//	    pattern: ^[0-9]{4}$
+type Query struct {
+	Name        string `yaml:"-" json:"name"`
+	Title       string `yaml:"title" json:"title,omitempty"`
+	Description string `yaml:"description" json:"description,omitempty"`
+	// Connection pins the connection profile the query runs on, the
+	// caller picks one when empty
+	Connection string `yaml:"connection" json:"connection,omitempty"`
+	// Connections are the profiles any caller may pick for a query that is
+	// not pinned, others need the author role
+	Connections []string `yaml:"connections" json:"connections,omitempty"`
+	// Input tells if a values file is required, optional or not accepted
+	Input string `yaml:"input" json:"input"`
+	// Output is the default output format
+	Output    string     `yaml:"output" json:"output,omitempty"`
+	Variables []Variable `yaml:"variables" json:"variables,omitempty"`
+
+	Template string `yaml:"-" json:"-"`
+}
+
+// Variable is a single value passed to the template as .Vars.<name>.
+type Variable struct {
+	Name        string `yaml:"name" json:"name"`
+	Description string `yaml:"description" json:"description,omitempty"`
+	Default     string `yaml:"default" json:"default,omitempty"`
+	Required    bool   `yaml:"required" json:"required,omitempty"`
+	// Pattern is a regular expression the whole value has to match
+	Pattern string `yaml:"pattern" json:"pattern,omitempty"`
+}
+
+// Repository reads approved queries from a directory or an embedded fs.
+type Repository struct {
+	fsys fs.FS
+}
+
+// New returns a repository reading from the root of fsys.
+func New(fsys fs.FS) *Repository {
+	return &Repository{fsys: fsys}
+}
+
+// Open returns a repository reading from the directory.
+func Open(dir string) (*Repository, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open query repository: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("query repository %s is not a directory", dir)
+	}
+	return New(os.DirFS(dir)), nil
+}
+
+// List returns every query in the repository sorted by name.
+func (r *Repository) List() ([]*Query, error) {
+	entries, err := fs.ReadDir(r.fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list query repository: %w", err)
+	}
+
+	queries := []*Query{}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".sql")
+		if e.IsDir() || !ok || !nameRe.MatchString(name) {
+			continue
+		}
+		q, err := r.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+	sort.Slice(queries, func(i, j int) bool { return queries[i].Name < queries[j].Name })
+	return queries, nil
+}
+
+// Get returns the named query with its template.
+func (r *Repository) Get(name string) (*Query, error) {
+	if !nameRe.MatchString(name) {
+		return nil, fmt.Errorf("%w: %q", ErrNotFound, name)
+	}
+
+	tmpl, err := fs.ReadFile(r.fsys, name+".sql")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %q", ErrNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read query %q: %w", name, err)
+	}
+
+	q := &Query{}
+	for _, ext := range []string{".yaml", ".yml"} {
+		meta, err := fs.ReadFile(r.fsys, name+ext)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metadata of query %q: %w", name, err)
+		}
+		if err := yaml.Unmarshal(meta, q); err != nil {
+			return nil, fmt.Errorf("invalid metadata for query %q: %w", name, err)
+		}
+		break
+	}
+	q.Name = name
+	q.Template = string(tmpl)
+
+	switch q.Input {
+	case "":
+		q.Input = InputOptional
+	case InputRequired, InputOptional, InputNone:
+	default:
+		return nil, fmt.Errorf("query %q: invalid input %q, expected required, optional or none", name, q.Input)
+	}
+	for _, v := range q.Variables {
+		if v.Pattern == "" {
+			continue
+		}
+		if _, err := regexp.Compile(v.Pattern); err != nil {
+			return nil, fmt.Errorf("query %q: invalid pattern for variable %q: %w", name, v.Name, err)
+		}
+	}
+	return q, nil
+}
+
+// Vars checks the given values against the declared variables and fills
+// in the defaults. Undeclared variables are rejected so callers can not
+// inject anything the query author did not plan for.
+func (q *Query) Vars(given map[string]string) (map[string]string, error) {
+	declared := make(map[string]Variable, len(q.Variables))
+	for _, v := range q.Variables {
+		declared[v.Name] = v
+	}
+	for name := range given {
+		if _, ok := declared[name]; !ok {
+			return nil, fmt.Errorf("query %q has no variable %q", q.Name, name)
+		}
+	}
+
+	vars := make(map[string]string, len(q.Variables))
+	for _, v := range q.Variables {
+		val, ok := given[v.Name]
+		if !ok || val == "" {
+			val = v.Default
+		}
+		if val == "" && v.Required {
+			return nil, fmt.Errorf("query %q requires the variable %q", q.Name, v.Name)
+		}
+		if v.Pattern != "" && (val != "" || v.Required) {
+			if !regexp.MustCompile(`^(?:` + v.Pattern + `)$`).MatchString(val) {
+				return nil, fmt.Errorf("value %q for variable %q does not match %s", val, v.Name, v.Pattern)
+			}
+		}
+		vars[v.Name] = val
+	}
+	return vars, nil
+}
+
+// CheckInput validates the presence of a values file against Input.
+func (q *Query) CheckInput(hasValues bool) error {
+	switch {
+	case q.Input == InputRequired && !hasValues:
+		return fmt.Errorf("query %q needs a values file", q.Name)
+	case q.Input == InputNone && hasValues:
+		return fmt.Errorf("query %q does not take a values file", q.Name)
+	}
+	return nil
+}
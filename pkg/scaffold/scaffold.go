@@ -0,0 +1,178 @@
+package scaffold
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/NiclasZi/gaspecgen/pkg/loader"
+	"github.com/NiclasZi/gaspecgen/pkg/renderer"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	TypeText    = "text"
+	TypeNumber  = "number"
+	TypeInteger = "integer"
+	TypeDate    = "date"
+	TypeList    = "list"
+)
+
+const (
+	frontMatterStart = "/*---"
+	frontMatterEnd   = "---*/"
+)
+
+// Spec describes the input a template expects. Templates can declare it in
+// a YAML block comment at their very start, the database skips it as a
+// comment:
+//
+//	/*---
+//	title: BOM lookup
+//	description: One row per BOM line
+//	columns:
+//	  - name: artNr
+//	    header: Art. nr
+//	    description: Article number
+//	    example: "100-2001"
+//	    required: true
+//	  - name: qty
+//	    type: number
+//	  - name: unit
+//	    type: list
+//	    values: [pcs, m, kg]
+//	---*/
+type Spec struct {
+	Title       string   `yaml:"title"`
+	Description string   `yaml:"description"`
+	Columns     []Column `yaml:"columns"`
+	// Variables are the .Vars the template reads, they are not part of the
+	// input rows
+	Variables []string `yaml:"-"`
+}
+
+// Column is a single input column.
+type Column struct {
+	// Name is the key the template reads, e.g. artNr for {{ .artNr }}
+	Name string `yaml:"name"`
+	// Header is the header in the input file, defaults to Name
+	Header      string `yaml:"header"`
+	Description string `yaml:"description"`
+	// Type is text, number, integer, date or list, text by default
+	Type     string   `yaml:"type"`
+	Values   []string `yaml:"values"`
+	Example  string   `yaml:"example"`
+	Required bool     `yaml:"required"`
+}
+
+// ParseFrontMatter reads the front-matter at the start of the template, nil
+// when there is none.
+func ParseFrontMatter(tmpl string) (*Spec, error) {
+	rest, ok := strings.CutPrefix(strings.TrimLeft(tmpl, " \t\r\n\ufeff"), frontMatterStart)
+	if !ok {
+		return nil, nil
+	}
+	body, _, ok := strings.Cut(rest, frontMatterEnd)
+	if !ok {
+		return nil, fmt.Errorf("front-matter is not closed with %s", frontMatterEnd)
+	}
+
+	spec := &Spec{}
+	if err := yaml.Unmarshal([]byte(body), spec); err != nil {
+		return nil, fmt.Errorf("invalid front-matter: %w", err)
+	}
+	seen := map[string]bool{}
+	for i := range spec.Columns {
+		c := &spec.Columns[i]
+		if c.Name == "" {
+			return nil, fmt.Errorf("front-matter column %d has no name", i+1)
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("front-matter column %q is declared twice", c.Name)
+		}
+		seen[c.Name] = true
+		if c.Type == "" {
+			c.Type = TypeText
+		}
+		switch c.Type {
+		case TypeText, TypeNumber, TypeInteger, TypeDate:
+		case TypeList:
+			if len(c.Values) == 0 {
+				return nil, fmt.Errorf("front-matter column %q is a list without values", c.Name)
+			}
+		default:
+			return nil, fmt.Errorf("front-matter column %q: invalid type %q, expected text, number, integer, date or list", c.Name, c.Type)
+		}
+	}
+	return spec, nil
+}
+
+// Describe returns the input of the template: the front-matter columns
+// first, followed by the other columns the template reads as text.
+func Describe(tmpl string) (*Spec, error) {
+	spec, err := ParseFrontMatter(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	if spec == nil {
+		spec = &Spec{}
+	}
+
+	fields, err := renderer.RowFields(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+	declared := map[string]bool{}
+	for _, c := range spec.Columns {
+		declared[c.Name] = true
+	}
+	for _, field := range fields {
+		if !declared[field] {
+			spec.Columns = append(spec.Columns, Column{Name: field, Type: TypeText})
+		}
+	}
+
+	spec.Variables, err = renderer.VarFields(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+	return spec, nil
+}
+
+// HeaderOf returns the header of the column in the input file.
+func (c Column) HeaderOf() string {
+	if c.Header != "" {
+		return c.Header
+	}
+	return c.Name
+}
+
+// ExampleOf returns the example value, or one made up from the type.
+func (c Column) ExampleOf() string {
+	if c.Example != "" {
+		return c.Example
+	}
+	switch c.Type {
+	case TypeNumber:
+		return "1.5"
+	case TypeInteger:
+		return "1"
+	case TypeDate:
+		return "2024-01-31"
+	case TypeList:
+		return c.Values[0]
+	}
+	return ""
+}
+
+// Aliases returns the header aliases needed for headers that do not turn
+// into their column name with the default lowerCamel header mode.
+func (s *Spec) Aliases() map[string]string {
+	n := &loader.HeaderNormalizer{Mode: loader.HeadersLowerCamel}
+	aliases := map[string]string{}
+	for _, c := range s.Columns {
+		if n.Key(c.HeaderOf()) != c.Name {
+			aliases[c.HeaderOf()] = c.Name
+		}
+	}
+	return aliases
+}
@@ -0,0 +1,271 @@
+package scaffold
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	InputSheet  = "Input"
+	ReadmeSheet = "README"
+
+	// validatedRows is how far down the data validation reaches
+	validatedRows = 10000
+)
+
+// WriteWorkbook writes an input workbook for the spec: the Input sheet has
+// the header row and validation for the cells below it, the README sheet
+// explains the columns. Input comes first so the loader reads it by default.
+func WriteWorkbook(w io.Writer, spec *Spec) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	if err := f.SetSheetName("Sheet1", InputSheet); err != nil {
+		return err
+	}
+	if err := writeInput(f, spec); err != nil {
+		return fmt.Errorf("failed to write input sheet: %w", err)
+	}
+	if _, err := f.NewSheet(ReadmeSheet); err != nil {
+		return err
+	}
+	if err := writeReadme(f, spec); err != nil {
+		return fmt.Errorf("failed to write readme sheet: %w", err)
+	}
+	f.SetActiveSheet(0)
+	return f.Write(w)
+}
+
+func writeInput(f *excelize.File, spec *Spec) error {
+	header, err := f.NewStyle(&excelize.Style{
+		Font: &excelize.Font{Bold: true},
+		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#DDEBF7"}},
+	})
+	if err != nil {
+		return err
+	}
+	required, err := f.NewStyle(&excelize.Style{
+		Font: &excelize.Font{Bold: true},
+		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#FCE4D6"}},
+	})
+	if err != nil {
+		return err
+	}
+	// text cells keep leading zeros of article numbers and the like
+	text, err := f.NewStyle(&excelize.Style{NumFmt: 49})
+	if err != nil {
+		return err
+	}
+	isoDate := "yyyy-mm-dd"
+	date, err := f.NewStyle(&excelize.Style{CustomNumFmt: &isoDate})
+	if err != nil {
+		return err
+	}
+
+	for i, c := range spec.Columns {
+		col, err := excelize.ColumnNumberToName(i + 1)
+		if err != nil {
+			return err
+		}
+		switch c.Type {
+		case TypeText, TypeList:
+			err = f.SetColStyle(InputSheet, col, text)
+		case TypeDate:
+			err = f.SetColStyle(InputSheet, col, date)
+		}
+		if err != nil {
+			return err
+		}
+		if err := f.SetColWidth(InputSheet, col, col, float64(max(len(c.HeaderOf())+4, 14))); err != nil {
+			return err
+		}
+
+		cell := col + "1"
+		if err := f.SetCellStr(InputSheet, cell, c.HeaderOf()); err != nil {
+			return err
+		}
+		if err := f.SetCellStyle(InputSheet, cell, cell, pick(c.Required, required, header)); err != nil {
+			return err
+		}
+
+		dv, err := validation(c)
+		if err != nil {
+			return fmt.Errorf("column %q: %w", c.Name, err)
+		}
+		if dv == nil {
+			continue
+		}
+		dv.Sqref = fmt.Sprintf("%s2:%s%d", col, col, validatedRows+1)
+		if err := f.AddDataValidation(InputSheet, dv); err != nil {
+			return fmt.Errorf("column %q: %w", c.Name, err)
+		}
+	}
+
+	return f.SetPanes(InputSheet, &excelize.Panes{
+		Freeze:      true,
+		YSplit:      1,
+		TopLeftCell: "A2",
+		ActivePane:  "bottomLeft",
+	})
+}
+
+// validation returns the data validation of the column with the input
+// message showing its description and example, nil when there is nothing to
+// validate or show.
+func validation(c Column) (*excelize.DataValidation, error) {
+	dv := excelize.NewDataValidation(!c.Required)
+
+	var err error
+	switch c.Type {
+	case TypeNumber:
+		err = dv.SetRange(-math.MaxFloat32, math.MaxFloat32, excelize.DataValidationTypeDecimal, excelize.DataValidationOperatorBetween)
+		dv.SetError(excelize.DataValidationErrorStyleStop, "Not a number", "Enter a number.")
+	case TypeInteger:
+		err = dv.SetRange(math.MinInt32, math.MaxInt32, excelize.DataValidationTypeWhole, excelize.DataValidationOperatorBetween)
+		dv.SetError(excelize.DataValidationErrorStyleStop, "Not a whole number", "Enter a whole number.")
+	case TypeDate:
+		// the serial numbers of 1900-01-01 and 9999-12-31
+		err = dv.SetRange(1, 2958465, excelize.DataValidationTypeDate, excelize.DataValidationOperatorBetween)
+		dv.SetError(excelize.DataValidationErrorStyleStop, "Not a date", "Enter a date, e.g. 2024-01-31.")
+	case TypeList:
+		err = dv.SetDropList(c.Values)
+		dv.SetError(excelize.DataValidationErrorStyleStop, "Unknown value", "Pick one of: "+truncate(strings.Join(c.Values, ", "), 200))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var prompt []string
+	if c.Required {
+		prompt = append(prompt, "Required.")
+	}
+	if c.Description != "" {
+		prompt = append(prompt, c.Description)
+	}
+	if example := c.ExampleOf(); example != "" {
+		prompt = append(prompt, "Example: "+example)
+	}
+	if len(prompt) > 0 {
+		// Excel limits the title to 32 and the message to 255 characters
+		dv.SetInput(truncate(c.HeaderOf(), 32), truncate(strings.Join(prompt, " "), 255))
+	}
+	if dv.Type == "" && !dv.ShowInputMessage {
+		return nil, nil
+	}
+	return dv, nil
+}
+
+func writeReadme(f *excelize.File, spec *Spec) error {
+	bold, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	title, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true, Size: 14}})
+	if err != nil {
+		return err
+	}
+
+	row := 1
+	line := func(style int, values ...string) error {
+		for i, v := range values {
+			cell, err := excelize.CoordinatesToCellName(i+1, row)
+			if err != nil {
+				return err
+			}
+			if err := f.SetCellStr(ReadmeSheet, cell, v); err != nil {
+				return err
+			}
+			if style != 0 {
+				if err := f.SetCellStyle(ReadmeSheet, cell, cell, style); err != nil {
+					return err
+				}
+			}
+		}
+		row++
+		return nil
+	}
+
+	lines := [][]string{}
+	if spec.Description != "" {
+		lines = append(lines, []string{spec.Description})
+	}
+	lines = append(lines,
+		[]string{fmt.Sprintf("Fill in the %s sheet with one row per input row below the header row, keep the headers as they are.", InputSheet)},
+		[]string{"Required columns have an orange header, select a cell to see its description and an example."},
+	)
+	if aliases := spec.Aliases(); len(aliases) > 0 {
+		pairs := make([]string, 0, len(aliases))
+		for header, name := range aliases {
+			pairs = append(pairs, header+"="+name)
+		}
+		sort.Strings(pairs)
+		lines = append(lines, []string{fmt.Sprintf("Load the workbook with --header-alias %q, or the header aliases in the web UI.", strings.Join(pairs, ","))})
+	}
+
+	if err := line(title, pick(spec.Title != "", spec.Title, "Input")); err != nil {
+		return err
+	}
+	for _, l := range lines {
+		if err := line(0, l...); err != nil {
+			return err
+		}
+	}
+	row++
+
+	if len(spec.Columns) == 0 {
+		if err := line(0, "The query does not read any input columns."); err != nil {
+			return err
+		}
+	} else {
+		if err := line(bold, "Header", "Column", "Type", "Required", "Example", "Description"); err != nil {
+			return err
+		}
+		for _, c := range spec.Columns {
+			typ := c.Type
+			if c.Type == TypeList {
+				typ += ": " + strings.Join(c.Values, ", ")
+			}
+			if err := line(0, c.HeaderOf(), c.Name, typ, pick(c.Required, "yes", "no"), c.ExampleOf(), c.Description); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(spec.Variables) > 0 {
+		row++
+		if err := line(bold, "Variables"); err != nil {
+			return err
+		}
+		if err := line(0, "The query also takes these values, they are not part of the workbook: "+strings.Join(spec.Variables, ", ")); err != nil {
+			return err
+		}
+	}
+
+	for col, width := range map[string]float64{"A": 24, "B": 18, "C": 16, "D": 10, "E": 16, "F": 60} {
+		if err := f.SetColWidth(ReadmeSheet, col, col, width); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pick returns a when cond holds and b otherwise.
+func pick[T any](cond bool, a, b T) T {
+	if cond {
+		return a
+	}
+	return b
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}